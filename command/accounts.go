@@ -2,14 +2,12 @@ package command
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 
-	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/riotgames/key-conjurer/internal/apiclient"
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2"
 )
@@ -54,53 +52,61 @@ var accountsCmd = &cobra.Command{
 			}
 		}
 
-		accounts, err := refreshAccounts(cmd.Context(), serverAddrURI, &keychainTokenSource{})
+		accounts, etag, err := refreshAccounts(cmd.Context(), serverAddrURI, &keychainTokenSource{}, config.AccountsETag)
+		if errors.Is(err, apiclient.ErrNotModified) {
+			config.DumpAccounts(stdOut, loud)
+			return nil
+		}
+
 		if err != nil {
 			return fmt.Errorf("error refreshing accounts: %w", err)
 		}
 
 		config.UpdateAccounts(accounts)
+		config.AccountsETag = etag
 		config.DumpAccounts(stdOut, loud)
 		return nil
 	},
 }
 
-func refreshAccounts(ctx context.Context, serverAddr *url.URL, ts oauth2.TokenSource) ([]Account, error) {
-	client := oauth2.NewClient(ctx, ts)
-	uri := serverAddr.ResolveReference(&url.URL{Path: "/v2/applications"})
-	req, _ := http.NewRequestWithContext(ctx, "POST", uri.String(), nil)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to issue request: %s", err)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+// refreshAccounts fetches the list of accounts from the server, along with the ETag identifying that version of the list.
+//
+// The v3 API is preferred as it includes metadata about each account, but servers which do not support it are asked for the v2 list instead.
+// If etag is not empty and the list has not changed since it was issued, apiclient.ErrNotModified is returned.
+func refreshAccounts(ctx context.Context, serverAddr *url.URL, ts oauth2.TokenSource, etag string) ([]Account, string, error) {
+	list, err := apiclient.New(ctx, serverAddr, ts).ListApplications(ctx, etag)
 	if err != nil {
-		return nil, fmt.Errorf("could not read body: %s", err)
+		return nil, "", accountsError(err)
 	}
 
-	var jsonError api.JSONError
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != 0 {
-		if err := json.Unmarshal(body, &jsonError); err != nil {
-			return nil, errors.New(jsonError.Message)
-
+	entries := make([]Account, len(list.Applications))
+	for idx, app := range list.Applications {
+		entries[idx] = Account{
+			ID:             app.ID,
+			Name:           app.Label,
+			Alias:          app.Alias,
+			MostRecentRole: app.DefaultRole,
+			Type:           app.Type,
+			AccountIDs:     app.AccountIDs,
+			Category:       app.Category,
+			SortKey:        app.SortKey,
 		}
-		return nil, fmt.Errorf("status code %d", resp.StatusCode)
 	}
 
-	var apps []api.Application
-	if err := json.Unmarshal(body, &apps); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal applications: %w", err)
+	return entries, list.ETag, nil
+}
+
+// accountsError converts errors from the account server into errors with exit codes and messages suitable for the user.
+func accountsError(err error) error {
+	var rateLimitErr *apiclient.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return RateLimitedError(rateLimitErr.RetryAfter)
 	}
 
-	entries := make([]Account, len(apps))
-	for idx, app := range apps {
-		entries[idx] = Account{
-			ID:    app.ID,
-			Name:  app.Name,
-			Alias: generateDefaultAlias(app.Name),
-		}
+	var apiErr *apiclient.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		return ErrTokensExpiredOrAbsent
 	}
 
-	return entries, nil
+	return err
 }
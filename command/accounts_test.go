@@ -0,0 +1,186 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/riotgames/key-conjurer/internal/apiclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshAccounts_SendsIfNoneMatch(t *testing.T) {
+	const etag = `"v1"`
+	// This server predates the v3 API, so the v2 API should be used.
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("POST /v2/applications", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(`[{"@id":"0oa1","name":"AWS - Production Account"}]`))
+	})
+	t.Cleanup(srv.Close)
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+
+	accounts, gotETag, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	require.NoError(t, err)
+	assert.Equal(t, etag, gotETag)
+	assert.Equal(t, []Account{{ID: "0oa1", Name: "AWS - Production Account", Alias: "production-account"}}, accounts)
+
+	_, _, err = refreshAccounts(context.Background(), serverAddr, ts, gotETag)
+	assert.ErrorIs(t, err, apiclient.ErrNotModified)
+}
+
+func TestRefreshAccounts_ReturnsServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"unauthorized"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, _, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	assert.EqualError(t, err, "unauthorized")
+}
+
+func TestRefreshAccounts_PrefersV3(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("GET /v3/applications", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v3"`)
+		w.Write([]byte(`{"applications":[{
+			"id": "0oa1",
+			"label": "AWS - Production Account",
+			"app_name": "amazon_aws",
+			"type": "aws",
+			"alias": "prod",
+			"account_ids": ["123456789012"],
+			"default_role": "ReadOnly",
+			"category": "Production",
+			"sort_key": "01"
+		}]}`))
+	})
+	mux.HandleFunc("POST /v2/applications", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the v2 API should not be used when the v3 API is available")
+	})
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	accounts, etag, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	require.NoError(t, err)
+	assert.Equal(t, `"v3"`, etag)
+	assert.Equal(t, []Account{{
+		ID:             "0oa1",
+		Name:           "AWS - Production Account",
+		Alias:          "prod",
+		MostRecentRole: "ReadOnly",
+		Type:           "aws",
+		AccountIDs:     []string{"123456789012"},
+		Category:       "Production",
+		SortKey:        "01",
+	}}, accounts)
+}
+
+func TestRefreshAccounts_RetriesWhenRateLimited(t *testing.T) {
+	var calls int
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("GET /v3/applications", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"applications":[]}`))
+	})
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, _, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRefreshAccounts_ReportsWhenToRetry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, _, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	assert.EqualError(t, err, "The KeyConjurer server is receiving too many requests. Please try again in 2m0s.")
+
+	code, ok := GetExitCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, ExitCodeConnectivityError, code)
+}
+
+func TestRefreshAccounts_UnauthorizedMeansSessionExpired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"unauthorized"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	serverAddr, _ := url.Parse(srv.URL)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, _, err := refreshAccounts(context.Background(), serverAddr, ts, "")
+	assert.ErrorIs(t, err, ErrTokensExpiredOrAbsent)
+}
+
+func TestRefreshAccounts_EndToEnd(t *testing.T) {
+	okta, ctx := newOktaTestServer(t)
+	provider, err := oidc.NewProvider(ctx, okta.URL)
+	require.NoError(t, err)
+
+	domain, _ := url.Parse(okta.URL)
+	mux := api.NewServeMux(api.ServeUserApplicationsHandler{
+		Okta:   api.NewOktaService(domain, okta.APIToken, okta.Client()),
+		Tokens: api.UserInfoValidator{Provider: provider},
+	})
+	// The account server validates tokens with the userinfo endpoint, which must be reached with a client that trusts the Okta server.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oauth2.HTTPClient, okta.Client())))
+	}))
+	t.Cleanup(srv.Close)
+
+	tok, err := okta.Token(testOktaUser.Login)
+	require.NoError(t, err)
+
+	serverAddr, _ := url.Parse(srv.URL)
+	accounts, etag, err := refreshAccounts(context.Background(), serverAddr, oauth2.StaticTokenSource(tok), "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, []Account{{
+		ID:         "0oa1",
+		Name:       "AWS - Production Account",
+		Alias:      "production-account",
+		Type:       "aws",
+		AccountIDs: []string{},
+		SortKey:    "aws - production account",
+	}}, accounts)
+
+	_, _, err = refreshAccounts(context.Background(), serverAddr, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "invalid"}), "")
+	assert.EqualError(t, err, "unauthorized")
+}
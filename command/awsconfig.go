@@ -1,8 +1,12 @@
 package command
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-ini/ini"
 	homedir "github.com/mitchellh/go-homedir"
@@ -62,15 +66,80 @@ func saveCredentialEntry(file *ini.File, entry CloudCliEntry) error {
 }
 
 func SaveCloudCredentialInCLI(cloudCliPath string, entry CloudCliEntry) error {
+	return SaveCloudCredentialsInCLI(context.Background(), cloudCliPath, entry)
+}
+
+// SaveCloudCredentialsInCLI writes each of the given entries to the aws CLI credentials file in a single write.
+//
+// The credentials file is locked for the duration of the write so that concurrent invocations of KeyConjurer do not overwrite each other's profiles.
+func SaveCloudCredentialsInCLI(ctx context.Context, cloudCliPath string, entries ...CloudCliEntry) error {
 	path := ResolveAWSCredentialsPath(cloudCliPath)
-	file, err := getCloudCliCredentialsFile(path)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	unlock, err := lockCredentialsFile(ctx, path)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	if err := saveCredentialEntry(file, entry); err != nil {
+	file, err := getCloudCliCredentialsFile(path)
+	if err != nil {
 		return err
 	}
 
+	for _, entry := range entries {
+		if err := saveCredentialEntry(file, entry); err != nil {
+			return err
+		}
+	}
+
 	return file.SaveTo(path)
 }
+
+const (
+	// credentialsLockRetryInterval is how long to wait between attempts to acquire the credentials file lock.
+	credentialsLockRetryInterval = 50 * time.Millisecond
+	// credentialsLockTimeout is how long to wait for the credentials file lock before giving up.
+	credentialsLockTimeout = 10 * time.Second
+)
+
+var errCredentialsFileLocked = errors.New("credentials file is locked by another process")
+
+// lockCredentialsFile acquires an exclusive lock on the file at path by locking a lock file next to it.
+//
+// The lock is an advisory lock held by the operating system, so it is released if the process holding it exits without releasing it, and the lock file is left in place.
+// The returned function releases the lock.
+func lockCredentialsFile(ctx context.Context, path string) (func(), error) {
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialsLockTimeout)
+	defer cancel()
+
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+
+		if ok {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, errCredentialsFileLocked)
+		case <-time.After(credentialsLockRetryInterval):
+		}
+	}
+}
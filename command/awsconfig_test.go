@@ -2,6 +2,8 @@ package command
 
 import (
 	"bytes"
+	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-ini/ini"
@@ -48,3 +50,42 @@ func TestAddAWSCliEntry(t *testing.T) {
 		assert.Truef(t, key.Value() == testinivals[idx], "field %s should have value %s\n", inikey, testinivals[idx])
 	}
 }
+
+func TestSaveCloudCredentialsInCLIWritesAllEntries(t *testing.T) {
+	dir := t.TempDir()
+	entries := []CloudCliEntry{
+		{profileName: "first", keyID: "id1", key: "key1", token: "token1"},
+		{profileName: "second", keyID: "id2", key: "key2", token: "token2"},
+	}
+
+	require.NoError(t, SaveCloudCredentialsInCLI(context.Background(), dir, entries...))
+
+	file, err := ini.Load(filepath.Join(dir, "credentials"))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		sec := file.Section(entry.profileName)
+		assert.Equal(t, entry.keyID, sec.Key("aws_access_key_id").Value())
+		assert.Equal(t, entry.key, sec.Key("aws_secret_access_key").Value())
+		assert.Equal(t, entry.token, sec.Key("aws_session_token").Value())
+	}
+
+	unlock, err := lockCredentialsFile(context.Background(), filepath.Join(dir, "credentials"))
+	require.NoError(t, err, "lock should be released after writing")
+	unlock()
+}
+
+func TestLockCredentialsFileWaitsForOtherHolders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	unlock, err := lockCredentialsFile(context.Background(), path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*credentialsLockRetryInterval)
+	t.Cleanup(cancel)
+	_, err = lockCredentialsFile(ctx, path)
+	assert.ErrorIs(t, err, errCredentialsFileLocked)
+
+	unlock()
+	unlock, err = lockCredentialsFile(context.Background(), path)
+	require.NoError(t, err)
+	unlock()
+}
@@ -0,0 +1,275 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v3"
+)
+
+// bulkTarget is a single account and role to fetch credentials for in bulk mode.
+type bulkTarget struct {
+	Account string `yaml:"account"`
+	Role    string `yaml:"role"`
+	// Profile is the name of the aws CLI profile to write the credentials to.
+	// If empty, the alias or name of the account is used.
+	Profile string `yaml:"profile"`
+}
+
+type bulkManifest struct {
+	Accounts []bulkTarget `yaml:"accounts"`
+}
+
+// readBulkManifest reads a list of targets from a YAML manifest.
+func readBulkManifest(r io.Reader) ([]bulkTarget, error) {
+	var manifest bulkManifest
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	for idx, target := range manifest.Accounts {
+		if target.Account == "" {
+			return nil, fmt.Errorf("entry %d is missing an account", idx+1)
+		}
+	}
+
+	return manifest.Accounts, nil
+}
+
+// bulkProfile is a set of credentials and the aws CLI profile they should be written to.
+type bulkProfile struct {
+	Entry       CloudCliEntry
+	Credentials CloudCredentials
+}
+
+type bulkResult struct {
+	Target      bulkTarget
+	Account     *Account
+	Credentials *CloudCredentials
+	Err         error
+}
+
+func (g GetCommand) isBulk() bool {
+	return g.All || g.ManifestPath != ""
+}
+
+// bulkTargets returns the accounts and roles that should be fetched in bulk mode.
+func (g GetCommand) bulkTargets(config *Config) ([]bulkTarget, error) {
+	if g.ManifestPath == "" {
+		var targets []bulkTarget
+		config.Accounts.ForEach(func(id string, _ Account, _ string) {
+			targets = append(targets, bulkTarget{Account: id, Role: g.RoleName})
+		})
+		return targets, nil
+	}
+
+	f, err := os.Open(g.ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	targets, err := readBulkManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %w", g.ManifestPath, err)
+	}
+
+	for idx := range targets {
+		if targets[idx].Role == "" {
+			targets[idx].Role = g.RoleName
+		}
+	}
+
+	return targets, nil
+}
+
+// executeBulk fetches credentials for many accounts at once.
+//
+// OIDC discovery happens once for every account, and failures for individual accounts are reported without preventing credentials being written for the rest.
+func (g GetCommand) executeBulk(ctx context.Context, config *Config) error {
+	targets, err := g.bulkTargets(config)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		g.PrintErrln("There are no accounts to fetch credentials for. Your account cache can be refreshed by executing `keyconjurer accounts`.")
+		return nil
+	}
+
+	if config.TimeRemaining != 0 && g.TimeRemaining == DefaultTimeRemaining {
+		g.TimeRemaining = config.TimeRemaining
+	}
+
+	oauthCfg, err := oauth2cli.DiscoverConfig(ctx, g.OIDCDomain, g.ClientID)
+	if err != nil {
+		return fmt.Errorf("discover oauth2 config: %w", err)
+	}
+
+	// Check the token up front so that we either log in once or fail once, rather than failing for every account.
+	_, err = getAccountCredentialFromKeychain()
+	if errors.Is(err, ErrTokensExpiredOrAbsent) && g.Login {
+		loginCommand := LoginCommand{
+			OIDCDomain:    g.OIDCDomain,
+			ClientID:      g.ClientID,
+			MachineOutput: g.MachineOutput,
+			NoBrowser:     g.NoBrowser,
+		}
+		err = loginCommand.Execute(ctx, config)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	ts := oauth2.ReuseTokenSource(nil, &keychainTokenSource{})
+	results := g.fetchBulkCredentials(ctx, oauthCfg, ts, config, targets)
+
+	var (
+		profiles []bulkProfile
+		failures []error
+		lastUsed string
+	)
+	for _, r := range results {
+		if r.Err != nil {
+			g.PrintErrln(fmt.Sprintf("%s: %s", r.Target.Account, r.Err))
+			failures = append(failures, r.Err)
+			continue
+		}
+
+		r.Account.MostRecentRole = r.Target.Role
+		lastUsed = r.Target.Account
+		entry := NewCloudCliEntry(*r.Credentials, r.Account)
+		if r.Target.Profile != "" {
+			entry.profileName = r.Target.Profile
+		}
+		profiles = append(profiles, bulkProfile{Entry: entry, Credentials: *r.Credentials})
+	}
+
+	if err := g.writeBulkCredentials(ctx, profiles); err != nil {
+		return err
+	}
+
+	// As with a single account, a later get without an account uses the last account credentials were written for.
+	if lastUsed != "" {
+		config.LastUsedAccount = &lastUsed
+	}
+
+	if len(failures) > 0 {
+		code, ok := GetExitCode(failures[0])
+		if !ok {
+			code = ExitCodeUnknownError
+		}
+
+		return genericError{
+			Message:  fmt.Sprintf("failed to fetch credentials for %d of %d accounts", len(failures), len(results)),
+			ExitCode: code,
+		}
+	}
+
+	return nil
+}
+
+// fetchBulkCredentials fetches credentials for each target using at most g.Concurrency workers.
+//
+// The returned results are in the same order as targets.
+func (g GetCommand) fetchBulkCredentials(ctx context.Context, oauthCfg *oauth2.Config, ts oauth2.TokenSource, config *Config, targets []bulkTarget) []bulkResult {
+	results := make([]bulkResult, len(targets))
+	// Accounts are resolved up front because the config is not safe to access from multiple goroutines.
+	for idx, target := range targets {
+		results[idx].Target = target
+		account, ok := resolveApplicationInfo(config, g.BypassCache, target.Account)
+		if !ok {
+			results[idx].Err = UnknownAccountError(target.Account, FlagBypassCache)
+			continue
+		}
+
+		if !account.IsAWS() {
+			results[idx].Err = UnsupportedAccountTypeError(*account)
+			continue
+		}
+
+		results[idx].Account = account
+		if results[idx].Target.Role == "" {
+			results[idx].Target.Role = account.MostRecentRole
+		}
+
+		if results[idx].Target.Role == "" {
+			results[idx].Err = genericError{
+				Message:  fmt.Sprintf("no role was specified and no role has been used with this account before; specify one with --%s or in the manifest", FlagRoleName),
+				ExitCode: ExitCodeValueError,
+			}
+		}
+	}
+
+	environmentCredentials := LoadAWSCredentialsFromEnvironment()
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(int(g.Concurrency), len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				r := &results[idx]
+				if g.canReuseCredentials(environmentCredentials, r.Account) {
+					r.Credentials = &environmentCredentials
+					continue
+				}
+
+				cmd := g
+				cmd.AccountIDOrName = r.Target.Account
+				cmd.RoleName = r.Target.Role
+				r.Credentials, r.Err = cmd.exchangeForCredentials(ctx, oauthCfg, ts, *r.Account, config)
+			}
+		}()
+	}
+
+	for idx := range results {
+		if results[idx].Err == nil {
+			indices <- idx
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	return results
+}
+
+func (g GetCommand) writeBulkCredentials(ctx context.Context, profiles []bulkProfile) error {
+	switch g.OutputType {
+	case outputTypeAWSCredentialsFile:
+		if len(profiles) == 0 {
+			return nil
+		}
+
+		entries := make([]CloudCliEntry, len(profiles))
+		for idx, p := range profiles {
+			entries[idx] = p.Entry
+		}
+		return SaveCloudCredentialsInCLI(ctx, g.AWSCLIPath, entries...)
+	case outputTypeJSON:
+		// JSON output is keyed by profile name so that scripts can find the credentials for each account.
+		creds := make(map[string]CloudCredentials, len(profiles))
+		for _, p := range profiles {
+			creds[p.Entry.profileName] = p.Credentials
+		}
+
+		buf, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(buf))
+		return nil
+	default:
+		return fmt.Errorf("%s is an invalid output type", g.OutputType)
+	}
+}
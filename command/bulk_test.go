@@ -0,0 +1,98 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readBulkManifest(t *testing.T) {
+	doc := `accounts:
+  - account: production
+    role: ReadOnly
+    profile: production-readonly
+  - account: staging
+`
+	targets, err := readBulkManifest(strings.NewReader(doc))
+	require.NoError(t, err)
+	assert.Equal(t, []bulkTarget{
+		{Account: "production", Role: "ReadOnly", Profile: "production-readonly"},
+		{Account: "staging"},
+	}, targets)
+}
+
+func Test_readBulkManifest_RejectsEntriesWithoutAccounts(t *testing.T) {
+	doc := `accounts:
+  - role: ReadOnly
+`
+	_, err := readBulkManifest(strings.NewReader(doc))
+	assert.Error(t, err)
+}
+
+func Test_readBulkManifest_RejectsUnknownFields(t *testing.T) {
+	doc := `accounts:
+  - account: production
+    rol: ReadOnly
+`
+	_, err := readBulkManifest(strings.NewReader(doc))
+	assert.Error(t, err)
+}
+
+func TestGetCommand_bulkTargetsUsesEveryCachedAccount(t *testing.T) {
+	cfg := Config{}
+	cfg.AddAccount("1", Account{ID: "1", Name: "AWS - one"})
+	cfg.AddAccount("2", Account{ID: "2", Name: "AWS - two"})
+
+	g := GetCommand{All: true, RoleName: "Admin"}
+	targets, err := g.bulkTargets(&cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []bulkTarget{
+		{Account: "1", Role: "Admin"},
+		{Account: "2", Role: "Admin"},
+	}, targets)
+}
+
+func TestGetCommand_ParseRejectsAccountsInBulkMode(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().AddFlagSet(getCmd.Flags())
+	cmd.Flags().AddFlagSet(rootCmd.PersistentFlags())
+	t.Cleanup(func() {
+		flag := getCmd.Flags().Lookup(FlagAll)
+		flag.Value.Set("false")
+		flag.Changed = false
+	})
+	require.NoError(t, cmd.Flags().Parse([]string{"--all"}))
+
+	var g GetCommand
+	require.NoError(t, g.Parse(cmd, nil))
+	assert.True(t, g.All)
+
+	err := g.Parse(cmd, []string{"production"})
+	code, _ := GetExitCode(err)
+	assert.Equal(t, ExitCodeValueError, code)
+}
+
+func TestGetCommand_executeBulkRecordsLastUsedAccount(t *testing.T) {
+	g, _, ctx := newLoggedInGetCommand(t)
+	g.All = true
+	g.Concurrency = 1
+	g.OutputType = outputTypeAWSCredentialsFile
+	g.AWSCLIPath = t.TempDir()
+	var stderr []string
+	g.PrintErrln = func(a ...any) { stderr = append(stderr, fmt.Sprint(a...)) }
+
+	config := newTestConfig()
+	config.AddAccount("0oa2", Account{ID: "0oa2", Name: "Salesforce", Type: "salesforce"})
+	require.Error(t, g.Execute(ctx, config), "credentials cannot be fetched for the Salesforce account")
+
+	require.NotNil(t, config.LastUsedAccount)
+	assert.Equal(t, "0oa1", *config.LastUsedAccount)
+	assert.Equal(t, "Admin", config.Accounts.accounts["0oa1"].MostRecentRole)
+	assert.FileExists(t, filepath.Join(g.AWSCLIPath, "credentials"))
+	assert.Len(t, stderr, 1)
+}
@@ -0,0 +1,254 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"log/slog"
+
+	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/riotgames/key-conjurer/internal/apiclient"
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+)
+
+var (
+	FlagCallbackPorts        = "callback-ports"
+	FlagCallbackBindAddress  = "callback-bind-address"
+	FlagCallbackRedirectHost = "callback-redirect-host"
+)
+
+// DefaultCallbackRedirectHost is the host of the redirect URI used to log in, unless another is configured.
+const DefaultCallbackRedirectHost = "localhost"
+
+// loopbackAddrs are the addresses localhost may resolve to.
+//
+// If the redirect host is localhost and no bind address is configured, the callback server listens on each of them that is available, so that logging in works whether the browser prefers IPv4 or IPv6.
+var loopbackAddrs = []string{"127.0.0.1", "::1"}
+
+// parseLoginSettingsFlags returns the login settings given on the command line. Settings which were not given are empty.
+func parseLoginSettingsFlags(flags *pflag.FlagSet) (api.LoginSettings, error) {
+	var settings api.LoginSettings
+	settings.CallbackPorts, _ = flags.GetStringSlice(FlagCallbackPorts)
+	settings.CallbackBindAddress, _ = flags.GetString(FlagCallbackBindAddress)
+	settings.CallbackRedirectHost, _ = flags.GetString(FlagCallbackRedirectHost)
+	if err := settings.Validate(); err != nil {
+		return api.LoginSettings{}, genericError{Message: err.Error(), ExitCode: ExitCodeValueError}
+	}
+
+	return settings, nil
+}
+
+// defaultLoginSettings returns the login settings used when no others are configured.
+//
+// CallbackPortList replaces CallbackPorts if it was set at build time.
+func defaultLoginSettings() api.LoginSettings {
+	ports := CallbackPorts
+	if CallbackPortList != "" {
+		ports = strings.Split(CallbackPortList, ",")
+	}
+
+	return api.LoginSettings{CallbackPorts: ports, CallbackRedirectHost: DefaultCallbackRedirectHost}
+}
+
+// mergeLoginSettings returns the first non-empty value of each setting, so that earlier settings take precedence over later ones.
+func mergeLoginSettings(settings ...api.LoginSettings) api.LoginSettings {
+	var merged api.LoginSettings
+	for _, s := range settings {
+		if len(merged.CallbackPorts) == 0 {
+			merged.CallbackPorts = s.CallbackPorts
+		}
+
+		if merged.CallbackBindAddress == "" {
+			merged.CallbackBindAddress = s.CallbackBindAddress
+		}
+
+		if merged.CallbackRedirectHost == "" {
+			merged.CallbackRedirectHost = s.CallbackRedirectHost
+		}
+	}
+
+	return merged
+}
+
+// fetchLoginSettings retrieves the login settings served by the account server.
+//
+// Logging in must not depend on the account server being available, so empty settings are returned if they cannot be retrieved.
+func fetchLoginSettings(ctx context.Context, serverAddr string) api.LoginSettings {
+	if serverAddr == "" {
+		return api.LoginSettings{}
+	}
+
+	serverAddrURI, err := url.Parse(serverAddr)
+	if err != nil {
+		slog.Debug("invalid server address, not fetching login settings", slog.String("error", err.Error()))
+		return api.LoginSettings{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client := apiclient.Client{BaseURL: serverAddrURI, HTTPClient: oauth2.NewClient(ctx, nil)}
+	settings, err := client.LoginSettings(ctx)
+	if err == nil {
+		err = settings.Validate()
+	}
+
+	if err != nil {
+		slog.Debug("could not fetch login settings from the account server", slog.String("error", err.Error()))
+		return api.LoginSettings{}
+	}
+
+	return settings
+}
+
+// listenForCallback opens the listener for the OAuth2 callback server described by settings, and returns it with the redirect URL that leads to it.
+func listenForCallback(ctx context.Context, settings api.LoginSettings) (net.Listener, string, error) {
+	redirectHost := strings.Trim(settings.CallbackRedirectHost, "[]")
+	addrs := loopbackAddrs
+	if settings.CallbackBindAddress != "" {
+		addrs = []string{strings.Trim(settings.CallbackBindAddress, "[]")}
+	} else if net.ParseIP(redirectHost) != nil {
+		addrs = []string{redirectHost}
+	}
+
+	sock, err := findFirstFreePort(ctx, addrs, settings.CallbackPorts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, port, err := net.SplitHostPort(sock.Addr().String())
+	if err != nil {
+		sock.Close()
+		return nil, "", err
+	}
+
+	return sock, callbackRedirectURL(redirectHost, port), nil
+}
+
+// registeredRedirectURL returns the redirect URL of the first port in settings, without listening for the callback.
+//
+// This is for flows in which the redirect is never followed, which must still use a redirect URI registered with the OIDC application.
+func registeredRedirectURL(settings api.LoginSettings) (string, error) {
+	for _, port := range settings.CallbackPorts {
+		// The port chosen by the operating system cannot be known without listening.
+		if port != "0" {
+			return callbackRedirectURL(strings.Trim(settings.CallbackRedirectHost, "[]"), port), nil
+		}
+	}
+
+	return "", errNoPortsAvailable
+}
+
+func callbackRedirectURL(host, port string) string {
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
+}
+
+// listenOnPort listens on port on the first of addrs, and on the same port on each of the others that is available.
+//
+// A port of 0 listens on a port chosen by the operating system.
+// The other addresses are skipped if they cannot be listened on, such as when IPv6 is disabled, unless another program is already listening on the port.
+func listenOnPort(ctx context.Context, addrs []string, port string) (net.Listener, error) {
+	var lc net.ListenConfig
+	first, err := lc.Listen(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return nil, err
+	}
+
+	// The port is only known once the first address is listened on if the operating system chose it.
+	_, port, _ = net.SplitHostPort(first.Addr().String())
+	listeners := []net.Listener{first}
+	for _, addr := range addrs[1:] {
+		sock, err := lc.Listen(ctx, "tcp", net.JoinHostPort(addr, port))
+		if errors.Is(err, syscall.EADDRINUSE) {
+			// The browser might connect to the other program instead.
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		if err != nil {
+			slog.Debug("could not listen, skipping addr", slog.String("addr", addr), slog.String("error", err.Error()))
+			continue
+		}
+
+		listeners = append(listeners, sock)
+	}
+
+	if len(listeners) == 1 {
+		return first, nil
+	}
+
+	return newMultiListener(listeners...), nil
+}
+
+// multiListener accepts connections from several listeners, so that a single server can serve each of them.
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners ...net.Listener) *multiListener {
+	m := &multiListener{listeners: listeners, conns: make(chan acceptResult), closed: make(chan struct{})}
+	for _, l := range listeners {
+		go m.accept(l)
+	}
+	return m
+}
+
+func (m *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.conns <- acceptResult{conn: conn, err: err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.conns:
+		return r.conn, r.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var errs []error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, l := range m.listeners {
+			errs = append(errs, l.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Addr returns the address of the first listener.
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
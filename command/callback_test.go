@@ -0,0 +1,130 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeLoginSettings(t *testing.T) {
+	flags := api.LoginSettings{CallbackRedirectHost: "127.0.0.1"}
+	config := api.LoginSettings{CallbackPorts: []string{"8080"}, CallbackRedirectHost: "[::1]"}
+	server := api.LoginSettings{CallbackPorts: []string{"9090"}, CallbackBindAddress: "127.0.0.1"}
+
+	merged := mergeLoginSettings(flags, config, server, defaultLoginSettings())
+	assert.Equal(t, api.LoginSettings{CallbackPorts: []string{"8080"}, CallbackBindAddress: "127.0.0.1", CallbackRedirectHost: "127.0.0.1"}, merged)
+	assert.Equal(t, defaultLoginSettings(), mergeLoginSettings(api.LoginSettings{}, defaultLoginSettings()))
+}
+
+func Test_defaultLoginSettings_UsesPortsSetAtBuildTime(t *testing.T) {
+	CallbackPortList = "8080,9090"
+	t.Cleanup(func() { CallbackPortList = "" })
+	assert.Equal(t, []string{"8080", "9090"}, defaultLoginSettings().CallbackPorts)
+}
+
+// serveCallback serves requests on sock, answering each with 204, until the test finishes.
+func serveCallback(t *testing.T, sock net.Listener) {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })}
+	go srv.Serve(sock)
+	t.Cleanup(func() { srv.Close() })
+}
+
+func ipv6Available() bool {
+	sock, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	sock.Close()
+	return true
+}
+
+func Test_listenForCallback_LocalhostListensOnEveryLoopbackAddress(t *testing.T) {
+	sock, redirectURL, err := listenForCallback(context.Background(), api.LoginSettings{CallbackPorts: []string{"0"}, CallbackRedirectHost: "localhost"})
+	require.NoError(t, err)
+	serveCallback(t, sock)
+
+	u, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", u.Hostname())
+	assert.NotEqual(t, "0", u.Port(), "the port chosen by the operating system must be used in the redirect URL")
+
+	addrs := []string{"127.0.0.1"}
+	if ipv6Available() {
+		addrs = append(addrs, "::1")
+	}
+
+	for _, addr := range addrs {
+		resp, err := http.Get(fmt.Sprintf("http://%s/", net.JoinHostPort(addr, u.Port())))
+		require.NoError(t, err, "the callback server must be reachable on %s", addr)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+}
+
+func Test_listenForCallback_IPv6(t *testing.T) {
+	if !ipv6Available() {
+		t.Skip("IPv6 is not available")
+	}
+
+	sock, redirectURL, err := listenForCallback(context.Background(), api.LoginSettings{CallbackPorts: []string{"0"}, CallbackRedirectHost: "[::1]"})
+	require.NoError(t, err)
+	serveCallback(t, sock)
+
+	u, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	assert.Equal(t, "::1", u.Hostname())
+	assert.Equal(t, "::1", sock.Addr().(*net.TCPAddr).IP.String())
+
+	resp, err := http.Get(redirectURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func Test_listenForCallback_BindAddress(t *testing.T) {
+	sock, redirectURL, err := listenForCallback(context.Background(), api.LoginSettings{CallbackPorts: []string{"0"}, CallbackBindAddress: "127.0.0.1", CallbackRedirectHost: "localhost"})
+	require.NoError(t, err)
+	t.Cleanup(func() { sock.Close() })
+
+	assert.IsType(t, &net.TCPListener{}, sock, "only the bind address is listened on")
+	assert.Regexp(t, `^http://localhost:\d+$`, redirectURL)
+}
+
+func Test_listenOnPort_SkipsPortsInUseOnAnyAddress(t *testing.T) {
+	if !ipv6Available() {
+		t.Skip("IPv6 is not available")
+	}
+
+	other, err := net.Listen("tcp", "[::1]:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { other.Close() })
+	_, port, _ := net.SplitHostPort(other.Addr().String())
+
+	_, err = listenOnPort(context.Background(), loopbackAddrs, port)
+	assert.Error(t, err, "another program listening on the port of one of the addresses might receive the callback instead")
+
+	// The port must have been released on the other addresses.
+	sock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	sock.Close()
+}
+
+func Test_multiListener_Close(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	second, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	m := newMultiListener(first, second)
+	require.NoError(t, m.Close())
+	_, err = m.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.NoError(t, m.Close(), "closing twice must not fail")
+}
@@ -0,0 +1,155 @@
+package command
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// clientAssertionType is the client_assertion_type of a private_key_jwt client assertion, as described in RFC 7523.
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime is how long client assertions are valid for. Okta rejects assertions valid for longer than an hour.
+const clientAssertionLifetime = 5 * time.Minute
+
+// DefaultClientCredentialsScopes are the scopes requested by service apps logging in with --client-credentials, unless others are given.
+var DefaultClientCredentialsScopes = []string{"okta.apps.read"}
+
+// readClientKey reads the private key a service app signs its client assertions with.
+//
+// The file may contain a JSON Web Key, as generated by the Okta admin console, or a PEM encoded RSA or EC private key. keyID, if not empty, replaces the ID of the key.
+func readClientKey(path, keyID string) (jose.JSONWebKey, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	var jwk jose.JSONWebKey
+	if block, _ := pem.Decode(buf); block != nil {
+		jwk.Key, err = parsePrivateKey(block)
+	} else if err = json.Unmarshal(buf, &jwk); err == nil && jwk.IsPublic() {
+		err = errors.New("the key is a public key")
+	}
+
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("%s does not contain a private key: %w", path, err)
+	}
+
+	if keyID != "" {
+		jwk.KeyID = keyID
+	}
+
+	jwk.Algorithm, err = signatureAlgorithm(jwk.Key)
+	return jwk, err
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// signatureAlgorithm returns the algorithm client assertions are signed with for key.
+func signatureAlgorithm(key any) (string, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return string(jose.RS256), nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return string(jose.ES256), nil
+		case elliptic.P384():
+			return string(jose.ES384), nil
+		case elliptic.P521():
+			return string(jose.ES512), nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported private key type %T; Okta accepts RSA and EC keys", key)
+}
+
+// clientAssertion returns a private_key_jwt client assertion which authenticates clientID to the token endpoint at tokenURL.
+func clientAssertion(key jose.JSONWebKey, clientID, tokenURL string, now time.Time) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	// The ID of each assertion must be unique, as Okta rejects assertions which are replayed.
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	claims := jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{tokenURL},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// loginWithClientCredentials requests a token for the service app identified by ClientID with the client credentials grant, authenticating with a private_key_jwt assertion.
+//
+// The token is stored in place of the token of a user. Tokens issued to service apps have no ID token, so they cannot be used to retrieve cloud credentials; see ErrServiceAppCannotUseWebSSO.
+func (c LoginCommand) loginWithClientCredentials(ctx context.Context, prov *oidc.Provider) error {
+	key, err := readClientKey(c.PrivateKeyFile, c.PrivateKeyID)
+	if err != nil {
+		return genericError{Message: err.Error(), ExitCode: ExitCodeValueError}
+	}
+
+	tokenURL := prov.Endpoint().TokenURL
+	assertion, err := clientAssertion(key, c.ClientID, tokenURL, time.Now())
+	if err != nil {
+		return fmt.Errorf("sign client assertion: %w", err)
+	}
+
+	cfg := clientcredentials.Config{
+		ClientID: c.ClientID,
+		TokenURL: tokenURL,
+		Scopes:   c.Scopes,
+		EndpointParams: url.Values{
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+		},
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+
+	tok, err := cfg.Token(ctx)
+	if loginErr, ok := tryParseClientCredentialsError(err); ok {
+		return loginErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return putAccountCredentialInKeychain(tok, "")
+}
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func writeTestFile(t *testing.T, name string, buf []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, buf, 0600))
+	return path
+}
+
+func Test_readClientKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	key, err := readClientKey(writeTestFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})), "kid1")
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", key.Algorithm)
+	assert.Equal(t, "kid1", key.KeyID)
+
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	key, err = readClientKey(writeTestFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})), "")
+	require.NoError(t, err)
+	assert.Equal(t, "ES384", key.Algorithm)
+
+	// The key ID of a JSON Web Key is kept unless another is given.
+	jwk, err := json.Marshal(jose.JSONWebKey{Key: rsaKey, KeyID: "okta-generated"})
+	require.NoError(t, err)
+	key, err = readClientKey(writeTestFile(t, "key.json", jwk), "")
+	require.NoError(t, err)
+	assert.Equal(t, "okta-generated", key.KeyID)
+	assert.Equal(t, "RS256", key.Algorithm)
+
+	jwk, err = json.Marshal(jose.JSONWebKey{Key: rsaKey.Public()})
+	require.NoError(t, err)
+	_, err = readClientKey(writeTestFile(t, "key.json", jwk), "")
+	assert.ErrorContains(t, err, "does not contain a private key")
+}
+
+func TestLoginCommand_ClientCredentials(t *testing.T) {
+	keyring.MockInit()
+	srv, ctx := newOktaTestServer(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	srv.AddServiceApp(oktatest.ServiceApp{ClientID: "0oaService", Key: jose.JSONWebKey{Key: ecKey.Public()}, Scopes: DefaultClientCredentialsScopes})
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	require.NoError(t, err)
+	login := LoginCommand{
+		OIDCDomain:        srv.URL,
+		ClientID:          "0oaService",
+		ClientCredentials: true,
+		PrivateKeyFile:    writeTestFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		Scopes:            []string{"okta.users.manage"},
+	}
+
+	err = login.Execute(ctx, &Config{})
+	var loginErr LoginError
+	require.ErrorAs(t, err, &loginErr)
+	assert.Equal(t, ExitCodeValueError, loginErr.ExitCode)
+
+	login.Scopes = DefaultClientCredentialsScopes
+	require.NoError(t, login.Execute(ctx, &Config{}))
+
+	tok, err := getAccountCredentialFromKeychain()
+	require.NoError(t, err)
+	assert.NotEmpty(t, tok.AccessToken)
+	assert.Nil(t, tok.Extra("id_token"))
+
+	// Okta does not issue web SSO tokens to service apps, so the login cannot be used to retrieve credentials.
+	g := GetCommand{OIDCDomain: srv.URL, ClientID: srv.ClientID, Region: DefaultRegion, RoleName: "Admin", TimeToLive: 1, TimeRemaining: DefaultTimeRemaining}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWSKEY_ACCOUNT", "")
+	_, err = g.FetchCredentials(ctx, newTestConfig(), "production")
+	assert.ErrorIs(t, err, ErrServiceAppCannotUseWebSSO)
+}
@@ -8,9 +8,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 
 	"strings"
+
+	"github.com/riotgames/key-conjurer/internal/api"
 )
 
 type Account struct {
@@ -18,6 +21,28 @@ type Account struct {
 	Name           string `json:"name"`
 	Alias          string `json:"alias"`
 	MostRecentRole string `json:"most_recent_role"`
+	// Type is the type of the account reported by the server, such as aws. Servers which predate application types do not report one.
+	Type string `json:"type"`
+	// AccountIDs, Category and SortKey are provided by servers supporting the v3 API.
+	AccountIDs []string `json:"account_ids,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	SortKey    string   `json:"sort_key,omitempty"`
+}
+
+// sortKey returns the key accounts are ordered by when listed.
+func (a *Account) sortKey() string {
+	if a.SortKey != "" {
+		return a.SortKey
+	}
+
+	return a.Name
+}
+
+// IsAWS indicates whether credentials for the account can be retrieved from AWS.
+//
+// Accounts without a type are assumed to be AWS accounts, as they were the only type of account served before types were introduced.
+func (a *Account) IsAWS() bool {
+	return a.Type == "" || a.Type == api.ApplicationTypeAWS
 }
 
 func (a *Account) NormalizeName() string {
@@ -53,13 +78,7 @@ type accountSet struct {
 }
 
 func generateDefaultAlias(name string) string {
-	magicPrefixes := []string{"AWS -"}
-	for _, prefix := range magicPrefixes {
-		name = strings.TrimPrefix(name, prefix)
-		name = strings.TrimSpace(name)
-	}
-
-	return strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+	return api.DefaultAlias(name)
 }
 
 func (a *accountSet) ForEach(f func(id string, account Account, alias string)) {
@@ -70,7 +89,7 @@ func (a *accountSet) ForEach(f func(id string, account Account, alias string)) {
 	}
 
 	sort.SliceStable(accounts, func(i, j int) bool {
-		return accounts[i].Name < accounts[j].Name
+		return accounts[i].sortKey() < accounts[j].sortKey()
 	})
 
 	for _, acc := range accounts {
@@ -153,8 +172,15 @@ func (a *accountSet) ReplaceWith(other []Account) {
 		clone := acc
 		// Preserve the alias if the account ID is the same and it already exists
 		if entry, ok := a.accounts[acc.ID]; ok {
-			// The name is the only thing that might change.
+			// The alias and most recently used role belong to the user, so only the details provided by the server are updated.
 			entry.Name = acc.Name
+			entry.Type = acc.Type
+			entry.AccountIDs = acc.AccountIDs
+			entry.Category = acc.Category
+			entry.SortKey = acc.SortKey
+			if entry.MostRecentRole == "" {
+				entry.MostRecentRole = acc.MostRecentRole
+			}
 		} else {
 			a.accounts[acc.ID] = &clone
 		}
@@ -174,11 +200,15 @@ func (a accountSet) WriteTable(w io.Writer, withHeaders bool) {
 	tbl.Comma = '\t'
 
 	if withHeaders {
-		tbl.Write([]string{"id", "name", "alias"})
+		tbl.Write([]string{"id", "name", "alias", "type"})
 	}
 
 	a.ForEach(func(id string, acc Account, alias string) {
-		tbl.Write([]string{id, acc.Name, alias})
+		accountType := acc.Type
+		if accountType == "" {
+			accountType = api.ApplicationTypeAWS
+		}
+		tbl.Write([]string{id, acc.Name, alias, accountType})
 	})
 
 	tbl.Flush()
@@ -190,6 +220,14 @@ type Config struct {
 	TTL             uint        `json:"ttl"`
 	TimeRemaining   uint        `json:"time_remaining"`
 	LastUsedAccount *string     `json:"last_used_account"`
+	// AccountsETag identifies the version of the account list last received from the server, so that it is only sent again if it has changed.
+	AccountsETag string `json:"accounts_etag"`
+	// PolicyPresets are named session policies that can be used to scope down credentials.
+	PolicyPresets map[string]SessionPolicy `json:"policy_presets"`
+	// RoleChains are named sequences of roles that the switch command assumes in order.
+	RoleChains map[string][]RoleHop `json:"role_chains"`
+	// Login configures how the OAuth2 callback is received when logging in. It takes precedence over the settings provided by the account server.
+	Login api.LoginSettings `json:"login"`
 }
 
 // Encode writes the config to the file provided overwriting the file if it exists
@@ -244,6 +282,15 @@ func (c *Config) Unalias(name string) {
 	acc.Alias = ""
 }
 
+// SetPolicyPreset adds or replaces the session policy with the given name.
+func (c *Config) SetPolicyPreset(name string, policy SessionPolicy) {
+	if c.PolicyPresets == nil {
+		c.PolicyPresets = make(map[string]SessionPolicy)
+	}
+
+	c.PolicyPresets[name] = policy
+}
+
 func (c *Config) FindAccount(name string) (*Account, bool) {
 	if c.Accounts == nil {
 		return &Account{}, false
@@ -257,6 +304,23 @@ func (c *Config) FindAccount(name string) (*Account, bool) {
 	return &Account{}, false
 }
 
+// FindAWSAccount returns the account which grants access to the AWS account with the given ID.
+//
+// Only accounts provided by servers supporting the v3 API list the AWS accounts they grant access to.
+func (c *Config) FindAWSAccount(awsAccountID string) (*Account, bool) {
+	if c.Accounts == nil {
+		return &Account{}, false
+	}
+
+	for _, acc := range c.Accounts.accounts {
+		if slices.Contains(acc.AccountIDs, awsAccountID) {
+			return acc, true
+		}
+	}
+
+	return &Account{}, false
+}
+
 func (c *Config) UpdateAccounts(entries []Account) {
 	c.Accounts.ReplaceWith(entries)
 }
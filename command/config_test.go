@@ -1,10 +1,12 @@
 package command
 
 import (
+	"bytes"
 	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFindAccount(t *testing.T) {
@@ -126,3 +128,29 @@ func TestGeneratesGoodAliases(t *testing.T) {
 		assert.Equal(t, pair[1], generateDefaultAlias(pair[0]))
 	}
 }
+
+func TestAccountTypesAreUpdatedAndShown(t *testing.T) {
+	cfg := Config{}
+	cfg.AddAccount("riot-1", Account{ID: "riot-1", Name: "AWS - riot 1", Alias: "riot-1"})
+	cfg.UpdateAccounts([]Account{
+		{ID: "riot-1", Name: "AWS - riot 1", Type: "aws"},
+		{ID: "riot-2", Name: "Tencent - riot 2", Alias: "riot-2", Type: "tencent"},
+	})
+
+	acc, ok := cfg.FindAccount("riot-1")
+	require.True(t, ok)
+	assert.True(t, acc.IsAWS())
+
+	acc, ok = cfg.FindAccount("riot-2")
+	require.True(t, ok)
+	assert.False(t, acc.IsAWS())
+
+	var buf bytes.Buffer
+	cfg.DumpAccounts(&buf, true)
+	assert.Equal(t, "id\tname\talias\ttype\nriot-1\tAWS - riot 1\triot-1\taws\nriot-2\tTencent - riot 2\triot-2\ttencent\n", buf.String())
+}
+
+func TestAccountsWithoutTypeAreAWS(t *testing.T) {
+	acc := Account{ID: "riot-1"}
+	assert.True(t, acc.IsAWS())
+}
@@ -7,11 +7,17 @@ var (
 	ServerAddress  string
 	Version        = "TBD"
 	BuildTimestamp = "BuildTimestamp is not set"
+	// OrganizationName, OrganizationLogoURL and SupportURL brand the pages shown in the browser after logging in.
+	OrganizationName    string
+	OrganizationLogoURL string
+	SupportURL          string
 	// CallbackPorts is a list of ports that will be attempted in no particular order for hosting an Oauth2 callback web server.
 	// This cannot be set using -ldflags='-X ..' because -X requires that this be a string literal or uninitialized.
 	//
 	// These ports are chosen somewhat arbitrarily
 	CallbackPorts = []string{"57468", "47512", "57123", "61232", "48231", "49757", "59834", "54293"}
+	// CallbackPortList is a comma separated list of ports which replaces CallbackPorts if set, so that the ports can be chosen at build time.
+	CallbackPortList string
 )
 
 const (
@@ -19,4 +25,8 @@ const (
 	DefaultTTL uint = 1
 	// DefaultTimeRemaining for new key requests in minutes
 	DefaultTimeRemaining uint = 5
+	// DefaultRegion is the AWS region used to request credentials if none is specified
+	DefaultRegion = "us-west-2"
+	// DefaultConcurrency is the number of accounts to fetch credentials for at once in bulk mode
+	DefaultConcurrency uint = 4
 )
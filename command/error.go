@@ -7,6 +7,10 @@ import (
 	"time"
 
 	"github.com/aws/smithy-go"
+	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/riotgames/key-conjurer/internal/oktaauthn"
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -16,6 +20,7 @@ const (
 	ExitCodeConnectivityError     int = 0x4
 	ExitCodeValueError            int = 0x5
 	ExitCodeAWSError              int = 0x6
+	ExitCodeAccessDeniedError     int = 0x7
 	ExitCodeUnknownError          int = 0x7D
 )
 
@@ -25,6 +30,12 @@ var (
 		DebugMessage: "tokens expired or absent",
 		Description:  "Your session has expired. Please login again.",
 	}
+	// ErrServiceAppCannotUseWebSSO indicates that the stored token was issued to a service app, which Okta will not exchange for the web SSO token needed to retrieve a SAML assertion.
+	ErrServiceAppCannotUseWebSSO = UsageError{
+		ExitCode:     ExitCodeAuthenticationError,
+		DebugMessage: "stored token has no id token",
+		Description:  "You are logged in as a service app with --client-credentials. Okta only issues the web SSO tokens used to retrieve cloud credentials to users who signed in with OpenID Connect, so credentials cannot be retrieved with this login. Run `keyconjurer login` without --client-credentials to sign in as a user.",
+	}
 )
 
 type genericError struct {
@@ -74,6 +85,22 @@ func UnknownAccountError(accountID, bypassCacheFlag string) error {
 	}
 }
 
+// UnsupportedAccountTypeError indicates that the user asked for credentials for an account that KeyConjurer cannot retrieve credentials for.
+func UnsupportedAccountTypeError(account Account) error {
+	return genericError{
+		Message:  fmt.Sprintf("%s is a %s account. KeyConjurer can only retrieve credentials for %s accounts.", account.Name, account.Type, api.ApplicationTypeAWS),
+		ExitCode: ExitCodeValueError,
+	}
+}
+
+// RateLimitedError indicates that the account server refused a request because too many requests have been made.
+func RateLimitedError(retryAfter time.Duration) error {
+	return genericError{
+		Message:  fmt.Sprintf("The KeyConjurer server is receiving too many requests. Please try again in %s.", retryAfter.Round(time.Second)),
+		ExitCode: ExitCodeConnectivityError,
+	}
+}
+
 type ValueError struct {
 	Value       string
 	ValidValues []string
@@ -169,6 +196,170 @@ func tryParseTimeToLiveError(err error) (error, bool) {
 	return nil, false
 }
 
+// LoginError indicates that the identity provider did not sign the user in, and includes a hint describing what the user can do about it.
+type LoginError struct {
+	InnerError error
+	Message    string
+	Hint       string
+	ExitCode   int
+}
+
+func (o LoginError) Unwrap() error {
+	return o.InnerError
+}
+
+func (o LoginError) Error() string {
+	return o.Message
+}
+
+func (o LoginError) Code() int {
+	return o.ExitCode
+}
+
+// tryParseCallbackError attempts to turn an error returned from the OAuth2 callback into a LoginError.
+//
+// Returns nil and false if the error did not come from the callback.
+func tryParseCallbackError(err error) (error, bool) {
+	var authErr oauth2cli.AuthorizationError
+	switch {
+	case errors.As(err, &authErr):
+		message := authErr.Description
+		if message == "" {
+			message = fmt.Sprintf("Your identity provider returned the error %s.", authErr.Code)
+		} else if !strings.HasSuffix(message, ".") {
+			message += "."
+		}
+
+		loginErr := LoginError{InnerError: err, Message: message}
+		// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+		switch authErr.Code {
+		case "access_denied", "unauthorized_client":
+			loginErr.ExitCode = ExitCodeAccessDeniedError
+			loginErr.Hint = "Ask your administrator to assign you to the KeyConjurer application in Okta."
+		case "login_required", "interaction_required", "consent_required", "account_selection_required":
+			loginErr.ExitCode = ExitCodeAuthenticationError
+			loginErr.Hint = "Sign in to Okta in your browser, then run `keyconjurer login` again."
+		case "invalid_request", "invalid_scope", "invalid_client", "unsupported_response_type":
+			loginErr.ExitCode = ExitCodeValueError
+			loginErr.Hint = fmt.Sprintf("KeyConjurer may be misconfigured. Check the values of --%s and --%s, or contact your administrator.", FlagOIDCDomain, FlagClientID)
+		case "server_error", "temporarily_unavailable":
+			loginErr.ExitCode = ExitCodeUndisclosedOktaError
+			loginErr.Hint = "Okta could not complete your sign in. Please try again in a few minutes."
+		default:
+			loginErr.ExitCode = ExitCodeUndisclosedOktaError
+			loginErr.Hint = "Please run `keyconjurer login` again, or contact your administrator if the problem persists."
+		}
+		return loginErr, true
+	case errors.Is(err, oauth2cli.ErrStateMismatch):
+		return LoginError{
+			InnerError: err,
+			Message:    "The sign in response did not belong to this login.",
+			Hint:       "This can happen when a browser tab from an earlier login is used. Please run `keyconjurer login` again and sign in using the link it gives you.",
+			ExitCode:   ExitCodeAuthenticationError,
+		}, true
+	case errors.Is(err, oauth2cli.ErrMissingCode):
+		return LoginError{
+			InnerError: err,
+			Message:    "The sign in response did not include an authorization code.",
+			Hint:       "Please run `keyconjurer login` again.",
+			ExitCode:   ExitCodeAuthenticationError,
+		}, true
+	}
+
+	return nil, false
+}
+
+// tryParseClientCredentialsError attempts to turn an error from the token endpoint during a client credentials login into a LoginError.
+//
+// Returns nil and false if the error did not come from the token endpoint.
+func tryParseClientCredentialsError(err error) (error, bool) {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return nil, false
+	}
+
+	message := retrieveErr.ErrorDescription
+	if message == "" {
+		message = fmt.Sprintf("Okta returned the error %s.", retrieveErr.ErrorCode)
+	} else if !strings.HasSuffix(message, ".") {
+		message += "."
+	}
+
+	loginErr := LoginError{InnerError: err, Message: message}
+	switch retrieveErr.ErrorCode {
+	case "invalid_client":
+		loginErr.ExitCode = ExitCodeAuthenticationError
+		loginErr.Hint = fmt.Sprintf("Check that --%s is the client ID of your service app, that it uses public key authentication, and that the public key of --%s is registered with it.", FlagClientID, FlagPrivateKeyFile)
+	case "invalid_scope":
+		loginErr.ExitCode = ExitCodeValueError
+		loginErr.Hint = fmt.Sprintf("Check that the scopes given with --%s have been granted to your service app.", FlagScopes)
+	case "unauthorized_client", "unsupported_grant_type":
+		loginErr.ExitCode = ExitCodeValueError
+		loginErr.Hint = "Check that the Client Credentials grant type is enabled for your service app."
+	default:
+		loginErr.ExitCode = ExitCodeUndisclosedOktaError
+		loginErr.Hint = "Please try again, or contact your administrator if the problem persists."
+	}
+
+	return loginErr, true
+}
+
+// tryParseAuthnError attempts to turn an error from the Okta authentication API during a terminal login into a LoginError.
+//
+// Returns nil and false if the error did not come from the authentication API.
+func tryParseAuthnError(err error) (error, bool) {
+	var apiErr *oktaauthn.Error
+	switch {
+	case errors.Is(err, oktaauthn.ErrPushRejected):
+		return LoginError{
+			InnerError: err,
+			Message:    "The push notification was rejected.",
+			Hint:       "If you did not try to log in, contact your administrator.",
+			ExitCode:   ExitCodeAccessDeniedError,
+		}, true
+	case errors.Is(err, oktaauthn.ErrPushTimeout):
+		return LoginError{
+			InnerError: err,
+			Message:    "The push notification was not answered in time.",
+			Hint:       "Please run `keyconjurer login` again.",
+			ExitCode:   ExitCodeAuthenticationError,
+		}, true
+	case errors.As(err, &apiErr):
+		loginErr := LoginError{InnerError: err, ExitCode: ExitCodeAuthenticationError}
+		// https://developer.okta.com/docs/reference/error-codes/
+		switch apiErr.Code {
+		case "E0000004":
+			loginErr.Message = "Okta could not sign you in with that username and password."
+			loginErr.Hint = fmt.Sprintf("Check your username and password, or log in without --%s to sign in with a browser.", FlagTerminal)
+		case "E0000068":
+			loginErr.Message = "The code was not accepted."
+			loginErr.Hint = "Check that the clock of the device showing the code is correct, then run `keyconjurer login` again with a new code."
+		case "E0000011":
+			loginErr.Message = "The login took too long and expired."
+			loginErr.Hint = "Please run `keyconjurer login` again."
+		default:
+			loginErr.Message = apiErr.Summary
+			if !strings.HasSuffix(loginErr.Message, ".") {
+				loginErr.Message += "."
+			}
+			loginErr.Hint = "Please try again, or contact your administrator if the problem persists."
+			loginErr.ExitCode = ExitCodeUndisclosedOktaError
+		}
+		return loginErr, true
+	}
+
+	return nil, false
+}
+
+// GetHint returns the hint of a LoginError in the chain of err, which describes what the user can do about it.
+func GetHint(err error) (string, bool) {
+	var loginErr LoginError
+	if errors.As(err, &loginErr) && loginErr.Hint != "" {
+		return loginErr.Hint, true
+	}
+	return "", false
+}
+
 func GetExitCode(err error) (int, bool) {
 	var codeError codeError
 	if errors.As(err, &codeError) {
@@ -1,10 +1,13 @@
 package command
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/aws/smithy-go"
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
 	"github.com/stretchr/testify/require"
 )
 
@@ -43,3 +46,63 @@ func Test_tryParseTimeToLiveError(t *testing.T) {
 		require.Equal(t, ttlError.Code(), ExitCodeValueError)
 	})
 }
+
+func Test_tryParseCallbackError(t *testing.T) {
+	t.Run("AccessDenied", func(t *testing.T) {
+		authErr := oauth2cli.AuthorizationError{Code: "access_denied", Description: "User is not assigned to the client application."}
+		err, ok := tryParseCallbackError(fmt.Errorf("wrapped: %w", authErr))
+
+		require.True(t, ok)
+		require.Equal(t, "User is not assigned to the client application.", err.Error())
+		hint, ok := GetHint(err)
+		require.True(t, ok)
+		require.Equal(t, "Ask your administrator to assign you to the KeyConjurer application in Okta.", hint)
+		code, _ := GetExitCode(err)
+		require.Equal(t, ExitCodeAccessDeniedError, code)
+		require.ErrorIs(t, err, oauth2cli.ErrBadRequest)
+	})
+
+	t.Run("NoDescription", func(t *testing.T) {
+		err, ok := tryParseCallbackError(oauth2cli.AuthorizationError{Code: "temporarily_unavailable"})
+
+		require.True(t, ok)
+		require.Contains(t, err.Error(), "Your identity provider returned the error temporarily_unavailable.")
+		code, _ := GetExitCode(err)
+		require.Equal(t, ExitCodeUndisclosedOktaError, code)
+	})
+
+	t.Run("Misconfigured", func(t *testing.T) {
+		err, ok := tryParseCallbackError(oauth2cli.AuthorizationError{Code: "invalid_scope", Description: "The authentication request has an invalid 'scope' parameter."})
+
+		require.True(t, ok)
+		hint, _ := GetHint(err)
+		require.Contains(t, hint, "--"+FlagClientID)
+		code, _ := GetExitCode(err)
+		require.Equal(t, ExitCodeValueError, code)
+	})
+
+	t.Run("StateMismatch", func(t *testing.T) {
+		err, ok := tryParseCallbackError(oauth2cli.ErrStateMismatch)
+
+		require.True(t, ok)
+		hint, _ := GetHint(err)
+		require.Contains(t, hint, "earlier login")
+		code, _ := GetExitCode(err)
+		require.Equal(t, ExitCodeAuthenticationError, code)
+	})
+
+	t.Run("MissingCode", func(t *testing.T) {
+		err, ok := tryParseCallbackError(oauth2cli.ErrMissingCode)
+
+		require.True(t, ok)
+		code, _ := GetExitCode(err)
+		require.Equal(t, ExitCodeAuthenticationError, code)
+	})
+
+	t.Run("Unrelated", func(t *testing.T) {
+		err, ok := tryParseCallbackError(errors.New("discover provider: connection refused"))
+
+		require.False(t, ok)
+		require.Nil(t, err)
+	})
+}
@@ -11,8 +11,10 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/riotgames/key-conjurer/internal/oktawebsso"
 	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
 	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
 )
 
 var (
@@ -22,6 +24,12 @@ var (
 	FlagTimeToLive    = "ttl"
 	FlagBypassCache   = "bypass-cache"
 	FlagLogin         = "login"
+	FlagAll           = "all"
+	FlagManifest      = "manifest"
+	FlagConcurrency   = "concurrency"
+	FlagPolicyFile    = "policy-file"
+	FlagPolicyARN     = "policy-arn"
+	FlagPolicyPreset  = "policy-preset"
 )
 
 var (
@@ -32,10 +40,12 @@ var (
 	outputTypeJSON               = "json"
 	permittedOutputTypes         = []string{outputTypeAWSCredentialsFile, outputTypeEnvironmentVariable, outputTypeJSON}
 	permittedShellTypes          = []string{shellTypePowershell, shellTypeBash, shellTypeBasic, shellTypeInfer}
+	// permittedBulkOutputTypes is the subset of output types that can hold credentials for more than one account.
+	permittedBulkOutputTypes = []string{outputTypeAWSCredentialsFile, outputTypeJSON}
 )
 
 func init() {
-	getCmd.Flags().String(FlagRegion, "us-west-2", "The AWS region to use")
+	getCmd.Flags().String(FlagRegion, DefaultRegion, "The AWS region to use")
 	getCmd.Flags().Uint(FlagTimeToLive, 1, "The key timeout in hours from 1 to 8.")
 	getCmd.Flags().UintP(FlagTimeRemaining, "t", DefaultTimeRemaining, "Request new keys if there are no keys in the environment or the current keys expire within <time-remaining> minutes. Defaults to 60.")
 	getCmd.Flags().StringP(FlagRoleName, "r", "", "The name of the role to assume.")
@@ -47,6 +57,13 @@ func init() {
 	getCmd.Flags().String(FlagAWSCLIPath, "~/.aws/", "Path for directory used by the aws CLI")
 	getCmd.Flags().BoolP(FlagURLOnly, "u", false, "Print only the URL to visit rather than a user-friendly message")
 	getCmd.Flags().BoolP(FlagNoBrowser, "b", false, "Do not open a browser window, printing the URL instead")
+	getCmd.Flags().Bool(FlagAll, false, "Fetch credentials for every account in your account cache, using the most recently used role for each account unless --role is specified.")
+	getCmd.Flags().String(FlagManifest, "", "Path to a YAML file listing the accounts, roles and profile names to fetch credentials for.")
+	getCmd.Flags().Uint(FlagConcurrency, DefaultConcurrency, "When fetching credentials for many accounts, the maximum number of accounts to fetch credentials for at once.")
+	getCmd.Flags().String(FlagPolicyFile, "", "Path to a JSON IAM policy used to scope down the permissions of the credentials.")
+	getCmd.Flags().StringSlice(FlagPolicyARN, nil, "The ARN of a managed IAM policy used to scope down the permissions of the credentials. May be specified more than once.")
+	getCmd.Flags().String(FlagPolicyPreset, "", "The name of a policy preset from your config used to scope down the permissions of the credentials.")
+	getCmd.MarkFlagsMutuallyExclusive(FlagAll, FlagManifest)
 }
 
 func resolveApplicationInfo(cfg *Config, bypassCache bool, nameOrID string) (*Account, bool) {
@@ -62,6 +79,19 @@ type GetCommand struct {
 	TimeRemaining                                                             uint
 	OutputType, ShellType, RoleName, AWSCLIPath, OIDCDomain, ClientID, Region string
 	Login, URLOnly, NoBrowser, BypassCache, MachineOutput                     bool
+	// AWSEndpointURL, if set, is used as the STS endpoint instead of the default endpoint of Region.
+	AWSEndpointURL string
+
+	// All and ManifestPath select bulk mode, in which credentials for many accounts are fetched at once.
+	All          bool
+	ManifestPath string
+	Concurrency  uint
+
+	PolicyFile, PolicyPreset string
+	PolicyARNs               []string
+
+	// sessionPolicy is resolved from PolicyFile, PolicyPreset and PolicyARNs when the command is executed.
+	sessionPolicy SessionPolicy
 
 	UsageFunc  func() error
 	PrintErrln func(...any)
@@ -82,9 +112,32 @@ func (g *GetCommand) Parse(cmd *cobra.Command, args []string) error {
 	g.NoBrowser, _ = flags.GetBool(FlagNoBrowser)
 	g.BypassCache, _ = flags.GetBool(FlagBypassCache)
 	g.Region, _ = flags.GetString(FlagRegion)
+	g.AWSEndpointURL = awsEndpointURL(flags)
 	g.UsageFunc = cmd.Usage
 	g.PrintErrln = cmd.PrintErrln
 	g.MachineOutput = ShouldUseMachineOutput(flags) || g.URLOnly
+	g.All, _ = flags.GetBool(FlagAll)
+	g.ManifestPath, _ = flags.GetString(FlagManifest)
+	g.Concurrency, _ = flags.GetUint(FlagConcurrency)
+	g.PolicyFile, _ = flags.GetString(FlagPolicyFile)
+	g.PolicyPreset, _ = flags.GetString(FlagPolicyPreset)
+	g.PolicyARNs, _ = flags.GetStringSlice(FlagPolicyARN)
+	if g.isBulk() {
+		// Writing many sets of credentials to environment variables makes no sense, so bulk mode defaults to the awscli output instead.
+		if !flags.Changed(FlagOutputType) {
+			g.OutputType = outputTypeAWSCredentialsFile
+		}
+
+		if len(args) > 0 {
+			return UsageError{
+				ExitCode:     ExitCodeValueError,
+				DebugMessage: "account given with bulk flags",
+				Description:  fmt.Sprintf("An account cannot be given with --%s or --%s, which choose the accounts to fetch credentials for.", FlagAll, FlagManifest),
+			}
+		}
+		return nil
+	}
+
 	if len(args) == 0 {
 		return fmt.Errorf("account name or alias is required")
 	}
@@ -100,6 +153,19 @@ func (g GetCommand) Validate() error {
 	if !slices.Contains(permittedShellTypes, g.ShellType) {
 		return ValueError{Value: g.ShellType, ValidValues: permittedShellTypes}
 	}
+
+	if g.isBulk() {
+		if !slices.Contains(permittedBulkOutputTypes, g.OutputType) {
+			return ValueError{Value: g.OutputType, ValidValues: permittedBulkOutputTypes}
+		}
+
+		if g.Concurrency == 0 {
+			return genericError{
+				ExitCode: ExitCodeValueError,
+				Message:  fmt.Sprintf("--%s must be greater than zero", FlagConcurrency),
+			}
+		}
+	}
 	return nil
 }
 
@@ -108,25 +174,57 @@ func (g GetCommand) printUsage() error {
 }
 
 func (g GetCommand) Execute(ctx context.Context, config *Config) error {
-	var accountID string
-	if g.AccountIDOrName != "" {
-		accountID = g.AccountIDOrName
-	} else if config.LastUsedAccount != nil {
+	var err error
+	g.sessionPolicy, err = resolveSessionPolicy(config, g.PolicyPreset, g.PolicyFile, g.PolicyARNs)
+	if err != nil {
+		return err
+	}
+
+	if g.isBulk() {
+		return g.executeBulk(ctx, config)
+	}
+
+	accountID := g.AccountIDOrName
+	if accountID == "" {
+		if config.LastUsedAccount == nil {
+			return g.printUsage()
+		}
 		// No account specified. Can we use the most recent one?
 		accountID = *config.LastUsedAccount
-	} else {
-		return g.printUsage()
 	}
 
+	credentials, err := g.FetchCredentials(ctx, config, accountID)
+	if errors.Is(err, errRoleRequired) {
+		g.PrintErrln("You must specify the --role flag with this command")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return echoCredentials(accountID, accountID, credentials, g.OutputType, g.ShellType, g.AWSCLIPath)
+}
+
+var errRoleRequired = errors.New("role required")
+
+// FetchCredentials returns credentials for g.RoleName in the given account, logging in first if necessary and permitted by g.Login.
+//
+// Credentials in the environment are returned instead if they are for the same account and will not expire soon.
+// The account and role are recorded in the config as the most recently used.
+func (g GetCommand) FetchCredentials(ctx context.Context, config *Config, accountID string) (CloudCredentials, error) {
 	account, ok := resolveApplicationInfo(config, g.BypassCache, accountID)
 	if !ok {
-		return UnknownAccountError(g.AccountIDOrName, FlagBypassCache)
+		return CloudCredentials{}, UnknownAccountError(accountID, FlagBypassCache)
+	}
+
+	if !account.IsAWS() {
+		return CloudCredentials{}, UnsupportedAccountTypeError(*account)
 	}
 
 	if g.RoleName == "" {
 		if account.MostRecentRole == "" {
-			g.PrintErrln("You must specify the --role flag with this command")
-			return nil
+			return CloudCredentials{}, errRoleRequired
 		}
 		g.RoleName = account.MostRecentRole
 	}
@@ -136,7 +234,7 @@ func (g GetCommand) Execute(ctx context.Context, config *Config) error {
 	}
 
 	credentials := LoadAWSCredentialsFromEnvironment()
-	if !credentials.ValidUntil(account, time.Duration(g.TimeRemaining)*time.Minute) {
+	if !g.canReuseCredentials(credentials, account) {
 		newCredentials, err := g.fetchNewCredentials(ctx, *account, config)
 		if errors.Is(err, ErrTokensExpiredOrAbsent) && g.Login {
 			loginCommand := LoginCommand{
@@ -147,28 +245,49 @@ func (g GetCommand) Execute(ctx context.Context, config *Config) error {
 			}
 			err = loginCommand.Execute(ctx, config)
 			if err != nil {
-				return err
+				return CloudCredentials{}, err
 			}
 			newCredentials, err = g.fetchNewCredentials(ctx, *account, config)
 		}
 
 		if err != nil {
-			return err
+			return CloudCredentials{}, err
 		}
 
 		credentials = *newCredentials
 	}
 
-	if account != nil {
-		account.MostRecentRole = g.RoleName
+	account.MostRecentRole = g.RoleName
+	config.LastUsedAccount = &accountID
+	return credentials, nil
+}
+
+// canReuseCredentials indicates whether the credentials in the environment can be used instead of fetching new ones.
+//
+// Credentials in the environment are never reused when a session policy is requested, as there is no way to tell whether they were scoped down in the same way.
+func (g GetCommand) canReuseCredentials(credentials CloudCredentials, account *Account) bool {
+	if !g.sessionPolicy.IsEmpty() {
+		return false
 	}
 
-	config.LastUsedAccount = &accountID
-	return echoCredentials(accountID, accountID, credentials, g.OutputType, g.ShellType, g.AWSCLIPath)
+	return credentials.ValidUntil(account, time.Duration(g.TimeRemaining)*time.Minute)
 }
 
 func (g GetCommand) fetchNewCredentials(ctx context.Context, account Account, cfg *Config) (*CloudCredentials, error) {
-	samlResponse, assertionStr, err := oauth2cli.DiscoverConfigAndExchangeTokenForAssertion(ctx, &keychainTokenSource{}, g.OIDCDomain, g.ClientID, account.ID)
+	oauthCfg, err := oauth2cli.DiscoverConfig(ctx, g.OIDCDomain, g.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("discover oauth2 config: %w", err)
+	}
+
+	return g.exchangeForCredentials(ctx, oauthCfg, &keychainTokenSource{}, account, cfg)
+}
+
+// exchangeForCredentials exchanges the users Okta session for credentials to g.RoleName in the given account.
+func (g GetCommand) exchangeForCredentials(ctx context.Context, oauthCfg *oauth2.Config, ts oauth2.TokenSource, account Account, cfg *Config) (*CloudCredentials, error) {
+	samlResponse, assertionStr, err := oauth2cli.ExchangeTokenForAssertion(ctx, oauthCfg, ts, g.OIDCDomain, account.ID)
+	if errors.Is(err, oktawebsso.ErrNotOIDCToken) {
+		return nil, ErrServiceAppCannotUseWebSSO
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -182,13 +301,20 @@ func (g GetCommand) fetchNewCredentials(ctx context.Context, account Account, cf
 		g.TimeToLive = cfg.TTL
 	}
 
-	stsClient := sts.New(sts.Options{Region: g.Region})
+	stsOptions := sts.Options{Region: g.Region}
+	if g.AWSEndpointURL != "" {
+		stsOptions.BaseEndpoint = aws.String(g.AWSEndpointURL)
+	}
+
+	stsClient := sts.New(stsOptions)
 	timeoutInSeconds := int32(3600 * g.TimeToLive)
 	resp, err := stsClient.AssumeRoleWithSAML(ctx, &sts.AssumeRoleWithSAMLInput{
 		DurationSeconds: aws.Int32(timeoutInSeconds),
 		PrincipalArn:    aws.String(pair.ProviderARN),
 		RoleArn:         aws.String(pair.RoleARN),
 		SAMLAssertion:   aws.String(assertionStr),
+		Policy:          g.sessionPolicy.policyDocument(),
+		PolicyArns:      g.sessionPolicy.policyDescriptors(),
 	})
 
 	if err, ok := tryParseTimeToLiveError(err); ok {
@@ -212,11 +338,22 @@ func (g GetCommand) fetchNewCredentials(ctx context.Context, account Account, cf
 }
 
 var getCmd = &cobra.Command{
-	Use:   "get <accountName/alias>",
+	Use:   "get [accountName/alias]",
 	Short: "Retrieves temporary cloud API credentials.",
-	Long: `Retrieves temporary cloud API credentials for the specified account.  It sends a push request to the first Duo device it finds associated with your account.
+	Long: `Retrieves temporary cloud API credentials for the specified account. You must have logged in with the login command first.
+
+A role must be specified when using this command through the --role flag. You may list the roles you can assume through the roles command.
+
+Credentials for many accounts can be fetched at once with --all, which uses every account in your account cache, or --manifest, which reads a YAML file of the following form:
+
+  accounts:
+    - account: production
+      role: ReadOnly
+      profile: production-readonly
+
+Bulk fetches write to the aws CLI credentials file by default.
 
-A role must be specified when using this command through the --role flag. You may list the roles you can assume through the roles command.`,
+The permissions of the credentials can be scoped down with --policy-file, --policy-arn and --policy-preset. Presets are created with the "set policy-preset" command.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var getCmd GetCommand
 		if err := getCmd.Parse(cmd, args); err != nil {
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/riotgames/key-conjurer/internal/ststest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+// newLoggedInGetCommand logs in to a local Okta organization and returns a GetCommand which exchanges its assertions with a local STS endpoint.
+func newLoggedInGetCommand(t *testing.T) (GetCommand, *ststest.Server, context.Context) {
+	keyring.MockInit()
+	okta, ctx := newOktaTestServer(t)
+	login := LoginCommand{OIDCDomain: okta.URL, ClientID: okta.ClientID, openURL: okta.Browse}
+	require.NoError(t, login.Execute(ctx, &Config{}))
+
+	stsServer := ststest.NewServer()
+	t.Cleanup(stsServer.Close)
+
+	// Credentials in the environment would otherwise be reused.
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWSKEY_ACCOUNT", "")
+
+	g := GetCommand{
+		OIDCDomain:     okta.URL,
+		ClientID:       okta.ClientID,
+		Region:         DefaultRegion,
+		AWSEndpointURL: stsServer.URL,
+		RoleName:       "Admin",
+		TimeToLive:     1,
+		TimeRemaining:  DefaultTimeRemaining,
+	}
+	return g, stsServer, ctx
+}
+
+func newTestConfig() *Config {
+	var config Config
+	config.AddAccount("0oa1", Account{ID: "0oa1", Name: "AWS - Production Account", Alias: "production", Type: "aws"})
+	return &config
+}
+
+func TestGetCommand_FetchCredentials(t *testing.T) {
+	g, stsServer, ctx := newLoggedInGetCommand(t)
+	config := newTestConfig()
+
+	creds, err := g.FetchCredentials(ctx, config, "production")
+	require.NoError(t, err)
+	assert.Equal(t, "0oa1", creds.AccountID)
+	assert.NotEmpty(t, creds.SessionToken)
+
+	calls := stsServer.Calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, "AssumeRoleWithSAML", calls[0].Action)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin", calls[0].Params.Get("RoleArn"))
+	assert.Equal(t, "arn:aws:iam::123456789012:saml-provider/Okta", calls[0].Params.Get("PrincipalArn"))
+	assert.Equal(t, "3600", calls[0].Params.Get("DurationSeconds"))
+	assert.Equal(t, "Admin", config.Accounts.accounts["0oa1"].MostRecentRole)
+}
+
+func TestGetCommand_FetchCredentialsReportsTimeToLiveErrors(t *testing.T) {
+	g, stsServer, ctx := newLoggedInGetCommand(t)
+	g.TimeToLive = 2
+
+	_, err := g.FetchCredentials(ctx, newTestConfig(), "production")
+	assert.Equal(t, TimeToLiveError{}, err, "the role only permits sessions of an hour")
+
+	stsServer.FailNext("AssumeRoleWithSAML", ststest.DurationSecondsError(2*time.Hour))
+	_, err = g.FetchCredentials(ctx, newTestConfig(), "production")
+	assert.Equal(t, TimeToLiveError{MaxDuration: 12 * time.Hour, RequestedDuration: 2 * time.Hour}, err)
+}
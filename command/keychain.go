@@ -40,6 +40,11 @@ func getAccountCredentialFromKeychain() (*oauth2.Token, error) {
 		// bad JSON format
 		return nil, ErrTokensExpiredOrAbsent
 	}
+	// Tokens issued to service apps with --client-credentials have no ID token.
+	if tok.IDToken == "" {
+		return &tok.Token, nil
+	}
+
 	// This is how we expect to find the ID token in the access token.
 	// Hacky, but this is also how OAuth2 APIs communicate it
 	extra := map[string]any{"id_token": tok.IDToken}
@@ -7,11 +7,13 @@ import (
 	"io"
 	"net"
 	"os"
+	"time"
 
 	"log/slog"
 
 	"github.com/coreos/go-oidc"
 	"github.com/pkg/browser"
+	"github.com/riotgames/key-conjurer/internal/api"
 	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -27,17 +29,41 @@ func init() {
 var (
 	FlagURLOnly   = "url-only"
 	FlagNoBrowser = "no-browser"
+	FlagQRCode    = "qr"
+
+	FlagClientCredentials = "client-credentials"
+	FlagPrivateKeyFile    = "private-key-file"
+	FlagPrivateKeyID      = "private-key-id"
+	FlagScopes            = "scopes"
+
+	FlagTerminal        = "terminal"
+	FlagUsername        = "username"
+	FlagMFAFactor       = "mfa-factor"
+	FlagExperimentalDuo = "experimental-duo"
 )
 
 func init() {
 	loginCmd.Flags().BoolP(FlagURLOnly, "u", false, "Print only the URL to visit rather than a user-friendly message")
 	loginCmd.Flags().BoolP(FlagNoBrowser, "b", false, "Do not open a browser window, printing the URL instead")
+	loginCmd.Flags().Bool(FlagQRCode, false, "Draw the URL as a QR code which can be scanned to log in on another device. Enabled by default with --no-browser when the output is a terminal")
+	loginCmd.Flags().Bool(FlagClientCredentials, false, "Log in as an Okta service app using the client credentials grant, instead of as a user in a browser. --client-id must be the client ID of the service app")
+	loginCmd.Flags().String(FlagPrivateKeyFile, "", "With --client-credentials, the private key of the service app, as a JSON Web Key or a PEM file")
+	loginCmd.Flags().String(FlagPrivateKeyID, "", "With --client-credentials, the key ID of the private key, if the key file does not include one")
+	loginCmd.Flags().StringSlice(FlagScopes, DefaultClientCredentialsScopes, "With --client-credentials, the scopes to request")
+	loginCmd.Flags().Bool(FlagTerminal, false, "Log in by entering your Okta username, password and MFA in the terminal, instead of in a browser. For hosts where no browser is available")
+	loginCmd.Flags().String(FlagUsername, "", "With --terminal, the Okta username to log in as. Prompted for if not given")
+	loginCmd.Flags().String(FlagMFAFactor, "", "With --terminal, the MFA factor to verify: push, totp or duo. Prompted for if you have enrolled more than one")
+	loginCmd.Flags().Bool(FlagExperimentalDuo, false, "With --terminal, allow Duo to be verified. Experimental: this uses undocumented Duo endpoints which may stop working, and always sends the push notification to your first Duo device")
+	loginCmd.Flags().String(FlagServerAddress, ServerAddress, "The address of the account server, which may provide settings for logging in. This does not usually need to be changed or specified.")
+	loginCmd.Flags().StringSlice(FlagCallbackPorts, nil, "The ports to listen on for the OAuth2 callback, in order of preference. 0 lets the operating system choose a port, if your identity provider allows it")
+	loginCmd.Flags().String(FlagCallbackBindAddress, "", "The IP address to listen on for the OAuth2 callback, such as 127.0.0.1 or ::1. Defaults to the redirect host if it is an IP address, or every loopback address otherwise")
+	loginCmd.Flags().String(FlagCallbackRedirectHost, "", "The host of the redirect URI, such as localhost, 127.0.0.1 or [::1]. Defaults to localhost")
 }
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "Authenticate with KeyConjurer.",
-	Long:  "Login to KeyConjurer using OAuth2. You will be required to open the URL printed to the console or scan a QR code.",
+	Long:  "Login to KeyConjurer using OAuth2. You will be required to open the URL printed to the console or scan a QR code.\n\nOn hosts with no browser, --terminal prompts for your Okta username, password and MFA instead.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var loginCmd LoginCommand
 		if err := loginCmd.Parse(cmd.Flags(), args); err != nil {
@@ -63,15 +89,86 @@ type LoginCommand struct {
 	ClientID      string
 	MachineOutput bool
 	NoBrowser     bool
+	// QRCode draws the URL to visit as a QR code.
+	QRCode bool
+	// ClientCredentials logs in as the service app identified by ClientID using the client credentials grant, authenticating with the key in PrivateKeyFile.
+	ClientCredentials bool
+	PrivateKeyFile    string
+	PrivateKeyID      string
+	// Scopes are the scopes requested with the client credentials grant.
+	Scopes []string
+	// Terminal logs in with the Okta authentication API, prompting for the username, password and MFA of the user in the terminal.
+	Terminal bool
+	Username string
+	// MFAFactor is the kind of MFA factor to verify with Terminal, one of the keys of mfaFactorTypes. The user is prompted to choose one if empty.
+	MFAFactor string
+	// ExperimentalDuo allows Duo factors to be verified with Terminal. Duo has no API for this, so the endpoints of its legacy web frame are used, which may stop working at any time.
+	ExperimentalDuo bool
+	// ServerAddress is the address of the account server, which may provide settings for logging in.
+	ServerAddress string
+	// Settings are the login settings given on the command line. They take precedence over those in the config and those provided by the account server.
+	Settings api.LoginSettings
+
+	// openURL, if set, is called with the authorization URL instead of opening a browser or printing it.
+	openURL func(url string) error
+	// terminal, if set, is used to prompt the user instead of standard input and standard error.
+	terminal *terminal
+	// pollInterval, if set, replaces how often push notifications are polled.
+	pollInterval time.Duration
 }
 
 func (c *LoginCommand) Parse(flags *pflag.FlagSet, args []string) error {
 	c.OIDCDomain, _ = flags.GetString(FlagOIDCDomain)
 	c.ClientID, _ = flags.GetString(FlagClientID)
 	c.NoBrowser, _ = flags.GetBool(FlagNoBrowser)
+	c.ServerAddress, _ = flags.GetString(FlagServerAddress)
 	urlOnly, _ := flags.GetBool(FlagURLOnly)
 	c.MachineOutput = ShouldUseMachineOutput(flags) || urlOnly
-	return nil
+	c.QRCode, _ = flags.GetBool(FlagQRCode)
+	if !flags.Changed(FlagQRCode) {
+		// A user who is not opening a browser on this machine may well be logging in from their phone.
+		c.QRCode = c.NoBrowser && !c.MachineOutput
+	}
+
+	c.ClientCredentials, _ = flags.GetBool(FlagClientCredentials)
+	c.PrivateKeyFile, _ = flags.GetString(FlagPrivateKeyFile)
+	c.PrivateKeyID, _ = flags.GetString(FlagPrivateKeyID)
+	c.Scopes, _ = flags.GetStringSlice(FlagScopes)
+	if c.ClientCredentials && c.PrivateKeyFile == "" {
+		return genericError{
+			ExitCode: ExitCodeValueError,
+			Message:  fmt.Sprintf("--%s must be specified with --%s", FlagPrivateKeyFile, FlagClientCredentials),
+		}
+	}
+
+	c.Terminal, _ = flags.GetBool(FlagTerminal)
+	c.Username, _ = flags.GetString(FlagUsername)
+	c.MFAFactor, _ = flags.GetString(FlagMFAFactor)
+	c.ExperimentalDuo, _ = flags.GetBool(FlagExperimentalDuo)
+	if c.Terminal && c.ClientCredentials {
+		return genericError{
+			ExitCode: ExitCodeValueError,
+			Message:  fmt.Sprintf("--%s cannot be used with --%s", FlagTerminal, FlagClientCredentials),
+		}
+	}
+
+	if _, ok := mfaFactorTypes[c.MFAFactor]; c.MFAFactor != "" && !ok {
+		return genericError{
+			ExitCode: ExitCodeValueError,
+			Message:  fmt.Sprintf("--%s must be one of push, totp or duo", FlagMFAFactor),
+		}
+	}
+
+	if c.MFAFactor == "duo" && !c.ExperimentalDuo {
+		return genericError{
+			ExitCode: ExitCodeValueError,
+			Message:  fmt.Sprintf("Verifying Duo from the terminal is experimental, and must be enabled with --%s", FlagExperimentalDuo),
+		}
+	}
+
+	var err error
+	c.Settings, err = parseLoginSettingsFlags(flags)
+	return err
 }
 
 func (c LoginCommand) Execute(ctx context.Context, config *Config) error {
@@ -89,42 +186,79 @@ func (c LoginCommand) Execute(ctx context.Context, config *Config) error {
 		}
 	}
 
+	if c.QRCode {
+		// The URL alone must be written to stdout for machines.
+		qrOut := os.Stdout
+		if c.MachineOutput {
+			qrOut = os.Stderr
+		}
+		serveURL = withQRCode(serveURL, qrOut)
+	}
+
+	if c.openURL != nil {
+		serveURL = c.openURL
+	}
+
 	prov, err := oidc.NewProvider(ctx, c.OIDCDomain)
 	if err != nil {
 		return fmt.Errorf("discover provider: %w", err)
 	}
 
-	sock, err := findFirstFreePort(ctx, "127.0.0.1", CallbackPorts)
+	if c.ClientCredentials {
+		return c.loginWithClientCredentials(ctx, prov)
+	}
+
+	settings := mergeLoginSettings(c.Settings, config.Login, fetchLoginSettings(ctx, c.ServerAddress), defaultLoginSettings())
+	if c.Terminal {
+		redirectURL, err := registeredRedirectURL(settings)
+		if err != nil {
+			return err
+		}
+
+		return c.loginWithTerminal(ctx, prov, c.oauth2Config(prov, redirectURL))
+	}
+
+	sock, redirectURL, err := listenForCallback(ctx, settings)
 	if err != nil {
 		return err
 	}
 	defer sock.Close()
-	_, port, err := net.SplitHostPort(sock.Addr().String())
+
+	cfg := c.oauth2Config(prov, redirectURL)
+
+	pages := oauth2cli.CallbackPages{
+		Branding: oauth2cli.Branding{OrganizationName: OrganizationName, LogoURL: OrganizationLogoURL, SupportURL: SupportURL},
+	}
+	handler := oauth2cli.NewAuthorizationCodeHandler(cfg, serveURL, oauth2cli.WithCallbackPages(pages))
+	accessToken, err := handler.HandlePendingSession(ctx, sock)
+	if loginErr, ok := tryParseCallbackError(err); ok {
+		return loginErr
+	}
 	if err != nil {
-		// Failed to split the host and port. We need the port to continue, so bail
 		return err
 	}
 
-	cfg := oauth2.Config{
+	return c.storeToken(ctx, prov, accessToken)
+}
+
+func (c LoginCommand) oauth2Config(prov *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
 		ClientID:    c.ClientID,
 		Endpoint:    prov.Endpoint(),
 		Scopes:      []string{oidc.ScopeOpenID, "profile", "okta.apps.read", "okta.apps.sso"},
-		RedirectURL: fmt.Sprintf("http://%s", net.JoinHostPort("localhost", port)),
-	}
-
-	handler := oauth2cli.NewAuthorizationCodeHandler(&cfg, serveURL)
-	accessToken, err := handler.HandlePendingSession(ctx, sock)
-	if err != nil {
-		return err
+		RedirectURL: redirectURL,
 	}
+}
 
+// storeToken verifies the ID token issued to the user with accessToken, then stores both in the keychain.
+func (c LoginCommand) storeToken(ctx context.Context, prov *oidc.Provider, accessToken *oauth2.Token) error {
 	// https://openid.net/specs/openid-connect-core-1_0.html#TokenResponse
 	idToken, ok := accessToken.Extra("id_token").(string)
 	if !ok {
 		return fmt.Errorf("id_token not found in token response")
 	}
 
-	_, err = prov.Verifier(&oidc.Config{ClientID: c.ClientID}).Verify(ctx, idToken)
+	_, err := prov.Verifier(&oidc.Config{ClientID: c.ClientID}).Verify(ctx, idToken)
 	if err != nil {
 		return fmt.Errorf("validate id token: %w", err)
 	}
@@ -134,22 +268,20 @@ func (c LoginCommand) Execute(ctx context.Context, config *Config) error {
 
 var errNoPortsAvailable = errors.New("no ports available")
 
-// findFirstFreePort will attempt to open a network listener for each port in turn, and return the first one that succeeded.
+// findFirstFreePort will attempt to open a network listener on addrs for each port in turn, and return the first one that succeeded.
 //
 // If none succeed, ErrNoPortsAvailable is returned.
 //
 // This is useful for supporting OIDC servers that do not allow for ephemeral ports to be used in the loopback address, like Okta.
-func findFirstFreePort(ctx context.Context, broadcastAddr string, ports []string) (net.Listener, error) {
-	var lc net.ListenConfig
+func findFirstFreePort(ctx context.Context, addrs []string, ports []string) (net.Listener, error) {
 	for _, port := range ports {
-		addr := net.JoinHostPort(broadcastAddr, port)
-		slog.Debug("opening connection", slog.String("addr", addr))
-		sock, err := lc.Listen(ctx, "tcp4", addr)
+		slog.Debug("opening connection", slog.Any("addrs", addrs), slog.String("port", port))
+		sock, err := listenOnPort(ctx, addrs, port)
 		if err == nil {
-			slog.Debug("listening", slog.String("addr", addr))
+			slog.Debug("listening", slog.String("addr", sock.Addr().String()))
 			return sock, nil
 		}
-		slog.Debug("could not listen, trying a different addr", slog.String("addr", addr), slog.String("error", err.Error()))
+		slog.Debug("could not listen, trying a different port", slog.String("port", port), slog.String("error", err.Error()))
 	}
 
 	return nil, errNoPortsAvailable
@@ -5,10 +5,67 @@ import (
 	"net"
 	"testing"
 
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
 )
 
+var testOktaUser = oktatest.User{
+	ID:     "00u1",
+	Login:  "user@example.com",
+	Groups: []string{"Everyone"},
+	Applications: []oktatest.Application{{
+		ID:          "0oa1",
+		Label:       "AWS - Production Account",
+		ProviderARN: "arn:aws:iam::123456789012:saml-provider/Okta",
+		RoleARNs:    []string{"arn:aws:iam::123456789012:role/Admin", "arn:aws:iam::123456789012:role/ReadOnly"},
+	}},
+}
+
+// newOktaTestServer returns a local Okta organization with testOktaUser signed in, and a context whose HTTP client trusts it.
+func newOktaTestServer(t *testing.T) (*oktatest.Server, context.Context) {
+	srv := oktatest.NewServer("0oaKeyConjurer", testOktaUser)
+	t.Cleanup(srv.Close)
+	return srv, context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+}
+
+func TestLoginCommand_EndToEnd(t *testing.T) {
+	keyring.MockInit()
+	srv, ctx := newOktaTestServer(t)
+
+	login := LoginCommand{OIDCDomain: srv.URL, ClientID: srv.ClientID, openURL: srv.Browse}
+	require.NoError(t, login.Execute(ctx, &Config{}))
+
+	// The stored credential can be exchanged for a SAML assertion for any of the applications of the user.
+	oauthCfg, err := oauth2cli.DiscoverConfig(ctx, srv.URL, srv.ClientID)
+	require.NoError(t, err)
+	response, _, err := oauth2cli.ExchangeTokenForAssertion(ctx, oauthCfg, &keychainTokenSource{}, srv.URL, "0oa1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Admin", "ReadOnly"}, listRoles(response))
+
+	pair, ok := findRoleInSAML("readonly", response)
+	require.True(t, ok)
+	assert.Equal(t, roleProviderPair{RoleARN: "arn:aws:iam::123456789012:role/ReadOnly", ProviderARN: "arn:aws:iam::123456789012:saml-provider/Okta"}, pair)
+}
+
+func TestLoginCommand_SignedOut(t *testing.T) {
+	keyring.MockInit()
+	srv, ctx := newOktaTestServer(t)
+	require.NoError(t, srv.SignIn(""))
+
+	login := LoginCommand{OIDCDomain: srv.URL, ClientID: srv.ClientID, openURL: srv.Browse}
+	err := login.Execute(ctx, &Config{})
+	var loginErr LoginError
+	require.ErrorAs(t, err, &loginErr)
+	assert.Equal(t, ExitCodeAuthenticationError, loginErr.Code())
+
+	_, err = getAccountCredentialFromKeychain()
+	assert.ErrorIs(t, err, ErrTokensExpiredOrAbsent)
+}
+
 func Test_findFirstFreePort_WorksCorrectly(t *testing.T) {
 	ports := []string{"58080", "58081", "58082", "58083"}
 	socket, err := net.Listen("tcp4", net.JoinHostPort("127.0.0.1", ports[0]))
@@ -17,7 +74,7 @@ func Test_findFirstFreePort_WorksCorrectly(t *testing.T) {
 	})
 	require.NoError(t, err, "Could not open socket on port: %s", ports[0])
 
-	openedSocket, err := findFirstFreePort(context.Background(), "127.0.0.1", ports)
+	openedSocket, err := findFirstFreePort(context.Background(), []string{"127.0.0.1"}, ports)
 	assert.NoError(t, err)
 	_, port, err := net.SplitHostPort(openedSocket.Addr().String())
 	assert.NoError(t, err)
@@ -28,7 +85,7 @@ func Test_findFirstFreePort_WorksCorrectly(t *testing.T) {
 
 func Test_findFirstFreePort_RejectsIfNoPortsAvailable(t *testing.T) {
 	var ports []string
-	_, err := findFirstFreePort(context.Background(), "127.0.0.1", ports)
+	_, err := findFirstFreePort(context.Background(), []string{"127.0.0.1"}, ports)
 	assert.ErrorIs(t, errNoPortsAvailable, err)
 }
 
@@ -53,6 +110,6 @@ func Test_findFirstFreePort_RejectsIfAllProvidedPortsExhausted(t *testing.T) {
 		}
 	})
 
-	_, err := findFirstFreePort(context.Background(), "127.0.0.1", activePorts)
+	_, err := findFirstFreePort(context.Background(), []string{"127.0.0.1"}, activePorts)
 	assert.ErrorIs(t, err, errNoPortsAvailable)
 }
@@ -0,0 +1,144 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// maxSessionPolicyARNs is the maximum number of managed policies STS will accept as session policies.
+const maxSessionPolicyARNs = 10
+
+// SessionPolicy scopes down the permissions of credentials returned by STS.
+//
+// The resulting credentials are allowed only the intersection of the permissions of the role and the session policy.
+type SessionPolicy struct {
+	// Policy is an inline IAM policy document in JSON format.
+	Policy string `json:"policy"`
+	// PolicyARNs is a list of ARNs of managed IAM policies.
+	PolicyARNs []string `json:"policy_arns"`
+}
+
+// IsEmpty indicates whether the session policy would restrict credentials at all.
+func (p SessionPolicy) IsEmpty() bool {
+	return p.Policy == "" && len(p.PolicyARNs) == 0
+}
+
+// Merge returns a session policy which has the inline policy of other if set, or p otherwise, and the managed policies of both.
+func (p SessionPolicy) Merge(other SessionPolicy) SessionPolicy {
+	merged := SessionPolicy{Policy: p.Policy}
+	if other.Policy != "" {
+		merged.Policy = other.Policy
+	}
+
+	merged.PolicyARNs = append(merged.PolicyARNs, p.PolicyARNs...)
+	merged.PolicyARNs = append(merged.PolicyARNs, other.PolicyARNs...)
+	return merged
+}
+
+// Validate checks that the session policy is well formed before it is sent to STS.
+func (p SessionPolicy) Validate() error {
+	if p.Policy != "" && !json.Valid([]byte(p.Policy)) {
+		return genericError{
+			Message:  "the session policy is not a valid JSON document",
+			ExitCode: ExitCodeValueError,
+		}
+	}
+
+	if len(p.PolicyARNs) > maxSessionPolicyARNs {
+		return genericError{
+			Message:  fmt.Sprintf("you specified %d policy ARNs, but at most %d may be used", len(p.PolicyARNs), maxSessionPolicyARNs),
+			ExitCode: ExitCodeValueError,
+		}
+	}
+
+	for _, policyARN := range p.PolicyARNs {
+		if parsed, err := arn.Parse(policyARN); err != nil || parsed.Service != "iam" {
+			return genericError{
+				Message:  fmt.Sprintf("%q is not a valid IAM policy ARN", policyARN),
+				ExitCode: ExitCodeValueError,
+			}
+		}
+	}
+
+	return nil
+}
+
+// policyDocument returns the inline policy in the format expected by the STS API.
+func (p SessionPolicy) policyDocument() *string {
+	if p.Policy == "" {
+		return nil
+	}
+
+	return aws.String(p.Policy)
+}
+
+// policyDescriptors returns the managed policies in the format expected by the STS API.
+func (p SessionPolicy) policyDescriptors() []types.PolicyDescriptorType {
+	var descriptors []types.PolicyDescriptorType
+	for _, policyARN := range p.PolicyARNs {
+		descriptors = append(descriptors, types.PolicyDescriptorType{Arn: aws.String(policyARN)})
+	}
+
+	return descriptors
+}
+
+// ReadSessionPolicy builds a session policy from an inline policy file and a list of managed policy ARNs.
+//
+// Either argument may be empty.
+func ReadSessionPolicy(policyFile string, policyARNs []string) (SessionPolicy, error) {
+	policy := SessionPolicy{PolicyARNs: policyARNs}
+	if policyFile == "" {
+		return policy, nil
+	}
+
+	buf, err := os.ReadFile(policyFile)
+	if err != nil {
+		return policy, fmt.Errorf("read policy file: %w", err)
+	}
+
+	// Compacting the policy makes better use of the limited space STS allows for session policies.
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, buf); err != nil {
+		return policy, genericError{
+			Message:  fmt.Sprintf("%s is not a valid JSON document: %s", policyFile, err),
+			ExitCode: ExitCodeValueError,
+		}
+	}
+
+	policy.Policy = compacted.String()
+	return policy, nil
+}
+
+// UnknownPolicyPresetError indicates that the user asked for a preset that is not in their config.
+func UnknownPolicyPresetError(name string) error {
+	return genericError{
+		Message:  fmt.Sprintf("%q is not a known policy preset. Presets can be added with `keyconjurer set policy-preset`.", name),
+		ExitCode: ExitCodeValueError,
+	}
+}
+
+// resolveSessionPolicy combines the preset, policy file and policy ARNs given to a command into a single session policy.
+func resolveSessionPolicy(cfg *Config, presetName, policyFile string, policyARNs []string) (SessionPolicy, error) {
+	var policy SessionPolicy
+	if presetName != "" {
+		preset, ok := cfg.PolicyPresets[presetName]
+		if !ok {
+			return policy, UnknownPolicyPresetError(presetName)
+		}
+		policy = preset
+	}
+
+	flagPolicy, err := ReadSessionPolicy(policyFile, policyARNs)
+	if err != nil {
+		return policy, err
+	}
+
+	policy = policy.Merge(flagPolicy)
+	return policy, policy.Validate()
+}
@@ -0,0 +1,68 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSessionPolicyCompactsPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	doc := `{
+	"Version": "2012-10-17",
+	"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]
+}`
+	require.NoError(t, os.WriteFile(path, []byte(doc), 0600))
+
+	policy, err := ReadSessionPolicy(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`, policy.Policy)
+}
+
+func TestReadSessionPolicyRejectsInvalidJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	_, err := ReadSessionPolicy(path, nil)
+	code, ok := GetExitCode(err)
+	require.True(t, ok)
+	assert.Equal(t, ExitCodeValueError, code)
+}
+
+func TestResolveSessionPolicyMergesPresetWithFlags(t *testing.T) {
+	cfg := Config{}
+	cfg.SetPolicyPreset("readonly", SessionPolicy{
+		Policy:     `{"Version":"2012-10-17"}`,
+		PolicyARNs: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	})
+
+	policy, err := resolveSessionPolicy(&cfg, "readonly", "", []string{"arn:aws:iam::1234:policy/Extra"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"Version":"2012-10-17"}`, policy.Policy)
+	assert.Equal(t, []string{"arn:aws:iam::aws:policy/ReadOnlyAccess", "arn:aws:iam::1234:policy/Extra"}, policy.PolicyARNs)
+	assert.Len(t, policy.policyDescriptors(), 2)
+}
+
+func TestResolveSessionPolicyRejectsUnknownPresets(t *testing.T) {
+	cfg := Config{}
+	_, err := resolveSessionPolicy(&cfg, "readonly", "", nil)
+	assert.Error(t, err)
+}
+
+func TestSessionPolicyValidateRejectsBadARNs(t *testing.T) {
+	policy := SessionPolicy{PolicyARNs: []string{"ReadOnlyAccess"}}
+	assert.Error(t, policy.Validate())
+
+	policy = SessionPolicy{PolicyARNs: []string{"arn:aws:s3:::bucket"}}
+	assert.Error(t, policy.Validate())
+}
+
+func TestEmptySessionPolicyIsNotSentToSTS(t *testing.T) {
+	var policy SessionPolicy
+	assert.True(t, policy.IsEmpty())
+	assert.Nil(t, policy.policyDocument())
+	assert.Nil(t, policy.policyDescriptors())
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"bufio"
+	"io"
+
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the number of light modules drawn around a QR code so that scanners can find its edges.
+const qrQuietZone = 2
+
+// writeQRCode draws text as a QR code using Unicode half blocks, so that each line of output holds two rows of modules.
+//
+// Light modules are drawn and dark modules are left blank, so the code scans correctly on terminals with a dark background, as most do.
+func writeQRCode(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+
+	light := func(x, y int) bool { return !code.Black(x, y) }
+	bw := bufio.NewWriter(w)
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			switch top, bottom := light(x, y), light(x, y+1) && y+1 < code.Size+qrQuietZone; {
+			case top && bottom:
+				bw.WriteString("█")
+			case top:
+				bw.WriteString("▀")
+			case bottom:
+				bw.WriteString("▄")
+			default:
+				bw.WriteString(" ")
+			}
+		}
+		bw.WriteString("\n")
+	}
+
+	return bw.Flush()
+}
+
+// withQRCode returns a function which calls serveURL, then draws the URL it was given as a QR code to w.
+func withQRCode(serveURL func(string) error, w io.Writer) func(string) error {
+	return func(url string) error {
+		if err := serveURL(url); err != nil {
+			return err
+		}
+
+		return writeQRCode(w, url)
+	}
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"rsc.io/qr"
+)
+
+func Test_writeQRCode(t *testing.T) {
+	const url = "https://example.okta.com/oauth2/v1/authorize?client_id=0oa1&code_challenge=E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM&code_challenge_method=S256&redirect_uri=http%3A%2F%2Flocalhost%3A57468&response_type=code&scope=openid+profile+okta.apps.read+okta.apps.sso&state=9pBs_x2wQfa8a1yGm3V0mAq5mXl2O3oIYy0lcTy6F1Y"
+	var buf bytes.Buffer
+	require.NoError(t, writeQRCode(&buf, url))
+
+	code, err := qr.Encode(url, qr.L)
+	require.NoError(t, err)
+	size := code.Size + 2*qrQuietZone
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, (size+1)/2, "each line holds two rows of modules")
+
+	// Decode the half blocks back into modules to compare them with the code.
+	for row, line := range lines {
+		require.Equal(t, size, utf8.RuneCountInString(line))
+		for col, r := range []rune(line) {
+			x, y := col-qrQuietZone, 2*row-qrQuietZone
+			top := r == '█' || r == '▀'
+			bottom := r == '█' || r == '▄'
+			assert.Equal(t, !code.Black(x, y), top, "module (%d, %d)", x, y)
+			if y+1 < code.Size+qrQuietZone {
+				assert.Equal(t, !code.Black(x, y+1), bottom, "module (%d, %d)", x, y+1)
+			}
+		}
+	}
+
+	assert.Equal(t, strings.Repeat("█", size), lines[0], "the quiet zone must be light")
+}
+
+func TestLoginCommand_ParseQRCode(t *testing.T) {
+	// The flags are shared with loginCmd, so the ones used here must be reset between parses.
+	resetFlags := func() {
+		for _, name := range []string{FlagQRCode, FlagNoBrowser, FlagURLOnly} {
+			flag := loginCmd.Flags().Lookup(name)
+			flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+		}
+	}
+	t.Cleanup(resetFlags)
+
+	newFlags := func(args ...string) *pflag.FlagSet {
+		resetFlags()
+		flags := pflag.NewFlagSet("login", pflag.ContinueOnError)
+		flags.AddFlagSet(loginCmd.Flags())
+		flags.AddFlagSet(rootCmd.PersistentFlags())
+		require.NoError(t, flags.Parse(args))
+		return flags
+	}
+
+	var c LoginCommand
+	require.NoError(t, c.Parse(newFlags("--qr"), nil))
+	assert.True(t, c.QRCode)
+
+	// The QR code is only drawn automatically for people, not scripts reading the URL.
+	require.NoError(t, c.Parse(newFlags("--no-browser", "--url-only"), nil))
+	assert.False(t, c.QRCode)
+
+	require.NoError(t, c.Parse(newFlags("--no-browser", "--qr=false"), nil))
+	assert.False(t, c.QRCode)
+}
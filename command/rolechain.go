@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// RoleHop describes a single AssumeRole call made by the switch command.
+//
+// A sequence of hops forms a role chain, where each hop is made using the credentials returned by the hop before it.
+type RoleHop struct {
+	// Role is either the ARN of the role to assume, or the name of a role in AccountID.
+	// If empty, a role with the same name as the callers current role is assumed.
+	Role      string `json:"role"`
+	AccountID string `json:"account_id"`
+	// ExternalID is required by some roles which are assumed by third parties.
+	ExternalID string `json:"external_id"`
+	// SerialNumber is the ARN or serial number of the MFA device required to assume the role, if any.
+	SerialNumber      string            `json:"mfa_serial"`
+	SourceIdentity    string            `json:"source_identity"`
+	RoleSessionName   string            `json:"role_session_name"`
+	Tags              map[string]string `json:"tags"`
+	TransitiveTagKeys []string          `json:"transitive_tag_keys"`
+}
+
+// roleARN resolves the ARN of the role this hop assumes.
+//
+// The current identity of the caller is requested unless the hop gives the ARN of its role, as the partition of the role is taken from it.
+func (h RoleHop) roleARN(callerIdentity func() (arn.ARN, error)) (string, error) {
+	if arn.IsARN(h.Role) {
+		return h.Role, nil
+	}
+
+	if h.AccountID == "" {
+		return "", genericError{
+			Message:  fmt.Sprintf("an account ID is required to assume the role %q", h.Role),
+			ExitCode: ExitCodeValueError,
+		}
+	}
+
+	caller, err := callerIdentity()
+	if err != nil {
+		return "", err
+	}
+
+	roleName := h.Role
+	if roleName == "" {
+		// The resource of the caller is either user/<name> or assumed-role/<name>/<session>
+		parts := strings.Split(caller.Resource, "/")
+		if len(parts) < 2 {
+			return "", fmt.Errorf("could not determine the role name from %s", caller)
+		}
+
+		roleName = parts[1]
+	}
+
+	return arn.ARN{
+		Partition: caller.Partition,
+		Service:   "iam",
+		AccountID: h.AccountID,
+		Resource:  fmt.Sprintf("role/%s", roleName),
+	}.String(), nil
+}
+
+// assumeRoleInput builds the STS request for this hop.
+func (h RoleHop) assumeRoleInput(roleARN, defaultRoleSessionName, tokenCode string) *sts.AssumeRoleInput {
+	sessionName := h.RoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	input := sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(sessionName),
+	}
+
+	if h.ExternalID != "" {
+		input.ExternalId = aws.String(h.ExternalID)
+	}
+
+	if h.SerialNumber != "" {
+		input.SerialNumber = aws.String(h.SerialNumber)
+		input.TokenCode = aws.String(tokenCode)
+	}
+
+	if h.SourceIdentity != "" {
+		input.SourceIdentity = aws.String(h.SourceIdentity)
+	}
+
+	// Tags are sorted so that requests are deterministic.
+	keys := make([]string, 0, len(h.Tags))
+	for key := range h.Tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(h.Tags[key])})
+	}
+
+	input.TransitiveTagKeys = h.TransitiveTagKeys
+	return &input
+}
+
+// UnknownRoleChainError indicates that the user asked for a role chain that is not in their config.
+func UnknownRoleChainError(name string) error {
+	return genericError{
+		Message:  fmt.Sprintf("%q is not a known role chain. Role chains are defined in the role_chains section of the file printed by `keyconjurer config-path`.", name),
+		ExitCode: ExitCodeValueError,
+	}
+}
+
+// assumeRoleChain assumes each role in hops in turn, starting with the credentials in cfg, and returns the credentials from the final hop.
+//
+// tokenCode is called to retrieve an MFA code for each hop that requires one. AWS rejects codes which have already been used, so it must return a new code each time.
+func assumeRoleChain(ctx context.Context, cfg aws.Config, hops []RoleHop, defaultRoleSessionName string, tokenCode func(serialNumber string) (string, error)) (CloudCredentials, error) {
+	var creds CloudCredentials
+	client := sts.NewFromConfig(cfg)
+	for _, hop := range hops {
+		callerIdentity := func() (arn.ARN, error) {
+			resp, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				return arn.ARN{}, AWSError{InnerError: err, Message: "failed to get caller identity"}
+			}
+			return arn.Parse(*resp.Arn)
+		}
+
+		roleARN, err := hop.roleARN(callerIdentity)
+		if err != nil {
+			return creds, err
+		}
+
+		var code string
+		if hop.SerialNumber != "" {
+			if code, err = tokenCode(hop.SerialNumber); err != nil {
+				return creds, err
+			}
+		}
+
+		resp, err := client.AssumeRole(ctx, hop.assumeRoleInput(roleARN, defaultRoleSessionName, code))
+		if err != nil {
+			return creds, AWSError{InnerError: err, Message: fmt.Sprintf("failed to assume %s", roleARN)}
+		}
+
+		// The account ID is always present in a role ARN, so this will not fail in practice.
+		parsed, _ := arn.Parse(roleARN)
+		creds = CloudCredentials{
+			AccountID:       parsed.AccountID,
+			AccessKeyID:     *resp.Credentials.AccessKeyId,
+			SecretAccessKey: *resp.Credentials.SecretAccessKey,
+			SessionToken:    *resp.Credentials.SessionToken,
+			Expiration:      resp.Credentials.Expiration.Format(time.RFC3339),
+		}
+
+		client = sts.NewFromConfig(cfg, func(o *sts.Options) {
+			o.Credentials = credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+		})
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,109 @@
+package command
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleHop_roleARN(t *testing.T) {
+	caller := func() (arn.ARN, error) {
+		return arn.Parse("arn:aws-us-gov:sts::1111:assumed-role/NetworkAdmin/KeyConjurer-AssumeRole")
+	}
+	noCaller := func() (arn.ARN, error) {
+		return arn.ARN{}, errors.New("caller identity should not be needed")
+	}
+
+	tests := []struct {
+		name     string
+		hop      RoleHop
+		identity func() (arn.ARN, error)
+		expected string
+	}{
+		{"ARN", RoleHop{Role: "arn:aws:iam::2222:role/Workload"}, noCaller, "arn:aws:iam::2222:role/Workload"},
+		{"Name", RoleHop{Role: "Workload", AccountID: "2222"}, caller, "arn:aws-us-gov:iam::2222:role/Workload"},
+		{"SameNameAsCaller", RoleHop{AccountID: "2222"}, caller, "arn:aws-us-gov:iam::2222:role/NetworkAdmin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleARN, err := tt.hop.roleARN(tt.identity)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, roleARN)
+		})
+	}
+}
+
+func TestRoleHop_roleARNRequiresAccountForNames(t *testing.T) {
+	_, err := RoleHop{Role: "Workload"}.roleARN(nil)
+	assert.Error(t, err)
+}
+
+func TestRoleHop_assumeRoleInput(t *testing.T) {
+	hop := RoleHop{
+		ExternalID:        "external",
+		SerialNumber:      "arn:aws:iam::1111:mfa/user",
+		SourceIdentity:    "user@example.com",
+		Tags:              map[string]string{"team": "network", "cost-center": "1234"},
+		TransitiveTagKeys: []string{"team"},
+	}
+
+	input := hop.assumeRoleInput("arn:aws:iam::2222:role/Workload", "KeyConjurer-AssumeRole", "123456")
+	assert.Equal(t, "arn:aws:iam::2222:role/Workload", aws.ToString(input.RoleArn))
+	assert.Equal(t, "KeyConjurer-AssumeRole", aws.ToString(input.RoleSessionName))
+	assert.Equal(t, "external", aws.ToString(input.ExternalId))
+	assert.Equal(t, "arn:aws:iam::1111:mfa/user", aws.ToString(input.SerialNumber))
+	assert.Equal(t, "123456", aws.ToString(input.TokenCode))
+	assert.Equal(t, "user@example.com", aws.ToString(input.SourceIdentity))
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("cost-center"), Value: aws.String("1234")},
+		{Key: aws.String("team"), Value: aws.String("network")},
+	}, input.Tags)
+	assert.Equal(t, []string{"team"}, input.TransitiveTagKeys)
+}
+
+func TestRoleHop_assumeRoleInputOmitsUnsetOptions(t *testing.T) {
+	input := RoleHop{RoleSessionName: "custom"}.assumeRoleInput("arn:aws:iam::2222:role/Workload", "KeyConjurer-AssumeRole", "")
+	assert.Equal(t, "custom", aws.ToString(input.RoleSessionName))
+	assert.Nil(t, input.ExternalId)
+	assert.Nil(t, input.SerialNumber)
+	assert.Nil(t, input.TokenCode)
+	assert.Nil(t, input.SourceIdentity)
+	assert.Empty(t, input.Tags)
+}
+
+func TestSwitchCommand_hopsAppendsAccountToChain(t *testing.T) {
+	cfg := Config{RoleChains: map[string][]RoleHop{
+		"hub": {
+			{Role: "arn:aws:iam::1111:role/Bastion"},
+			{Role: "arn:aws:iam::2222:role/NetworkHub", ExternalID: "hub"},
+		},
+	}}
+
+	s := SwitchCommand{RoleChain: "hub", AccountID: "3333", Hop: RoleHop{AccountID: "3333", Role: "Workload"}}
+	hops, err := s.hops(&cfg)
+	require.NoError(t, err)
+	require.Len(t, hops, 3)
+	assert.Equal(t, "arn:aws:iam::1111:role/Bastion", hops[0].Role)
+	assert.Equal(t, "hub", hops[1].ExternalID)
+	assert.Equal(t, "Workload", hops[2].Role)
+
+	s.RoleChain = "unknown"
+	_, err = s.hops(&cfg)
+	assert.Error(t, err)
+}
+
+func TestSwitchCommand_hopsRejectsEmptyChains(t *testing.T) {
+	cfg := Config{RoleChains: map[string][]RoleHop{"empty": {}}}
+
+	s := SwitchCommand{RoleChain: "empty"}
+	_, err := s.hops(&cfg)
+	code, ok := GetExitCode(err)
+	require.True(t, ok, err)
+	assert.Equal(t, ExitCodeValueError, code)
+}
@@ -1,9 +1,11 @@
 package command
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/RobotsAndPencils/go-saml"
+	"github.com/riotgames/key-conjurer/internal/oktawebsso"
 	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
 	"github.com/spf13/cobra"
 )
@@ -24,6 +26,9 @@ var rolesCmd = cobra.Command{
 		}
 
 		samlResponse, _, err := oauth2cli.DiscoverConfigAndExchangeTokenForAssertion(cmd.Context(), &keychainTokenSource{}, oidcDomain, clientID, applicationID)
+		if errors.Is(err, oktawebsso.ErrNotOIDCToken) {
+			return ErrServiceAppCannotUseWebSSO
+		}
 		if err != nil {
 			return err
 		}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"runtime"
 	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -16,12 +18,15 @@ var (
 	FlagClientID   = "client-id"
 	FlagQuiet      = "quiet"
 	FlagTimeout    = "timeout"
+	// FlagAWSEndpointURL overrides the STS endpoint, such as for testing against a local emulator.
+	FlagAWSEndpointURL = "aws-endpoint-url"
 )
 
 func init() {
 	rootCmd.PersistentFlags().String(FlagOIDCDomain, OIDCDomain, "The domain name of your OIDC server")
 	rootCmd.PersistentFlags().String(FlagClientID, ClientID, "The OAuth2 Client ID for the application registered with your OIDC server")
 	rootCmd.PersistentFlags().Int(FlagTimeout, 120, "the amount of time in seconds to wait for keyconjurer to respond")
+	rootCmd.PersistentFlags().String(FlagAWSEndpointURL, "", "The URL of the AWS STS endpoint to use instead of the default. Defaults to $AWS_ENDPOINT_URL_STS")
 	rootCmd.PersistentFlags().Bool(FlagQuiet, false, "tells the CLI to be quiet; stdout will not contain human-readable informational messages")
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(accountsCmd)
@@ -70,9 +75,10 @@ To get started run the following commands:
 			return fmt.Errorf("failed to load config: %s", err)
 		}
 
-		// We don't care about this being cancelled.
+		// The timeout context is released when the context given to Execute is cancelled.
 		timeout, _ := cmd.Flags().GetInt(FlagTimeout)
-		nextCtx, _ := context.WithTimeout(cmd.Context(), time.Duration(timeout)*time.Second)
+		nextCtx, cancel := context.WithTimeout(cmd.Context(), time.Duration(timeout)*time.Second)
+		context.AfterFunc(cmd.Context(), cancel)
 		cmd.SetContext(ConfigContext(nextCtx, &config))
 		return nil
 	},
@@ -87,7 +93,19 @@ To get started run the following commands:
 	SilenceUsage:  true,
 }
 
+// awsEndpointURL returns the STS endpoint given with --aws-endpoint-url or $AWS_ENDPOINT_URL_STS, or an empty string if the default endpoint of the region should be used.
+func awsEndpointURL(flags *pflag.FlagSet) string {
+	if endpoint, _ := flags.GetString(FlagAWSEndpointURL); endpoint != "" {
+		return endpoint
+	}
+
+	return os.Getenv("AWS_ENDPOINT_URL_STS")
+}
+
 func Execute(ctx context.Context, args []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	client := &http.Client{Transport: LogRoundTripper{http.DefaultTransport}}
 	ctx = oidc.ClientContext(ctx, client)
 	rootCmd.SetArgs(args)
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -82,3 +83,17 @@ func TestInvalidCommand(t *testing.T) {
 		t.Errorf("Unexpected non-error, output=: %v", output)
 	}
 }
+
+func Test_awsEndpointURL(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String(FlagAWSEndpointURL, "", "")
+
+	t.Setenv("AWS_ENDPOINT_URL_STS", "")
+	assert.Equal(t, "", awsEndpointURL(flags))
+
+	t.Setenv("AWS_ENDPOINT_URL_STS", "http://localhost:4566")
+	assert.Equal(t, "http://localhost:4566", awsEndpointURL(flags))
+
+	flags.Set(FlagAWSEndpointURL, "http://localhost:8080")
+	assert.Equal(t, "http://localhost:8080", awsEndpointURL(flags), "the flag takes precedence over the environment")
+}
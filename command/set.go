@@ -10,6 +10,9 @@ import (
 func init() {
 	setCmd.AddCommand(setTTLCmd)
 	setCmd.AddCommand(setTimeRemainingCmd)
+	setCmd.AddCommand(setPolicyPresetCmd)
+	setPolicyPresetCmd.Flags().String(FlagPolicyFile, "", "Path to a JSON IAM policy to store in the preset.")
+	setPolicyPresetCmd.Flags().StringSlice(FlagPolicyARN, nil, "The ARN of a managed IAM policy to store in the preset. May be specified more than once.")
 }
 
 var setCmd = &cobra.Command{
@@ -51,3 +54,31 @@ var setTimeRemainingCmd = &cobra.Command{
 		return nil
 	},
 }
+
+var setPolicyPresetCmd = &cobra.Command{
+	Use:     "policy-preset <name>",
+	Short:   "Sets a named session policy which can be used to scope down credentials.",
+	Long:    "Sets a named session policy which can be used to scope down credentials with the --policy-preset flag. The contents of the policy file are stored in the preset, so the file does not need to exist afterwards.",
+	Example: "keyconjurer set policy-preset readonly --policy-arn arn:aws:iam::aws:policy/ReadOnlyAccess",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := ConfigFromCommand(cmd)
+		policyFile, _ := cmd.Flags().GetString(FlagPolicyFile)
+		policyARNs, _ := cmd.Flags().GetStringSlice(FlagPolicyARN)
+		policy, err := ReadSessionPolicy(policyFile, policyARNs)
+		if err != nil {
+			return err
+		}
+
+		if policy.IsEmpty() {
+			return fmt.Errorf("one of --%s or --%s must be specified", FlagPolicyFile, FlagPolicyARN)
+		}
+
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+
+		config.SetPolicyPreset(args[0], policy)
+		return nil
+	},
+}
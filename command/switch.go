@@ -1,26 +1,38 @@
 package command
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
 
 var (
-	FlagRoleSessionName = "role-session-name"
-	FlagOutputType      = "output"
-	FlagShellType       = "shell"
-	FlagAWSCLIPath      = "awscli"
+	FlagRoleSessionName  = "role-session-name"
+	FlagOutputType       = "output"
+	FlagShellType        = "shell"
+	FlagAWSCLIPath       = "awscli"
+	FlagExternalID       = "external-id"
+	FlagMFASerial        = "mfa-serial"
+	FlagMFAToken         = "mfa-token"
+	FlagSourceIdentity   = "source-identity"
+	FlagSessionTag       = "tag"
+	FlagTransitiveTagKey = "transitive-tag-key"
+	FlagRoleChain        = "chain"
+	FlagProfileName      = "profile"
+	FlagSourceProfile    = "source-profile"
+	FlagSourceAccount    = "source-account"
+	FlagSourceRole       = "source-role"
 )
 
 func init() {
@@ -28,19 +40,40 @@ func init() {
 	switchCmd.Flags().StringP(FlagOutputType, "o", outputTypeEnvironmentVariable, "Format to save new credentials in. Supported outputs: env, awscli, json")
 	switchCmd.Flags().String(FlagShellType, shellTypeInfer, "If output type is env, determines which format to output credentials in - by default, the format is inferred based on the execution environment. WSL users may wish to overwrite this to `bash`")
 	switchCmd.Flags().String(FlagAWSCLIPath, "~/.aws/", "Path for directory used by the aws-cli tool. Default is \"~/.aws\".")
+	switchCmd.Flags().StringP(FlagRoleName, "r", "", "The name or ARN of the role to assume. Defaults to a role with the same name as your current role.")
+	switchCmd.Flags().String(FlagExternalID, "", "The external ID required to assume the role, if any.")
+	switchCmd.Flags().String(FlagMFASerial, "", "The serial number or ARN of the MFA device required to assume the role, if any.")
+	switchCmd.Flags().String(FlagMFAToken, "", "The code from your MFA device, used for the first role which requires MFA. You will be prompted for a code for any other role which requires MFA, or if this is not specified.")
+	switchCmd.Flags().String(FlagSourceIdentity, "", "The source identity to set on the role session.")
+	switchCmd.Flags().StringToString(FlagSessionTag, nil, "A session tag to set on the role session in the form key=value. May be specified more than once.")
+	switchCmd.Flags().StringSlice(FlagTransitiveTagKey, nil, "The key of a session tag that should be passed on to roles assumed from the new session. May be specified more than once.")
+	switchCmd.Flags().String(FlagRoleChain, "", "The name of a role chain from your config to assume before switching into the given account.")
+	switchCmd.Flags().String(FlagProfileName, "", "If output type is awscli, the name of the profile to save the credentials in. Defaults to the account ID.")
+	switchCmd.Flags().String(FlagSourceProfile, "", "The name of an aws CLI profile to use as the source credentials, instead of the default credentials.")
+	switchCmd.Flags().String(FlagSourceAccount, "", "The name or alias of an account to retrieve the source credentials for, as if with the get command, instead of using the default credentials.")
+	switchCmd.Flags().String(FlagSourceRole, "", "If --source-account is specified, the role to retrieve the source credentials for. Defaults to the most recently used role for the account.")
+	switchCmd.Flags().String(FlagRegion, "", "The AWS region to use. Defaults to the region of the source credentials, or us-west-2 if --source-account is used.")
+	switchCmd.Flags().Bool(FlagLogin, false, "If --source-account is specified, login to Okta before retrieving the source credentials if required")
+	switchCmd.MarkFlagsMutuallyExclusive(FlagSourceProfile, FlagSourceAccount)
 }
 
 var switchCmd = cobra.Command{
-	Use:   "switch <account-id>",
+	Use:   "switch [account-id]",
 	Short: "Switch from the current AWS account into the one with the given Account ID.",
 	Long: `Attempt to AssumeRole into the given AWS with the current credentials. You only need to use this if you are a power user or network engineer with access to many accounts.
 
 This is used when a "bastion" account exists which users initially authenticate into and then pivot from that account into other accounts.
 
+By default, a role with the same name as your current role is assumed. A different role can be assumed with the --role flag.
+
+Role chains, such as bastion to network-hub to workload, can be defined in the role_chains section of your config file and used with the --chain flag. Each role in the chain is assumed in turn. If an account ID is also given, the account is switched into from the last role in the chain.
+
+The source credentials are taken from the default AWS credential chain unless --source-profile or --source-account is specified. With --source-account, credentials are first retrieved from Okta for the given account and role as if by the get command.
+
 This command will fail if you do not have active Cloud credentials.
 `,
 	Example: "keyconjurer switch 123456798",
-	Args:    cobra.ExactArgs(1),
+	Args:    cobra.RangeArgs(0, 1),
 	Aliases: []string{"switch-account"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var switchCmd SwitchCommand
@@ -52,7 +85,7 @@ This command will fail if you do not have active Cloud credentials.
 			return err
 		}
 
-		return switchCmd.Execute(cmd.Context())
+		return switchCmd.Execute(cmd.Context(), ConfigFromCommand(cmd))
 	},
 }
 
@@ -62,6 +95,22 @@ type SwitchCommand struct {
 	AWSCLIPath      string
 	RoleSessionName string
 	AccountID       string
+	RoleChain       string
+	MFAToken        string
+	ProfileName     string
+	Region          string
+	// AWSEndpointURL, if set, is used as the STS endpoint instead of the default endpoint of the region.
+	AWSEndpointURL string
+	// SourceProfile is the aws CLI profile to take the source credentials from.
+	SourceProfile string
+	// SourceAccount and SourceRole select an account to retrieve the source credentials for, as if with the get command.
+	SourceAccount, SourceRole string
+	// Source is used to retrieve credentials when SourceAccount is set.
+	Source GetCommand
+	// Hop holds the options for switching into AccountID.
+	Hop RoleHop
+
+	PromptMFAToken func(serialNumber string) (string, error)
 }
 
 func (s *SwitchCommand) Parse(flags *pflag.FlagSet, args []string) error {
@@ -69,11 +118,37 @@ func (s *SwitchCommand) Parse(flags *pflag.FlagSet, args []string) error {
 	s.ShellType, _ = flags.GetString(FlagShellType)
 	s.AWSCLIPath, _ = flags.GetString(FlagAWSCLIPath)
 	s.RoleSessionName, _ = flags.GetString(FlagRoleSessionName)
+	s.RoleChain, _ = flags.GetString(FlagRoleChain)
+	s.MFAToken, _ = flags.GetString(FlagMFAToken)
+	s.Hop.Role, _ = flags.GetString(FlagRoleName)
+	s.Hop.ExternalID, _ = flags.GetString(FlagExternalID)
+	s.Hop.SerialNumber, _ = flags.GetString(FlagMFASerial)
+	s.Hop.SourceIdentity, _ = flags.GetString(FlagSourceIdentity)
+	s.Hop.Tags, _ = flags.GetStringToString(FlagSessionTag)
+	s.Hop.TransitiveTagKeys, _ = flags.GetStringSlice(FlagTransitiveTagKey)
+	s.ProfileName, _ = flags.GetString(FlagProfileName)
+	s.Region, _ = flags.GetString(FlagRegion)
+	s.AWSEndpointURL = awsEndpointURL(flags)
+	s.SourceProfile, _ = flags.GetString(FlagSourceProfile)
+	s.SourceAccount, _ = flags.GetString(FlagSourceAccount)
+	s.SourceRole, _ = flags.GetString(FlagSourceRole)
+	s.Source.OIDCDomain, _ = flags.GetString(FlagOIDCDomain)
+	s.Source.ClientID, _ = flags.GetString(FlagClientID)
+	s.Source.Login, _ = flags.GetBool(FlagLogin)
+	s.Source.AWSEndpointURL = s.AWSEndpointURL
+	s.Source.MachineOutput = ShouldUseMachineOutput(flags)
+	s.Source.TimeToLive = DefaultTTL
+	s.Source.TimeRemaining = DefaultTimeRemaining
+	s.PromptMFAToken = promptMFAToken
 	if len(args) == 0 {
-		return fmt.Errorf("account-id is required")
+		if s.RoleChain == "" {
+			return fmt.Errorf("account-id is required")
+		}
+		return nil
 	}
 
 	s.AccountID = args[0]
+	s.Hop.AccountID = args[0]
 	return nil
 }
 
@@ -86,74 +161,166 @@ func (s SwitchCommand) Validate() error {
 		return ValueError{Value: s.ShellType, ValidValues: permittedShellTypes}
 	}
 
+	for _, key := range s.Hop.TransitiveTagKeys {
+		if _, ok := s.Hop.Tags[key]; !ok {
+			return genericError{
+				Message:  fmt.Sprintf("transitive tag key %q does not match any tag given with --%s", key, FlagSessionTag),
+				ExitCode: ExitCodeValueError,
+			}
+		}
+	}
+
 	return nil
 }
 
-func (s SwitchCommand) Execute(ctx context.Context) error {
-	// We could read the environment variable for the assumed role ARN, but it might be expired which isn't very useful to the user.
-	creds, err := getAWSCredentials(ctx, s.AccountID, s.RoleSessionName)
-	if err != nil {
-		// If this failed, either there was a network error or the user is not authorized to assume into this role
-		// This can happen if the user is not authenticated using the Bastion instance.
-		return err
+// hops returns the roles that need to be assumed in order.
+func (s SwitchCommand) hops(config *Config) ([]RoleHop, error) {
+	var hops []RoleHop
+	if s.RoleChain != "" {
+		chain, ok := config.RoleChains[s.RoleChain]
+		if !ok {
+			return nil, UnknownRoleChainError(s.RoleChain)
+		}
+
+		if len(chain) == 0 {
+			return nil, genericError{
+				Message:  fmt.Sprintf("The role chain %q has no roles. Add the roles to assume to the role_chains section of the file printed by `keyconjurer config-path`.", s.RoleChain),
+				ExitCode: ExitCodeValueError,
+			}
+		}
+		hops = append(hops, chain...)
 	}
 
-	switch s.OutputType {
-	case outputTypeEnvironmentVariable:
-		creds.WriteFormat(os.Stdout, s.ShellType)
-		return nil
-	case outputTypeAWSCredentialsFile:
-		acc := Account{ID: s.AccountID, Name: s.AccountID}
-		newCliEntry := NewCloudCliEntry(creds, &acc)
-		return SaveCloudCredentialInCLI(s.AWSCLIPath, newCliEntry)
-	default:
-		return fmt.Errorf("%s is an invalid output type", s.OutputType)
+	if s.AccountID != "" {
+		hops = append(hops, s.Hop)
+	}
+
+	return hops, nil
+}
+
+// tokenCodes returns a function which returns the MFA code to use for each hop that requires one.
+//
+// The code given with --mfa-token is only used for the first such hop, as AWS rejects codes which have already been used; the user is prompted for the rest.
+func (s SwitchCommand) tokenCodes() func(serialNumber string) (string, error) {
+	code := s.MFAToken
+	return func(serialNumber string) (string, error) {
+		if code != "" {
+			defer func() { code = "" }()
+			return code, nil
+		}
+
+		return s.PromptMFAToken(serialNumber)
+	}
+}
+
+// loadSourceConfig loads the AWS config holding the credentials that the first role is assumed with.
+func (s SwitchCommand) loadSourceConfig(ctx context.Context, config *Config) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	region := s.Region
+	if s.SourceProfile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(s.SourceProfile))
+	}
+
+	if s.SourceAccount != "" {
+		source := s.Source
+		source.RoleName = s.SourceRole
+		source.AccountIDOrName = s.SourceAccount
+		if region == "" {
+			region = DefaultRegion
+		}
+		source.Region = region
+
+		creds, err := source.FetchCredentials(ctx, config, s.SourceAccount)
+		if errors.Is(err, errRoleRequired) {
+			return aws.Config{}, fmt.Errorf("--%s is required because no role has been used with %s before", FlagSourceRole, s.SourceAccount)
+		}
+
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		provider := credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+		opts = append(opts, awsconfig.WithCredentialsProvider(provider))
+	}
+
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	if s.AWSEndpointURL != "" {
+		opts = append(opts, awsconfig.WithBaseEndpoint(s.AWSEndpointURL))
 	}
+
+	return awsconfig.LoadDefaultConfig(ctx, opts...)
 }
 
-func getAWSCredentials(ctx context.Context, accountID, roleSessionName string) (creds CloudCredentials, err error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func (s SwitchCommand) Execute(ctx context.Context, config *Config) error {
+	hops, err := s.hops(config)
 	if err != nil {
-		return
+		return err
 	}
 
-	c := sts.NewFromConfig(cfg)
-	response, err := c.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	// Retrieving credentials for --source-account records it as the most recently used account.
+	previous := config.LastUsedAccount
+
+	// We could read the environment variable for the assumed role ARN, but it might be expired which isn't very useful to the user.
+	awsCfg, err := s.loadSourceConfig(ctx, config)
 	if err != nil {
-		return
+		return err
 	}
 
-	// We need to modify this to change the last section to be role/GL-SuperAdmin
-	id, err := arn.Parse(*response.Arn)
+	creds, err := assumeRoleChain(ctx, awsCfg, hops, s.RoleSessionName, s.tokenCodes())
 	if err != nil {
-		return
+		// If this failed, either there was a network error or the user is not authorized to assume into this role
+		// This can happen if the user is not authenticated using the Bastion instance.
+		return err
 	}
 
-	parts := strings.Split(id.Resource, "/")
-	arn := arn.ARN{
-		AccountID: accountID,
-		Partition: "aws",
-		Service:   "iam",
-		Resource:  fmt.Sprintf("role/%s", parts[1]),
-		Region:    id.Region,
+	recordTargetAccount(config, creds.AccountID, hops[len(hops)-1].Role, previous)
+
+	profileName := s.ProfileName
+	if profileName == "" {
+		profileName = creds.AccountID
 	}
 
-	resp, err := c.AssumeRole(ctx, &sts.AssumeRoleInput{
-		RoleArn:         aws.String(arn.String()),
-		RoleSessionName: aws.String(roleSessionName),
-	})
+	return echoCredentials(creds.AccountID, profileName, creds, s.OutputType, s.ShellType, s.AWSCLIPath)
+}
 
-	if err != nil {
+// recordTargetAccount records the account that was switched into as the most recently used, as the get command does.
+//
+// The AWS account is only recorded if it belongs to one of the accounts of the user; otherwise previous remains the most recently used account, rather than the source account.
+func recordTargetAccount(config *Config, awsAccountID, role string, previous *string) {
+	account, ok := config.FindAWSAccount(awsAccountID)
+	if !ok {
+		config.LastUsedAccount = previous
 		return
 	}
 
-	creds = CloudCredentials{
-		AccountID:       accountID,
-		AccessKeyID:     *resp.Credentials.AccessKeyId,
-		SecretAccessKey: *resp.Credentials.SecretAccessKey,
-		SessionToken:    *resp.Credentials.SessionToken,
-		Expiration:      resp.Credentials.Expiration.Format(time.RFC3339),
+	if role != "" && !arn.IsARN(role) {
+		account.MostRecentRole = role
+	}
+
+	id := account.ID
+	config.LastUsedAccount = &id
+}
+
+var errNoMFAToken = errors.New("an MFA code is required")
+
+// stdin reads from standard input. It is shared by every prompt, as a reader of its own could buffer input meant for the next prompt.
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptMFAToken asks the user for the code from their MFA device on the terminal.
+func promptMFAToken(serialNumber string) (string, error) {
+	fi, _ := os.Stdin.Stat()
+	if fi == nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("%w for %s; specify it with --%s", errNoMFAToken, serialNumber, FlagMFAToken)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter the MFA code for %s: ", serialNumber)
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", err
 	}
 
-	return creds, nil
+	return strings.TrimSpace(line), nil
 }
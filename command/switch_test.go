@@ -0,0 +1,130 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitchCommand_FromSourceAccount(t *testing.T) {
+	g, stsServer, ctx := newLoggedInGetCommand(t)
+	// The shared AWS config of the user running the tests must not be used.
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	s := SwitchCommand{
+		SourceAccount:   "production",
+		SourceRole:      "Admin",
+		Source:          g,
+		AWSEndpointURL:  stsServer.URL,
+		RoleSessionName: "KeyConjurer-AssumeRole",
+		Hop:             RoleHop{AccountID: "210987654321"},
+	}
+
+	awsCfg, err := s.loadSourceConfig(ctx, newTestConfig())
+	require.NoError(t, err)
+	creds, err := assumeRoleChain(ctx, awsCfg, []RoleHop{s.Hop}, s.RoleSessionName, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "210987654321", creds.AccountID)
+
+	var actions []string
+	for _, call := range stsServer.Calls() {
+		actions = append(actions, call.Action)
+	}
+	assert.Equal(t, []string{"AssumeRoleWithSAML", "GetCallerIdentity", "AssumeRole"}, actions)
+	// A role with the same name as the source role is assumed in the target account.
+	assert.Equal(t, "arn:aws:iam::210987654321:role/Admin", stsServer.Calls()[2].Params.Get("RoleArn"))
+}
+
+func TestSwitchCommand_tokenCodesOnlyUsesFlagForFirstHop(t *testing.T) {
+	var prompts []string
+	s := SwitchCommand{MFAToken: "111111", PromptMFAToken: func(serialNumber string) (string, error) {
+		prompts = append(prompts, serialNumber)
+		return "222222", nil
+	}}
+
+	tokenCode := s.tokenCodes()
+	code, err := tokenCode("arn:aws:iam::1111:mfa/user")
+	require.NoError(t, err)
+	assert.Equal(t, "111111", code)
+
+	// AWS rejects codes which have already been used, so the user is prompted for the next hop.
+	code, err = tokenCode("arn:aws:iam::2222:mfa/user")
+	require.NoError(t, err)
+	assert.Equal(t, "222222", code)
+	assert.Equal(t, []string{"arn:aws:iam::2222:mfa/user"}, prompts)
+}
+
+func TestSwitchCommand_ExecuteWritesTargetCredentials(t *testing.T) {
+	g, stsServer, ctx := newLoggedInGetCommand(t)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	cliPath := t.TempDir()
+
+	config := newTestConfig()
+	config.AddAccount("0oa2", Account{ID: "0oa2", Name: "AWS - Workload Account", Alias: "workload", Type: "aws", AccountIDs: []string{"210987654321"}})
+
+	s := SwitchCommand{
+		OutputType:      outputTypeAWSCredentialsFile,
+		AWSCLIPath:      cliPath,
+		ProfileName:     "workload",
+		SourceAccount:   "production",
+		SourceRole:      "Admin",
+		Source:          g,
+		AWSEndpointURL:  stsServer.URL,
+		RoleSessionName: "KeyConjurer-AssumeRole",
+		AccountID:       "210987654321",
+		Hop:             RoleHop{AccountID: "210987654321", Role: "Deployer"},
+	}
+	require.NoError(t, s.Execute(ctx, config))
+
+	file, err := ini.Load(filepath.Join(cliPath, "credentials"))
+	require.NoError(t, err)
+	section, err := file.GetSection("workload")
+	require.NoError(t, err, "the credentials are saved in the profile given with --profile")
+	assert.NotEmpty(t, section.Key("aws_session_token").String())
+	assert.Equal(t, "arn:aws:iam::210987654321:role/Deployer", stsServer.Calls()[2].Params.Get("RoleArn"))
+
+	// The account that was switched into is the most recently used, not the source account.
+	require.NotNil(t, config.LastUsedAccount)
+	assert.Equal(t, "0oa2", *config.LastUsedAccount)
+	assert.Equal(t, "Deployer", config.Accounts.accounts["0oa2"].MostRecentRole)
+	assert.Equal(t, "Admin", config.Accounts.accounts["0oa1"].MostRecentRole)
+}
+
+func TestSwitchCommand_ExecuteKeepsLastUsedAccountForUnknownTargets(t *testing.T) {
+	g, stsServer, ctx := newLoggedInGetCommand(t)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	cliPath := t.TempDir()
+
+	config := newTestConfig()
+	previous := "0oa9"
+	config.LastUsedAccount = &previous
+
+	s := SwitchCommand{
+		OutputType:      outputTypeAWSCredentialsFile,
+		AWSCLIPath:      cliPath,
+		SourceAccount:   "production",
+		SourceRole:      "Admin",
+		Source:          g,
+		AWSEndpointURL:  stsServer.URL,
+		RoleSessionName: "KeyConjurer-AssumeRole",
+		AccountID:       "210987654321",
+		Hop:             RoleHop{AccountID: "210987654321"},
+	}
+	require.NoError(t, s.Execute(ctx, config))
+
+	// The profile is named after the account when --profile is not given.
+	buf, err := os.ReadFile(filepath.Join(cliPath, "credentials"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), "[210987654321]")
+
+	// The get command could not retrieve credentials for the AWS account, so the account used before the switch is kept.
+	require.NotNil(t, config.LastUsedAccount)
+	assert.Equal(t, "0oa9", *config.LastUsedAccount)
+}
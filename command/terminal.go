@@ -0,0 +1,205 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"github.com/riotgames/key-conjurer/internal/oktaauthn"
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
+	"golang.org/x/oauth2"
+	"golang.org/x/term"
+)
+
+// mfaFactorTypes maps the values of --mfa-factor to the Okta factor types they select.
+var mfaFactorTypes = map[string]string{
+	"push": oktaauthn.FactorTypePush,
+	"totp": oktaauthn.FactorTypeTOTP,
+	"duo":  oktaauthn.FactorTypeDuo,
+}
+
+// terminal prompts the user for input.
+type terminal struct {
+	in  *bufio.Reader
+	out io.Writer
+	// fd is the file descriptor of the terminal input is read from, so that passwords are not echoed, or -1 if input is not read from a terminal.
+	fd int
+}
+
+// stdioTerminal prompts the user on standard error and reads their answers from standard input, so that prompts are not mixed with machine output.
+func stdioTerminal() *terminal {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fd = -1
+	}
+	return &terminal{in: stdin, out: os.Stderr, fd: fd}
+}
+
+// ReadLine writes prompt and returns the line the user enters, without its line ending.
+func (t *terminal) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(t.out, prompt)
+	line, err := t.in.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadPassword is like ReadLine, but does not echo what the user enters.
+func (t *terminal) ReadPassword(prompt string) (string, error) {
+	if t.fd < 0 {
+		return t.ReadLine(prompt)
+	}
+
+	fmt.Fprint(t.out, prompt)
+	password, err := term.ReadPassword(t.fd)
+	fmt.Fprintln(t.out)
+	return string(password), err
+}
+
+// loginWithTerminal signs the user in with the Okta authentication API, prompting for their username, password and MFA, then exchanges the resulting session token for tokens with the authorization code flow.
+func (c LoginCommand) loginWithTerminal(ctx context.Context, prov *oidc.Provider, cfg *oauth2.Config) error {
+	t := c.terminal
+	if t == nil {
+		t = stdioTerminal()
+	}
+
+	username := c.Username
+	if username == "" {
+		line, err := t.ReadLine("Username: ")
+		if err != nil {
+			return err
+		}
+		username = strings.TrimSpace(line)
+	}
+
+	password, err := t.ReadPassword("Password: ")
+	if err != nil {
+		return err
+	}
+
+	client := oktaauthn.Client{Domain: c.OIDCDomain, HTTPClient: oauth2.NewClient(ctx, nil), PollInterval: c.pollInterval}
+	tx, err := client.Authenticate(ctx, username, password)
+	if err == nil && tx.Status == oktaauthn.StatusMFARequired {
+		tx, err = c.verifyFactor(ctx, client, t, tx)
+	}
+	if loginErr, ok := tryParseAuthnError(err); ok {
+		return loginErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if tx.Status != oktaauthn.StatusSuccess {
+		// Such as when the password of the user has expired, or they must enroll a factor.
+		return LoginError{
+			Message:  fmt.Sprintf("Okta cannot finish signing you in from the terminal (status %s).", tx.Status),
+			Hint:     fmt.Sprintf("Log in without --%s to finish signing in with a browser.", FlagTerminal),
+			ExitCode: ExitCodeAuthenticationError,
+		}
+	}
+
+	tok, err := oauth2cli.ExchangeSessionToken(ctx, cfg, tx.SessionToken)
+	if loginErr, ok := tryParseCallbackError(err); ok {
+		return loginErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.storeToken(ctx, prov, tok)
+}
+
+// verifyFactor verifies one of the MFA factors of tx, prompting the user to choose one if they have enrolled more than one.
+func (c LoginCommand) verifyFactor(ctx context.Context, client oktaauthn.Client, t *terminal, tx oktaauthn.Transaction) (oktaauthn.Transaction, error) {
+	factor, err := c.chooseFactor(t, tx.Embedded.Factors)
+	if err != nil {
+		return tx, err
+	}
+
+	switch factor.FactorType {
+	case oktaauthn.FactorTypeTOTP:
+		passCode, err := t.ReadLine(fmt.Sprintf("Enter the code from %s: ", strings.TrimSuffix(factor.String(), " code")))
+		if err != nil {
+			return tx, err
+		}
+		return client.VerifyPasscode(ctx, tx, factor, strings.TrimSpace(passCode))
+	case oktaauthn.FactorTypePush:
+		challenge, err := client.Challenge(ctx, tx, factor)
+		if err != nil {
+			return tx, err
+		}
+
+		if f := challenge.Embedded.Factor; f != nil && f.Embedded.Challenge != nil {
+			fmt.Fprintf(t.out, "Choose %d in Okta Verify to approve the push notification.\n", f.Embedded.Challenge.CorrectAnswer)
+		} else {
+			fmt.Fprintln(t.out, "Approve the push notification sent to Okta Verify.")
+		}
+		return client.WaitForChallenge(ctx, challenge)
+	default:
+		fmt.Fprintln(t.out, "Approve the push notification sent to your Duo device.")
+		return client.VerifyDuo(ctx, tx, factor)
+	}
+}
+
+// chooseFactor returns the factor selected by MFAFactor, or prompts the user to choose one of the supported factors.
+//
+// Duo factors are only offered if ExperimentalDuo is set.
+func (c LoginCommand) chooseFactor(t *terminal, factors []oktaauthn.Factor) (oktaauthn.Factor, error) {
+	hasDuo := slices.ContainsFunc(factors, func(f oktaauthn.Factor) bool { return f.Supported() && f.FactorType == oktaauthn.FactorTypeDuo })
+	factors = slices.DeleteFunc(slices.Clone(factors), func(f oktaauthn.Factor) bool {
+		return !f.Supported() || (f.FactorType == oktaauthn.FactorTypeDuo && !c.ExperimentalDuo)
+	})
+	if len(factors) == 0 {
+		hint := fmt.Sprintf("Log in without --%s, or enroll Okta Verify or an authenticator app.", FlagTerminal)
+		if hasDuo {
+			hint = fmt.Sprintf("Log in without --%s, or add --%s to verify Duo from the terminal, which is experimental.", FlagTerminal, FlagExperimentalDuo)
+		}
+
+		return oktaauthn.Factor{}, LoginError{
+			Message:  "None of your MFA factors can be verified from the terminal.",
+			Hint:     hint,
+			ExitCode: ExitCodeAuthenticationError,
+		}
+	}
+
+	if c.MFAFactor != "" {
+		i := slices.IndexFunc(factors, func(f oktaauthn.Factor) bool { return f.FactorType == mfaFactorTypes[c.MFAFactor] })
+		if i < 0 {
+			return oktaauthn.Factor{}, genericError{
+				Message:  fmt.Sprintf("You have not enrolled a %s factor that can be used from the terminal", c.MFAFactor),
+				ExitCode: ExitCodeValueError,
+			}
+		}
+		return factors[i], nil
+	}
+
+	if len(factors) == 1 {
+		return factors[0], nil
+	}
+
+	for i, f := range factors {
+		fmt.Fprintf(t.out, "%d. %s\n", i+1, f)
+	}
+
+	answer, err := t.ReadLine(fmt.Sprintf("Choose an MFA factor [1-%d]: ", len(factors)))
+	if err != nil {
+		return oktaauthn.Factor{}, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(factors) {
+		return oktaauthn.Factor{}, genericError{
+			Message:  fmt.Sprintf("%q is not one of the MFA factors", answer),
+			ExitCode: ExitCodeValueError,
+		}
+	}
+	return factors[n-1], nil
+}
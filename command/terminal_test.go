@@ -0,0 +1,163 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/riotgames/key-conjurer/pkg/oauth2cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// newTerminalLoginCommand returns a LoginCommand which logs in from the terminal to a local Okta organization where testOktaUser has enrolled factors, answering prompts with input.
+//
+// The browser is signed out, so the login only succeeds if the user signs in from the terminal.
+func newTerminalLoginCommand(t *testing.T, input string, factors ...oktatest.Factor) (LoginCommand, *bytes.Buffer, *oktatest.Server, context.Context) {
+	keyring.MockInit()
+	user := testOktaUser
+	user.Password = "hunter2"
+	user.Factors = factors
+	srv := oktatest.NewServer("0oaKeyConjurer", user)
+	t.Cleanup(srv.Close)
+	require.NoError(t, srv.SignIn(""))
+
+	var out bytes.Buffer
+	login := LoginCommand{
+		OIDCDomain:   srv.URL,
+		ClientID:     srv.ClientID,
+		Terminal:     true,
+		terminal:     &terminal{in: bufio.NewReader(strings.NewReader(input)), out: &out, fd: -1},
+		pollInterval: time.Millisecond,
+	}
+	return login, &out, srv, context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+}
+
+func TestLoginCommand_Terminal(t *testing.T) {
+	login, out, srv, ctx := newTerminalLoginCommand(t, "user@example.com\nhunter2\n1\n",
+		oktatest.Factor{Type: oktatest.FactorTypePush, CorrectAnswer: 42},
+		oktatest.Factor{Type: oktatest.FactorTypeTOTP, Secret: testTOTPSecret},
+	)
+	require.NoError(t, login.Execute(ctx, &Config{}))
+	assert.Contains(t, out.String(), "1. Okta Verify push notification\n2. Okta Verify code\n")
+	assert.Contains(t, out.String(), "Choose 42 in Okta Verify")
+
+	// The stored credential can be exchanged for a SAML assertion, as it can after logging in with a browser.
+	oauthCfg, err := oauth2cli.DiscoverConfig(ctx, srv.URL, srv.ClientID)
+	require.NoError(t, err)
+	response, _, err := oauth2cli.ExchangeTokenForAssertion(ctx, oauthCfg, &keychainTokenSource{}, srv.URL, "0oa1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Admin", "ReadOnly"}, listRoles(response))
+}
+
+func TestLoginCommand_TerminalFactors(t *testing.T) {
+	passCode, err := oktatest.Passcode(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		input           string
+		mfaFactor       string
+		experimentalDuo bool
+		factors         []oktatest.Factor
+		exitCode        int
+	}{
+		{
+			name:    "totp",
+			input:   "hunter2\n" + passCode + "\n",
+			factors: []oktatest.Factor{{Type: oktatest.FactorTypeTOTP, Secret: testTOTPSecret}},
+		},
+		{
+			name:     "wrong passcode",
+			input:    "hunter2\n000000\n",
+			factors:  []oktatest.Factor{{Type: oktatest.FactorTypeTOTP, Secret: testTOTPSecret}},
+			exitCode: ExitCodeAuthenticationError,
+		},
+		{
+			name:            "duo chosen by flag",
+			input:           "hunter2\n",
+			mfaFactor:       "duo",
+			experimentalDuo: true,
+			factors:         []oktatest.Factor{{Type: oktatest.FactorTypePush}, {Type: oktatest.FactorTypeDuo}},
+		},
+		{
+			name:     "duo not enabled",
+			input:    "hunter2\n",
+			factors:  []oktatest.Factor{{Type: oktatest.FactorTypeDuo}},
+			exitCode: ExitCodeAuthenticationError,
+		},
+		{
+			name:     "push rejected",
+			input:    "hunter2\n",
+			factors:  []oktatest.Factor{{Type: oktatest.FactorTypePush, Result: oktatest.FactorResultRejected}},
+			exitCode: ExitCodeAccessDeniedError,
+		},
+		{
+			name:            "duo timed out",
+			input:           "hunter2\n",
+			experimentalDuo: true,
+			factors:         []oktatest.Factor{{Type: oktatest.FactorTypeDuo, Result: oktatest.FactorResultTimeout}},
+			exitCode:        ExitCodeAuthenticationError,
+		},
+		{
+			name:     "wrong password",
+			input:    "wrong\n",
+			factors:  []oktatest.Factor{{Type: oktatest.FactorTypePush}},
+			exitCode: ExitCodeAuthenticationError,
+		},
+		{
+			name:            "invalid choice",
+			input:           "hunter2\n3\n",
+			experimentalDuo: true,
+			factors:         []oktatest.Factor{{Type: oktatest.FactorTypePush}, {Type: oktatest.FactorTypeDuo}},
+			exitCode:        ExitCodeValueError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, _, _, ctx := newTerminalLoginCommand(t, tt.input, tt.factors...)
+			login.Username = "user@example.com"
+			login.MFAFactor = tt.mfaFactor
+			login.ExperimentalDuo = tt.experimentalDuo
+
+			err := login.Execute(ctx, &Config{})
+			if tt.exitCode == 0 {
+				require.NoError(t, err)
+				tok, err := getAccountCredentialFromKeychain()
+				require.NoError(t, err)
+				assert.NotNil(t, tok.Extra("id_token"))
+				return
+			}
+
+			code, _ := GetExitCode(err)
+			assert.Equal(t, tt.exitCode, code, err)
+		})
+	}
+}
+
+func TestLoginCommand_ParseRequiresExperimentalDuo(t *testing.T) {
+	var login LoginCommand
+	flags := loginCmd.Flags()
+	t.Cleanup(func() {
+		flags.Set(FlagMFAFactor, "")
+		flags.Set(FlagExperimentalDuo, "false")
+	})
+
+	require.NoError(t, flags.Set(FlagMFAFactor, "duo"))
+	err := login.Parse(flags, nil)
+	code, _ := GetExitCode(err)
+	assert.Equal(t, ExitCodeValueError, code, err)
+
+	require.NoError(t, flags.Set(FlagExperimentalDuo, "true"))
+	require.NoError(t, login.Parse(flags, nil))
+	assert.True(t, login.ExperimentalDuo)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"gopkg.in/yaml.v3"
+)
+
+// ApplicationTypeAWS is the type of applications which federate into an AWS account.
+const ApplicationTypeAWS = "aws"
+
+// ApplicationRule matches Okta applications by their app name, their label, or both.
+type ApplicationRule struct {
+	// AppName is the name of the Okta application integration, such as amazon_aws. Custom SAML applications have a generated name, which can be found in the URL of the application in the Okta admin console.
+	AppName string `yaml:"app_name"`
+	// Label is a regular expression which the label of the application must match.
+	Label string `yaml:"label"`
+	// Type is the type assigned to applications matched by the rule. It is ignored for exclusion rules.
+	Type string `yaml:"type"`
+
+	label *regexp.Regexp
+}
+
+func (r *ApplicationRule) compile() error {
+	if r.AppName == "" && r.Label == "" {
+		return errors.New("a rule must specify app_name, label or both")
+	}
+
+	if r.Label == "" {
+		return nil
+	}
+
+	label, err := regexp.Compile(r.Label)
+	if err != nil {
+		return fmt.Errorf("invalid label pattern %q: %w", r.Label, err)
+	}
+
+	r.label = label
+	return nil
+}
+
+func (r ApplicationRule) matches(app *okta.AppLink) bool {
+	if r.AppName != "" && r.AppName != app.AppName {
+		return false
+	}
+
+	return r.label == nil || r.label.MatchString(app.Label)
+}
+
+// ApplicationFilter decides which of the applications assigned to a user are served to the CLI, and the type of each.
+//
+// An application is served if it matches one of the Include rules and none of the Exclude rules. The type of the application is taken from the first Include rule it matches.
+type ApplicationFilter struct {
+	Include []ApplicationRule `yaml:"include"`
+	Exclude []ApplicationRule `yaml:"exclude"`
+}
+
+// DefaultApplicationFilter serves the applications created from the AWS Account Federation integration in the Okta catalog.
+var DefaultApplicationFilter = ApplicationFilter{
+	Include: []ApplicationRule{{AppName: "amazon_aws", Type: ApplicationTypeAWS}},
+}
+
+// ReadApplicationFilter reads an ApplicationFilter from YAML, such as:
+//
+//	include:
+//	  - app_name: amazon_aws
+//	    type: aws
+//	  - app_name: examplecorp_awscustomsaml_1
+//	    label: "^AWS - "
+//	    type: aws
+//	exclude:
+//	  - label: "(?i)deprecated"
+func ReadApplicationFilter(r io.Reader) (ApplicationFilter, error) {
+	var filter ApplicationFilter
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&filter); err != nil && !errors.Is(err, io.EOF) {
+		return filter, err
+	}
+
+	if len(filter.Include) == 0 {
+		return filter, errors.New("at least one include rule is required")
+	}
+
+	for idx := range filter.Include {
+		if filter.Include[idx].Type == "" {
+			return filter, fmt.Errorf("include rule %d: a type is required", idx+1)
+		}
+
+		if err := filter.Include[idx].compile(); err != nil {
+			return filter, fmt.Errorf("include rule %d: %w", idx+1, err)
+		}
+	}
+
+	for idx := range filter.Exclude {
+		if err := filter.Exclude[idx].compile(); err != nil {
+			return filter, fmt.Errorf("exclude rule %d: %w", idx+1, err)
+		}
+	}
+
+	return filter, nil
+}
+
+// Type returns the type of app, and false if app should not be served.
+func (f ApplicationFilter) Type(app *okta.AppLink) (string, bool) {
+	for _, rule := range f.Exclude {
+		if rule.matches(app) {
+			return "", false
+		}
+	}
+
+	for _, rule := range f.Include {
+		if rule.matches(app) {
+			return rule.Type, true
+		}
+	}
+
+	return "", false
+}
+
+// Applications returns the applications in links that should be served.
+func (f ApplicationFilter) Applications(links []*okta.AppLink) []Application {
+	var apps []Application
+	for _, link := range links {
+		if appType, ok := f.Type(link); ok {
+			apps = append(apps, Application{
+				ID:   link.AppInstanceId,
+				Name: link.Label,
+				Type: appType,
+			})
+		}
+	}
+
+	return apps
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplicationFilter(t *testing.T) {
+	filter, err := ReadApplicationFilter(strings.NewReader(`
+include:
+  - app_name: amazon_aws
+    type: aws
+  - app_name: examplecorp_awscustomsaml_1
+    label: "^AWS - "
+    type: aws
+  - label: "^Tencent - "
+    type: tencent
+exclude:
+  - label: "(?i)deprecated"
+`))
+	require.NoError(t, err)
+
+	links := []*okta.AppLink{
+		{AppInstanceId: "1", AppName: "amazon_aws", Label: "AWS - Production"},
+		{AppInstanceId: "2", AppName: "examplecorp_awscustomsaml_1", Label: "AWS - Custom"},
+		{AppInstanceId: "3", AppName: "examplecorp_awscustomsaml_1", Label: "Not AWS"},
+		{AppInstanceId: "4", AppName: "examplecorp_tencent_1", Label: "Tencent - Games"},
+		{AppInstanceId: "5", AppName: "amazon_aws", Label: "AWS - Deprecated Sandbox"},
+		{AppInstanceId: "6", AppName: "slack", Label: "Slack"},
+	}
+
+	assert.Equal(t, []Application{
+		{ID: "1", Name: "AWS - Production", Type: "aws"},
+		{ID: "2", Name: "AWS - Custom", Type: "aws"},
+		{ID: "4", Name: "Tencent - Games", Type: "tencent"},
+	}, filter.Applications(links))
+}
+
+func TestDefaultApplicationFilter(t *testing.T) {
+	links := []*okta.AppLink{
+		{AppInstanceId: "1", AppName: "amazon_aws", Label: "AWS - Production"},
+		{AppInstanceId: "2", AppName: "examplecorp_awscustomsaml_1", Label: "AWS - Custom"},
+	}
+
+	assert.Equal(t, []Application{{ID: "1", Name: "AWS - Production", Type: "aws"}}, DefaultApplicationFilter.Applications(links))
+}
+
+func TestReadApplicationFilter_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"NoIncludeRules":   `exclude: [{label: foo}]`,
+		"MissingType":      `include: [{app_name: amazon_aws}]`,
+		"EmptyRule":        `include: [{type: aws}]`,
+		"InvalidPattern":   `include: [{label: "(", type: aws}]`,
+		"UnknownField":     `include: [{app: amazon_aws, type: aws}]`,
+		"InvalidExclusion": "include: [{app_name: amazon_aws, type: aws}]\nexclude: [{label: \"[\"}]",
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadApplicationFilter(strings.NewReader(doc))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+// AuditService retrieves the applications in Okta and who is assigned to them.
+type AuditService interface {
+	ListApplicationsByName(ctx context.Context, name string) ([]*okta.Application, error)
+	ListApplicationUsers(ctx context.Context, appID string) ([]*okta.AppUser, error)
+	ListApplicationGroups(ctx context.Context, appID string) ([]*okta.ApplicationGroupAssignment, error)
+}
+
+// Audit configures the report of who can access each AWS application.
+type Audit struct {
+	Service AuditService
+	// ClientID is the client ID of the KeyConjurer OIDC application, which every AWS application must allow as its Web SSO client.
+	ClientID string
+	// Groups are the groups whose members may retrieve the report.
+	Groups []string
+}
+
+// AuditReport lists every AWS application in Okta and the users and groups assigned to it.
+type AuditReport struct {
+	GeneratedAt  time.Time          `json:"generated_at"`
+	ClientID     string             `json:"client_id"`
+	Applications []AuditApplication `json:"applications"`
+}
+
+// AuditApplication is an AWS application and its assignments.
+type AuditApplication struct {
+	ID     string `json:"id"`
+	Label  string `json:"label"`
+	Status string `json:"status"`
+	// WebSSOClientID is the Allowed Web SSO Client of the application.
+	WebSSOClientID string `json:"web_sso_client_id"`
+	// ClientIDMismatch indicates that WebSSOClientID is not the KeyConjurer client ID, so users cannot retrieve credentials for the application through KeyConjurer.
+	ClientIDMismatch bool         `json:"client_id_mismatch"`
+	Users            []AuditUser  `json:"users"`
+	Groups           []AuditGroup `json:"groups"`
+}
+
+// AuditUser is a user assigned to an application.
+type AuditUser struct {
+	ID    string `json:"id"`
+	Login string `json:"login"`
+	// Scope is USER if the user is assigned directly, or GROUP if they are assigned through a group.
+	Scope string `json:"scope"`
+}
+
+// AuditGroup is a group assigned to an application.
+type AuditGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// maxConcurrentAuditRequests limits the number of applications whose assignments are retrieved at once.
+const maxConcurrentAuditRequests = 4
+
+// BuildAuditReport retrieves every application using the AWS Account Federation integration and its assignments.
+//
+// Unlike metadata, the report must be complete, so an error retrieving any application fails the report.
+func BuildAuditReport(ctx context.Context, svc AuditService, clientID string, now time.Time) (AuditReport, error) {
+	report := AuditReport{GeneratedAt: now, ClientID: clientID}
+	apps, err := svc.ListApplicationsByName(ctx, "amazon_aws")
+	if err != nil {
+		return report, err
+	}
+
+	report.Applications = make([]AuditApplication, len(apps))
+	errs := make([]error, len(apps))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentAuditRequests)
+	for idx, app := range apps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			report.Applications[idx], errs[idx] = auditApplication(ctx, svc, clientID, app)
+		}()
+	}
+
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return report, err
+	}
+
+	slices.SortFunc(report.Applications, func(a, b AuditApplication) int {
+		return strings.Compare(strings.ToLower(a.Label), strings.ToLower(b.Label))
+	})
+
+	return report, nil
+}
+
+func auditApplication(ctx context.Context, svc AuditService, clientID string, app *okta.Application) (AuditApplication, error) {
+	entry := AuditApplication{ID: app.Id, Label: app.Label, Status: app.Status, Users: []AuditUser{}, Groups: []AuditGroup{}}
+	if app.Settings != nil && app.Settings.App != nil {
+		entry.WebSSOClientID, _ = (*app.Settings.App)["webSSOAllowedClient"].(string)
+	}
+	entry.ClientIDMismatch = entry.WebSSOClientID != clientID
+
+	users, err := svc.ListApplicationUsers(ctx, app.Id)
+	if err != nil {
+		return entry, err
+	}
+
+	for _, user := range users {
+		login := embeddedString(user.Embedded, "user", "profile", "login")
+		if login == "" && user.Credentials != nil {
+			login = user.Credentials.UserName
+		}
+
+		entry.Users = append(entry.Users, AuditUser{ID: user.Id, Login: login, Scope: user.Scope})
+	}
+
+	groups, err := svc.ListApplicationGroups(ctx, app.Id)
+	if err != nil {
+		return entry, err
+	}
+
+	for _, group := range groups {
+		entry.Groups = append(entry.Groups, AuditGroup{ID: group.Id, Name: embeddedString(group.Embedded, "group", "profile", "name")})
+	}
+
+	slices.SortFunc(entry.Users, func(a, b AuditUser) int { return strings.Compare(a.Login, b.Login) })
+	slices.SortFunc(entry.Groups, func(a, b AuditGroup) int { return strings.Compare(a.Name, b.Name) })
+	return entry, nil
+}
+
+// embeddedString returns the string at the given path within the _embedded object of an Okta resource, or an empty string if there is none.
+func embeddedString(embedded any, path ...string) string {
+	value := embedded
+	for _, key := range path {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = obj[key]
+	}
+
+	s, _ := value.(string)
+	return s
+}
+
+// auditCSVHeader is the header of the CSV representation of an AuditReport.
+var auditCSVHeader = []string{"application_id", "application_label", "status", "web_sso_client_id", "client_id_mismatch", "principal_type", "principal_id", "principal_name", "assignment_scope"}
+
+// WriteCSV writes the report as CSV, with one row for each user or group assigned to each application.
+// Applications with no assignments are written as a single row with no principal.
+func (r AuditReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(auditCSVHeader)
+	for _, app := range r.Applications {
+		prefix := []string{app.ID, app.Label, app.Status, app.WebSSOClientID, strconv.FormatBool(app.ClientIDMismatch)}
+		if len(app.Users) == 0 && len(app.Groups) == 0 {
+			cw.Write(escapeCSVFormulas(slices.Concat(prefix, []string{"", "", "", ""})))
+		}
+
+		for _, user := range app.Users {
+			cw.Write(escapeCSVFormulas(slices.Concat(prefix, []string{"user", user.ID, user.Login, user.Scope})))
+		}
+
+		for _, group := range app.Groups {
+			cw.Write(escapeCSVFormulas(slices.Concat(prefix, []string{"group", group.ID, group.Name, ""})))
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// escapeCSVFormulas prefixes cells which a spreadsheet would interpret as a formula with a single quote, as labels and group names are chosen by Okta administrators and users rather than KeyConjurer.
+func escapeCSVFormulas(row []string) []string {
+	for idx, cell := range row {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			row[idx] = "'" + cell
+		}
+	}
+
+	return row
+}
+
+// HandleAudit serves the audit report to members of the audit groups, as JSON or, if format=csv is given, as CSV.
+func (s ServeUserApplicationsHandler) HandleAudit(ctx context.Context, r Request) (w Response, err error) {
+	claims, ok := s.authenticate(ctx, r, &w)
+	if !ok {
+		return w, nil
+	}
+
+	requestAttrs := append(RequestAttrs(r), slog.String("username", claims.Username()))
+	if s.Audit == nil || !slices.ContainsFunc(s.Audit.Groups, func(group string) bool { return slices.Contains(claims.Groups, group) }) {
+		slog.Warn("denied access to the audit report", requestAttrs...)
+		ServeJSONError(&w, http.StatusForbidden, "forbidden")
+		return w, nil
+	}
+
+	format := r.Query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		ServeJSONError(&w, http.StatusBadRequest, "format must be json or csv")
+		return w, nil
+	}
+
+	report, err := BuildAuditReport(ctx, s.Audit.Service, s.Audit.ClientID, time.Now().UTC())
+	if err != nil {
+		serveListError(&w, err, requestAttrs)
+		return w, nil
+	}
+
+	requestAttrs = append(requestAttrs, slog.Int("application_count", len(report.Applications)))
+	slog.Info("served audit report", requestAttrs...)
+	if format != "csv" {
+		ServeJSON(&w, report)
+		w.SetHeader("Cache-Control", "no-store")
+		return w, nil
+	}
+
+	var buf strings.Builder
+	report.WriteCSV(&buf)
+	w.StatusCode = http.StatusOK
+	w.Body = buf.String()
+	w.SetHeader("Content-Type", "text/csv; charset=utf-8")
+	w.SetHeader("Content-Disposition", `attachment; filename="keyconjurer-audit.csv"`)
+	w.SetHeader("Cache-Control", "no-store")
+	return w, nil
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeOktaAPI returns a server emulating the parts of the Okta API used by the audit report.
+// The users of the production application are split over two pages to exercise pagination.
+func newFakeOktaAPI(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The Okta SDK only decodes JSON responses.
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("GET /api/v1/apps", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `name eq "amazon_aws"`, r.URL.Query().Get("filter"))
+		fmt.Fprint(w, `[
+			{"id": "0oa2", "name": "amazon_aws", "label": "AWS - Staging", "status": "ACTIVE", "settings": {"app": {"webSSOAllowedClient": "0oaOther"}}},
+			{"id": "0oa1", "name": "amazon_aws", "label": "AWS - Production", "status": "ACTIVE", "settings": {"app": {"webSSOAllowedClient": "0oaKeyConjurer"}}}
+		]`)
+	})
+	mux.HandleFunc("GET /api/v1/apps/0oa1/users", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user", r.URL.Query().Get("expand"))
+		if r.URL.Query().Get("after") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/apps/0oa1/users?after=00u1&expand=user>; rel="next"`, srv.URL))
+			fmt.Fprint(w, `[{"id": "00u1", "scope": "USER", "_embedded": {"user": {"profile": {"login": "bob@example.com"}}}}]`)
+			return
+		}
+
+		fmt.Fprint(w, `[{"id": "00u2", "scope": "GROUP", "credentials": {"userName": "alice@example.com"}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/apps/0oa1/groups", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "group", r.URL.Query().Get("expand"))
+		fmt.Fprint(w, `[{"id": "00g1", "_embedded": {"group": {"profile": {"name": "SRE"}}}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/apps/0oa2/users", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("GET /api/v1/apps/0oa2/groups", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	return srv
+}
+
+func newFakeOktaService(t *testing.T) Okta {
+	srv := newFakeOktaAPI(t)
+	domain, _ := url.Parse(srv.URL)
+	return NewOktaService(domain, "token", srv.Client())
+}
+
+func TestBuildAuditReport(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report, err := BuildAuditReport(context.Background(), newFakeOktaService(t), "0oaKeyConjurer", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, AuditReport{
+		GeneratedAt: now,
+		ClientID:    "0oaKeyConjurer",
+		Applications: []AuditApplication{
+			{
+				ID:             "0oa1",
+				Label:          "AWS - Production",
+				Status:         "ACTIVE",
+				WebSSOClientID: "0oaKeyConjurer",
+				Users: []AuditUser{
+					{ID: "00u2", Login: "alice@example.com", Scope: "GROUP"},
+					{ID: "00u1", Login: "bob@example.com", Scope: "USER"},
+				},
+				Groups: []AuditGroup{{ID: "00g1", Name: "SRE"}},
+			},
+			{
+				ID:               "0oa2",
+				Label:            "AWS - Staging",
+				Status:           "ACTIVE",
+				WebSSOClientID:   "0oaOther",
+				ClientIDMismatch: true,
+				Users:            []AuditUser{},
+				Groups:           []AuditGroup{},
+			},
+		},
+	}, report)
+}
+
+func TestAuditReport_WriteCSV(t *testing.T) {
+	report, err := BuildAuditReport(context.Background(), newFakeOktaService(t), "0oaKeyConjurer", time.Now())
+	require.NoError(t, err)
+
+	var buf strings.Builder
+	require.NoError(t, report.WriteCSV(&buf))
+	assert.Equal(t, `application_id,application_label,status,web_sso_client_id,client_id_mismatch,principal_type,principal_id,principal_name,assignment_scope
+0oa1,AWS - Production,ACTIVE,0oaKeyConjurer,false,user,00u2,alice@example.com,GROUP
+0oa1,AWS - Production,ACTIVE,0oaKeyConjurer,false,user,00u1,bob@example.com,USER
+0oa1,AWS - Production,ACTIVE,0oaKeyConjurer,false,group,00g1,SRE,
+0oa2,AWS - Staging,ACTIVE,0oaOther,true,,,,
+`, buf.String())
+}
+
+func TestAuditReport_WriteCSVEscapesFormulas(t *testing.T) {
+	report := AuditReport{Applications: []AuditApplication{{
+		ID:     "0oa1",
+		Label:  "=HYPERLINK(\"https://evil.example.com\")",
+		Status: "ACTIVE",
+		Groups: []AuditGroup{{ID: "00g1", Name: "@SRE"}, {ID: "00g2", Name: "+1"}, {ID: "00g3", Name: "-1"}, {ID: "00g4", Name: "Team-A"}},
+	}}}
+
+	var buf strings.Builder
+	require.NoError(t, report.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		`0oa1,"'=HYPERLINK(""https://evil.example.com"")",ACTIVE,,false,group,00g1,'@SRE,`,
+		`0oa1,"'=HYPERLINK(""https://evil.example.com"")",ACTIVE,,false,group,00g2,'+1,`,
+		`0oa1,"'=HYPERLINK(""https://evil.example.com"")",ACTIVE,,false,group,00g3,'-1,`,
+		`0oa1,"'=HYPERLINK(""https://evil.example.com"")",ACTIVE,,false,group,00g4,Team-A,`,
+	}, lines[1:])
+}
+
+func TestServeUserApplicationsHandler_Audit(t *testing.T) {
+	validator := fixedValidator{claims: Claims{PreferredUsername: "auditor@example.com", Groups: []string{"Everyone"}}}
+	h := ServeUserApplicationsHandler{
+		Tokens: &validator,
+		Audit:  &Audit{Service: newFakeOktaService(t), ClientID: "0oaKeyConjurer", Groups: []string{"Auditors"}},
+	}
+	routes := h.Routes()
+	req := Request{Method: "GET", Path: "/v3/audit", Headers: http.Header{"Authorization": {"Bearer token"}}, Query: url.Values{}}
+
+	w, err := routes.Handle(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, w.Status())
+
+	validator.claims.Groups = append(validator.claims.Groups, "Auditors")
+	w, err = routes.Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+
+	var report AuditReport
+	require.NoError(t, json.Unmarshal([]byte(w.Body), &report))
+	assert.Len(t, report.Applications, 2)
+
+	req.Query.Set("format", "csv")
+	w, err = routes.Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+	assert.Equal(t, "text/csv; charset=utf-8", w.Headers.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Body, "application_id,"))
+}
+
+func TestServeUserApplicationsHandler_AuditNotServedUnlessConfigured(t *testing.T) {
+	h := ServeUserApplicationsHandler{Tokens: &fixedValidator{}}
+	w, err := h.Routes().Handle(context.Background(), Request{Method: "GET", Path: "/v3/audit", Headers: http.Header{"Authorization": {"Bearer token"}}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, w.Status())
+}
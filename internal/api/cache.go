@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+// CacheBackend stores values for a limited amount of time.
+//
+// The in-memory backend is local to a single process, so deployments running many instances of the webserver, such as Lambda, may wish to use an external backend such as RedisCache so that entries are shared and can be invalidated everywhere at once.
+// Implementations must be safe for concurrent use.
+type CacheBackend interface {
+	// Get returns the value stored under key. ok is false if there is no value or it has expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCache is a CacheBackend which stores values in memory.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	// nextSweep is the number of entries at which expired entries are next removed.
+	nextSweep int
+	now       func() time.Time
+}
+
+type memoryCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// minSweepSize is the smallest number of entries a MemoryCache will hold before removing expired entries.
+const minSweepSize = 1024
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:   make(map[string]memoryCacheEntry),
+		nextSweep: minSweepSize,
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || !m.now().Before(entry.expiresAt) {
+		return nil, false, nil
+	}
+
+	return entry.value, true, nil
+}
+
+func (m *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryCacheEntry{value: value, expiresAt: m.now().Add(ttl)}
+	if len(m.entries) >= m.nextSweep {
+		m.sweep()
+	}
+
+	return nil
+}
+
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// sweep removes expired entries. Entries are only removed once the cache has doubled in size since the last sweep, which keeps the cost of sweeping proportional to the number of writes.
+func (m *MemoryCache) sweep() {
+	now := m.now()
+	for key, entry := range m.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+
+	m.nextSweep = max(2*len(m.entries), minSweepSize)
+}
+
+// ApplicationCache caches the applications assigned to each user so that Okta is not queried on every request.
+type ApplicationCache struct {
+	Backend CacheBackend
+	TTL     time.Duration
+}
+
+// cacheKey returns the key that the applications for user are stored under. Okta usernames are not case sensitive.
+func (c ApplicationCache) cacheKey(user string) string {
+	return "keyconjurer:applications:" + strings.ToLower(user)
+}
+
+// metadataKey returns the key that the metadata of an application is stored under.
+func (c ApplicationCache) metadataKey(appID string) string {
+	return "keyconjurer:application-metadata:" + appID
+}
+
+// Get returns the cached applications for user. A failure to read from the cache is treated as a miss.
+func (c ApplicationCache) Get(ctx context.Context, user string) ([]*okta.AppLink, bool) {
+	var links []*okta.AppLink
+	ok := c.get(ctx, c.cacheKey(user), &links)
+	return links, ok
+}
+
+// Set stores the applications for user. A failure to write to the cache is logged but otherwise ignored.
+func (c ApplicationCache) Set(ctx context.Context, user string, links []*okta.AppLink) {
+	c.set(ctx, c.cacheKey(user), links)
+}
+
+// GetMetadata returns the cached metadata of the application with the given ID.
+//
+// Unlike the applications of a user, metadata is shared by every user the application is assigned to.
+func (c ApplicationCache) GetMetadata(ctx context.Context, appID string) (ApplicationMetadata, bool) {
+	var metadata ApplicationMetadata
+	ok := c.get(ctx, c.metadataKey(appID), &metadata)
+	return metadata, ok
+}
+
+// SetMetadata stores the metadata of the application with the given ID.
+func (c ApplicationCache) SetMetadata(ctx context.Context, appID string, metadata ApplicationMetadata) {
+	c.set(ctx, c.metadataKey(appID), metadata)
+}
+
+func (c ApplicationCache) get(ctx context.Context, key string, v any) bool {
+	buf, ok, err := c.Backend.Get(ctx, key)
+	if err != nil {
+		slog.Warn("could not read from the application cache", slog.String("error", err.Error()))
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		slog.Warn("could not decode cached value", slog.String("key", key), slog.String("error", err.Error()))
+		return false
+	}
+
+	return true
+}
+
+func (c ApplicationCache) set(ctx context.Context, key string, v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		slog.Warn("could not encode value for the cache", slog.String("key", key), slog.String("error", err.Error()))
+		return
+	}
+
+	if err := c.Backend.Set(ctx, key, buf, c.TTL); err != nil {
+		slog.Warn("could not write to the application cache", slog.String("error", err.Error()))
+	}
+}
+
+// Invalidate removes the cached applications for user, if any.
+func (c ApplicationCache) Invalidate(ctx context.Context, user string) error {
+	return c.Backend.Delete(ctx, c.cacheKey(user))
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingOktaService returns the same applications for every user, counting the number of requests made.
+type countingOktaService struct {
+	links []*okta.AppLink
+	calls int
+}
+
+func (c *countingOktaService) ListApplicationsForUser(context.Context, string) ([]*okta.AppLink, error) {
+	c.calls++
+	return c.links, nil
+}
+
+func TestMemoryCache_ExpiresEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, cache.Set(ctx, "key", []byte("value"), time.Minute))
+	value, ok, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "value", string(value))
+
+	now = now.Add(time.Minute)
+	_, ok, err = cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryCache_SweepsExpiredEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := range minSweepSize - 1 {
+		require.NoError(t, cache.Set(ctx, string(rune(i)), nil, time.Second))
+	}
+
+	now = now.Add(time.Second)
+	require.NoError(t, cache.Set(ctx, "fresh", nil, time.Second))
+	assert.Len(t, cache.entries, 1)
+}
+
+func TestApplicationCache_KeysAreCaseInsensitive(t *testing.T) {
+	ctx := context.Background()
+	cache := ApplicationCache{Backend: NewMemoryCache(), TTL: time.Minute}
+	cache.Set(ctx, "User@Example.com", []*okta.AppLink{{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Prod"}})
+
+	links, ok := cache.Get(ctx, "user@example.com")
+	require.True(t, ok)
+	require.Len(t, links, 1)
+	assert.Equal(t, "0oa1", links[0].AppInstanceId)
+
+	require.NoError(t, cache.Invalidate(ctx, "USER@EXAMPLE.COM"))
+	_, ok = cache.Get(ctx, "user@example.com")
+	assert.False(t, ok)
+}
+
+func TestServeUserApplicationsHandler_Caching(t *testing.T) {
+	ctx := context.Background()
+	okta := countingOktaService{links: []*okta.AppLink{{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Prod"}}}
+	h := ServeUserApplicationsHandler{
+		Okta:   &okta,
+		Tokens: &fixedValidator{claims: Claims{PreferredUsername: "user@example.com"}},
+		Cache:  &ApplicationCache{Backend: NewMemoryCache(), TTL: 5 * time.Minute},
+	}
+	routes := h.Routes()
+	req := Request{Method: "POST", Path: "/v2/applications", Headers: http.Header{"Authorization": {"Bearer token"}}}
+
+	w, err := routes.Handle(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+	assert.Equal(t, "private, max-age=300", w.Headers.Get("Cache-Control"))
+	etag := w.Headers.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	var apps []Application
+	require.NoError(t, json.Unmarshal([]byte(w.Body), &apps))
+	assert.Equal(t, []Application{{ID: "0oa1", Name: "AWS - Prod", Type: ApplicationTypeAWS}}, apps)
+
+	// The second request is served from the cache, and the client already has the current list.
+	req.Headers.Set("If-None-Match", etag)
+	w, err = routes.Handle(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, w.Status())
+	assert.Empty(t, w.Body)
+	assert.Equal(t, etag, w.Headers.Get("ETag"))
+	assert.Equal(t, 1, okta.calls)
+
+	invalidate := Request{Method: "DELETE", Path: "/v2/applications/cache", Headers: http.Header{"Authorization": {"Bearer token"}}}
+	w, err = routes.Handle(ctx, invalidate)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, w.Status())
+
+	_, err = routes.Handle(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 2, okta.calls)
+}
+
+func TestServeUserApplicationsHandler_InvalidateRequiresAuthentication(t *testing.T) {
+	h := ServeUserApplicationsHandler{Cache: &ApplicationCache{Backend: NewMemoryCache(), TTL: time.Minute}}
+	w, err := h.Routes().Handle(context.Background(), Request{Method: "DELETE", Path: "/v2/applications/cache", Headers: http.Header{}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, w.Status())
+}
+
+func Test_etagMatches(t *testing.T) {
+	etag := `"abc"`
+	assert.True(t, etagMatches(`"abc"`, etag))
+	assert.True(t, etagMatches(`"xyz", W/"abc"`, etag))
+	assert.True(t, etagMatches(`*`, etag))
+	assert.False(t, etagMatches(`"xyz"`, etag))
+	assert.False(t, etagMatches(``, etag))
+}
+
+func TestRouter(t *testing.T) {
+	var rt Router
+	rt.Route("GET", "/things/", RequestHandlerFunc(func(context.Context, Request) (Response, error) {
+		return Response{Body: "things"}, nil
+	}))
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/things", http.StatusOK},
+		{"get", "/things/", http.StatusOK},
+		{"POST", "/things", http.StatusMethodNotAllowed},
+		{"GET", "/other", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w, err := rt.Handle(context.Background(), Request{Method: tt.method, Path: tt.path})
+		require.NoError(t, err)
+		assert.Equal(t, tt.status, w.Status(), "%s %s", tt.method, tt.path)
+	}
+}
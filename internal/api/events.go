@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// RequestHandler handles a request independent of the type of event it was delivered in.
+type RequestHandler interface {
+	Handle(ctx context.Context, r Request) (Response, error)
+}
+
+// EventType is the shape of the event a Lambda function was invoked with.
+type EventType int
+
+const (
+	EventTypeUnknown EventType = iota
+	// EventTypeALB is an event from an Application Load Balancer target group.
+	EventTypeALB
+	// EventTypeAPIGatewayV1 is an event from an API Gateway REST API using the proxy integration.
+	EventTypeAPIGatewayV1
+	// EventTypeAPIGatewayV2 is an event from an API Gateway HTTP API using the version 2.0 payload format.
+	EventTypeAPIGatewayV2
+	// EventTypeFunctionURL is an event from a Lambda Function URL.
+	EventTypeFunctionURL
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventTypeALB:
+		return "alb"
+	case EventTypeAPIGatewayV1:
+		return "apigateway-v1"
+	case EventTypeAPIGatewayV2:
+		return "apigateway-v2"
+	case EventTypeFunctionURL:
+		return "function-url"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrUnknownEventType indicates that the Lambda function was invoked with an event that is not a HTTP request.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// eventProbe contains just enough of each event type to tell them apart.
+type eventProbe struct {
+	Version        string `json:"version"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		ELB        json.RawMessage `json:"elb"`
+		HTTP       json.RawMessage `json:"http"`
+		DomainName string          `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+// DetectEventType determines the type of event from its JSON payload.
+func DetectEventType(payload []byte) (EventType, error) {
+	var probe eventProbe
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return EventTypeUnknown, err
+	}
+
+	switch {
+	case probe.RequestContext.ELB != nil:
+		return EventTypeALB, nil
+	case probe.Version == "2.0" || probe.RequestContext.HTTP != nil:
+		// Function URLs use the same payload as HTTP APIs, but are served from a lambda-url domain.
+		if strings.Contains(probe.RequestContext.DomainName, ".lambda-url.") {
+			return EventTypeFunctionURL, nil
+		}
+		return EventTypeAPIGatewayV2, nil
+	case probe.HTTPMethod != "":
+		return EventTypeAPIGatewayV1, nil
+	default:
+		return EventTypeUnknown, ErrUnknownEventType
+	}
+}
+
+// NewLambdaHandler returns a Lambda handler which serves h behind an ALB, API Gateway REST API, API Gateway HTTP API or Lambda Function URL.
+//
+// The type of event is detected from each payload, and the response is returned in the shape that the invoking service expects.
+func NewLambdaHandler(h RequestHandler) lambda.Handler {
+	return lambdaHandler{h}
+}
+
+type lambdaHandler struct {
+	handler RequestHandler
+}
+
+func (l lambdaHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	eventType, err := DetectEventType(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch eventType {
+	case EventTypeALB:
+		return invoke(ctx, l.handler, payload, requestFromALB, func(event events.ALBTargetGroupRequest, resp Response) any {
+			return albResponse(resp, event.MultiValueHeaders != nil)
+		})
+	case EventTypeAPIGatewayV1:
+		return invoke(ctx, l.handler, payload, requestFromAPIGatewayV1, func(_ events.APIGatewayProxyRequest, resp Response) any {
+			return events.APIGatewayProxyResponse{StatusCode: resp.Status(), MultiValueHeaders: resp.Headers, Body: resp.Body}
+		})
+	case EventTypeAPIGatewayV2:
+		return invoke(ctx, l.handler, payload, requestFromAPIGatewayV2, func(_ events.APIGatewayV2HTTPRequest, resp Response) any {
+			headers, cookies := splitCookies(resp.Headers)
+			return events.APIGatewayV2HTTPResponse{StatusCode: resp.Status(), Headers: headers, Cookies: cookies, Body: resp.Body}
+		})
+	case EventTypeFunctionURL:
+		return invoke(ctx, l.handler, payload, requestFromFunctionURL, func(_ events.LambdaFunctionURLRequest, resp Response) any {
+			headers, cookies := splitCookies(resp.Headers)
+			return events.LambdaFunctionURLResponse{StatusCode: resp.Status(), Headers: headers, Cookies: cookies, Body: resp.Body}
+		})
+	default:
+		return nil, ErrUnknownEventType
+	}
+}
+
+// invoke decodes an event of type E, serves it with h and encodes the response in the shape returned by toResponse.
+func invoke[E any](ctx context.Context, h RequestHandler, payload []byte, toRequest func(E) (Request, error), toResponse func(E, Response) any) ([]byte, error) {
+	var event E
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	req, err := toRequest(event)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.Handle(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(toResponse(event, resp))
+}
+
+func decodeBody(body string, isBase64Encoded bool) (string, error) {
+	if !isBase64Encoded {
+		return body, nil
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", fmt.Errorf("decode body: %w", err)
+	}
+	return string(buf), nil
+}
+
+// headersFromEvent merges single and multi-value header maps into a http.Header.
+//
+// The keys are canonicalized as some integrations lower-case header names and others do not.
+func headersFromEvent(single map[string]string, multi map[string][]string) http.Header {
+	headers := make(http.Header, len(single)+len(multi))
+	for key, value := range single {
+		headers.Set(key, value)
+	}
+
+	for key, values := range multi {
+		headers.Del(key)
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+
+	return headers
+}
+
+func queryFromEvent(single map[string]string, multi map[string][]string) url.Values {
+	query := make(url.Values, len(single)+len(multi))
+	for key, value := range single {
+		query.Set(key, value)
+	}
+
+	for key, values := range multi {
+		query[key] = values
+	}
+
+	return query
+}
+
+func requestFromALB(event events.ALBTargetGroupRequest) (Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	return Request{
+		Method:  event.HTTPMethod,
+		Path:    event.Path,
+		Headers: headersFromEvent(event.Headers, event.MultiValueHeaders),
+		Query:   queryFromEvent(event.QueryStringParameters, event.MultiValueQueryStringParameters),
+		Body:    body,
+	}, err
+}
+
+func requestFromAPIGatewayV1(event events.APIGatewayProxyRequest) (Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	return Request{
+		Method:   event.HTTPMethod,
+		Path:     event.Path,
+		Headers:  headersFromEvent(event.Headers, event.MultiValueHeaders),
+		Query:    queryFromEvent(event.QueryStringParameters, event.MultiValueQueryStringParameters),
+		Body:     body,
+		SourceIP: event.RequestContext.Identity.SourceIP,
+	}, err
+}
+
+func requestFromAPIGatewayV2(event events.APIGatewayV2HTTPRequest) (Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	// The raw query string is preferred because the parsed parameters join repeated values with commas.
+	query, _ := url.ParseQuery(event.RawQueryString)
+	return Request{
+		Method:   event.RequestContext.HTTP.Method,
+		Path:     stripStage(event.RawPath, event.RequestContext.Stage),
+		Headers:  headersFromEvent(event.Headers, nil),
+		Query:    query,
+		Body:     body,
+		SourceIP: event.RequestContext.HTTP.SourceIP,
+	}, err
+}
+
+// stripStage removes the stage from the start of the path of an API Gateway v2 request, which includes it for every stage but $default unless the request came through a custom domain.
+func stripStage(path, stage string) string {
+	if stage == "" || stage == "$default" {
+		return path
+	}
+
+	if rest, ok := strings.CutPrefix(path, "/"+stage); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		if rest == "" {
+			return "/"
+		}
+		return rest
+	}
+
+	return path
+}
+
+func requestFromFunctionURL(event events.LambdaFunctionURLRequest) (Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	query, _ := url.ParseQuery(event.RawQueryString)
+	return Request{
+		Method:   event.RequestContext.HTTP.Method,
+		Path:     event.RawPath,
+		Headers:  headersFromEvent(event.Headers, nil),
+		Query:    query,
+		Body:     body,
+		SourceIP: event.RequestContext.HTTP.SourceIP,
+	}, err
+}
+
+// albResponse converts a response to the shape expected by an ALB target group.
+//
+// If the target group has multi-value headers enabled, the ALB ignores the single-value headers field, so multiValue must reflect the setting on the target group.
+func albResponse(resp Response, multiValue bool) events.ALBTargetGroupResponse {
+	out := events.ALBTargetGroupResponse{
+		StatusCode:        resp.Status(),
+		StatusDescription: fmt.Sprintf("%d %s", resp.Status(), http.StatusText(resp.Status())),
+		Body:              resp.Body,
+	}
+
+	if multiValue {
+		out.MultiValueHeaders = resp.Headers
+		return out
+	}
+
+	// Only one Set-Cookie header can be sent without multi-value headers.
+	out.Headers, _ = splitCookies(resp.Headers)
+	if cookie := resp.Headers.Get("Set-Cookie"); cookie != "" {
+		out.Headers["Set-Cookie"] = cookie
+	}
+	return out
+}
+
+// splitCookies converts headers to the single-value headers and cookies returned by integrations which do not support multi-value headers.
+//
+// The values of other headers are joined with commas, which RFC 9110 permits for any header that may be repeated. Set-Cookie headers cannot be joined, so they are returned as cookies instead.
+func splitCookies(h http.Header) (map[string]string, []string) {
+	headers := make(map[string]string, len(h))
+	var cookies []string
+	for key, values := range h {
+		if http.CanonicalHeaderKey(key) == "Set-Cookie" {
+			cookies = append(cookies, values...)
+			continue
+		}
+
+		headers[key] = strings.Join(values, ", ")
+	}
+
+	return headers, cookies
+}
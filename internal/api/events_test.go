@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler records the request it was given and returns a fixed response.
+type recordingHandler struct {
+	request Request
+}
+
+func (h *recordingHandler) Handle(_ context.Context, r Request) (Response, error) {
+	h.request = r
+	var w Response
+	ServeJSONError(&w, http.StatusForbidden, "unauthorized")
+	return w, nil
+}
+
+func TestLambdaHandler_EventShapes(t *testing.T) {
+	tests := []struct {
+		file      string
+		eventType EventType
+		body      string
+		// fields that must be present in the response in the shape the integration expects.
+		responseFields []string
+	}{
+		{"alb.json", EventTypeALB, "", []string{"statusCode", "statusDescription", "headers"}},
+		{"alb-multivalue.json", EventTypeALB, "", []string{"statusCode", "statusDescription", "multiValueHeaders"}},
+		{"apigateway-v1.json", EventTypeAPIGatewayV1, "", []string{"statusCode", "multiValueHeaders"}},
+		{"apigateway-v2.json", EventTypeAPIGatewayV2, "", []string{"statusCode", "headers"}},
+		// The path of requests to a named stage starts with the stage, which the router does not expect.
+		{"apigateway-v2-stage.json", EventTypeAPIGatewayV2, "", []string{"statusCode", "headers"}},
+		{"function-url.json", EventTypeFunctionURL, `{"hello":"world"}`, []string{"statusCode", "headers"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			require.NoError(t, err)
+
+			eventType, err := DetectEventType(payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.eventType, eventType)
+
+			var h recordingHandler
+			out, err := NewLambdaHandler(&h).Invoke(context.Background(), payload)
+			require.NoError(t, err)
+
+			assert.Equal(t, "POST", h.request.Method)
+			assert.Equal(t, "/v2/applications", h.request.Path)
+			assert.Equal(t, "Root=1-5f84c7a9-0123456789abcdef01234567", h.request.Headers.Get("X-Amzn-Trace-Id"))
+			assert.Equal(t, tt.body, h.request.Body)
+
+			ts, ok := requestTokenSource(h.request)
+			require.True(t, ok)
+			tok, err := ts.Token()
+			require.NoError(t, err)
+			assert.Equal(t, "access-token", tok.AccessToken)
+
+			var resp map[string]any
+			require.NoError(t, json.Unmarshal(out, &resp))
+			assert.EqualValues(t, http.StatusForbidden, resp["statusCode"])
+			assert.JSONEq(t, `{"error":"unauthorized"}`, resp["body"].(string))
+			for _, field := range tt.responseFields {
+				assert.NotNil(t, resp[field], "response should contain %s", field)
+			}
+		})
+	}
+}
+
+func Test_stripStage(t *testing.T) {
+	tests := []struct{ path, stage, want string }{
+		{"/v2/applications", "$default", "/v2/applications"},
+		{"/prod/v2/applications", "prod", "/v2/applications"},
+		{"/prod", "prod", "/"},
+		// Requests through a custom domain do not include the stage.
+		{"/v2/applications", "prod", "/v2/applications"},
+		{"/production/v2/applications", "prod", "/production/v2/applications"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, stripStage(tt.path, tt.stage), "%s in stage %s", tt.path, tt.stage)
+	}
+}
+
+func Test_splitCookies(t *testing.T) {
+	h := http.Header{}
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+	h.Add("Vary", "Authorization")
+	h.Add("Vary", "Accept")
+
+	headers, cookies := splitCookies(h)
+	assert.Equal(t, map[string]string{"Vary": "Authorization, Accept"}, headers)
+	assert.Equal(t, []string{"a=1", "b=2"}, cookies)
+
+	out := albResponse(Response{Headers: h}, true)
+	assert.Equal(t, []string{"a=1", "b=2"}, out.MultiValueHeaders["Set-Cookie"])
+	out = albResponse(Response{Headers: h}, false)
+	assert.Equal(t, "a=1", out.Headers["Set-Cookie"])
+}
+
+func TestDetectEventType_RejectsOtherEvents(t *testing.T) {
+	_, err := DetectEventType([]byte(`{"Records":[]}`))
+	assert.ErrorIs(t, err, ErrUnknownEventType)
+}
+
+func TestResponse_StatusDefaultsToOK(t *testing.T) {
+	var w Response
+	ServeJSON(&w, []Application{})
+	assert.Equal(t, http.StatusOK, w.Status())
+	assert.Equal(t, "application/json", w.Headers.Get("Content-Type"))
+}
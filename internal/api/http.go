@@ -1,36 +1,75 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"log/slog"
 
-	"github.com/aws/aws-lambda-go/events"
 	"golang.org/x/oauth2"
 )
 
+// Request is an incoming HTTP request, independent of whether it was delivered by a load balancer, API Gateway, a Lambda Function URL or net/http.
+type Request struct {
+	Method   string
+	Path     string
+	Headers  http.Header
+	Query    url.Values
+	Body     string
+	SourceIP string
+}
+
+// Response is an outgoing HTTP response, independent of how it will be delivered to the client.
+type Response struct {
+	StatusCode int
+	Headers    http.Header
+	Body       string
+}
+
+// Status returns the status code of the response, treating an unset status code as success.
+func (w Response) Status() int {
+	if w.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return w.StatusCode
+}
+
+// SetHeader sets a response header, replacing any existing value.
+func (w *Response) SetHeader(key, value string) {
+	if w.Headers == nil {
+		w.Headers = make(http.Header)
+	}
+
+	w.Headers.Set(key, value)
+}
+
 // RequestAttrs returns attributes to be used with slog for the given request.
 //
 // []any is returned instead of []slog.Attr to make it easier to supply the attributes to slog functions using spread, for example:
 //
 //	slog.Error(msg, RequestAttrs(r)...)
-func RequestAttrs(r events.ALBTargetGroupRequest) []any {
+func RequestAttrs(r Request) []any {
 	var attrs []any
 
-	if v, ok := r.Headers["x-amzn-trace-id"]; ok {
-		attrs = append(attrs, slog.String("amz_request_id", v))
+	if v := r.Headers.Get("X-Amzn-Trace-Id"); v != "" {
+		attrs = append(attrs, slog.String("amz_request_id", v), slog.String("trace_id", TraceID(r)))
 	}
 
-	if v, ok := r.Headers["x-forwarded-for"]; ok {
+	if v := r.Headers.Get("X-Forwarded-For"); v != "" {
 		attrs = append(attrs, slog.String("x_forwarded_for", v))
+	} else if r.SourceIP != "" {
+		attrs = append(attrs, slog.String("source_ip", r.SourceIP))
 	}
 
 	return attrs
 }
 
-func requestTokenSource(r events.ALBTargetGroupRequest) (oauth2.TokenSource, bool) {
-	headerValue, ok := r.Headers["authorization"]
-	if !ok {
+func requestTokenSource(r Request) (oauth2.TokenSource, bool) {
+	headerValue := r.Headers.Get("Authorization")
+	if headerValue == "" {
 		return nil, false
 	}
 
@@ -45,3 +84,38 @@ func requestTokenSource(r events.ALBTargetGroupRequest) (oauth2.TokenSource, boo
 	token := oauth2.Token{AccessToken: parts[1]}
 	return oauth2.StaticTokenSource(&token), true
 }
+
+// ETag returns a strong entity tag for a response body.
+func ETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ServeNotModified sets the ETag header of a successful response and, if the client sent a matching If-None-Match header, replaces the response with an empty 304 Not Modified.
+func ServeNotModified(w *Response, r Request) {
+	if w.Status() != http.StatusOK {
+		return
+	}
+
+	etag := ETag(w.Body)
+	w.SetHeader("ETag", etag)
+	if !etagMatches(r.Headers.Get("If-None-Match"), etag) {
+		return
+	}
+
+	w.StatusCode = http.StatusNotModified
+	w.Body = ""
+	w.Headers.Del("Content-Type")
+}
+
+// etagMatches reports whether the value of an If-None-Match header matches etag, using the weak comparison required by RFC 9110.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxRequestBodySize is the largest request body the HTTP server will read.
+//
+// No endpoint currently requires a body, so this is deliberately small.
+const maxRequestBodySize = 1 << 20
+
+// NewServeMux returns a http.Handler which serves the account service over plain HTTP rather than through Lambda.
+func NewServeMux(h ServeUserApplicationsHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+	routes := HTTPHandler(h.requestHandler())
+	mux.Handle("/v2/", routes)
+	mux.Handle("/v3/", routes)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /openapi.yaml", serveOpenAPISpec)
+
+	if h.Metrics != nil {
+		mux.Handle("GET /metrics", promhttp.HandlerFor(h.Metrics.Registry, promhttp.HandlerOpts{}))
+	}
+
+	return mux
+}
+
+// timeoutBody is the body of the response sent when a request takes longer than the timeout given to TimeoutHandler.
+const timeoutBody = `{"error":"request timed out"}`
+
+// TimeoutHandler is like http.TimeoutHandler, but responds to requests which time out with a JSON error in the same shape as the other errors of the API.
+func TimeoutHandler(h http.Handler, dt time.Duration) http.Handler {
+	timeout := http.TimeoutHandler(h, dt, timeoutBody)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout.ServeHTTP(timeoutResponseWriter{w}, r)
+	})
+}
+
+// timeoutResponseWriter sets the Content-Type of the response written by http.TimeoutHandler when a request times out.
+//
+// http.TimeoutHandler only writes to the underlying ResponseWriter once the handler has finished, copying the headers the handler set, so a response without a Content-Type can only be the timeout response.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutResponseWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// HTTPHandler adapts a RequestHandler to net/http.
+func HTTPHandler(h RequestHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := requestFromHTTP(r)
+		if err != nil {
+			slog.Error("could not read request body", slog.String("error", err.Error()))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := h.Handle(r.Context(), req)
+		if err != nil {
+			slog.Error("handler failed", slog.String("error", err.Error()))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeResponse(w, resp)
+	})
+}
+
+func requestFromHTTP(r *http.Request) (Request, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxRequestBodySize))
+	if err != nil {
+		return Request{}, err
+	}
+
+	headers := r.Header.Clone()
+	if r.Host != "" {
+		headers.Set("Host", r.Host)
+	}
+
+	// The port is removed so that the source IP is the same as in Lambda events.
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	return Request{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Headers:  headers,
+		Query:    r.URL.Query(),
+		Body:     string(body),
+		SourceIP: sourceIP,
+	}, nil
+}
+
+func writeResponse(w http.ResponseWriter, resp Response) {
+	for key, values := range resp.Headers {
+		w.Header()[key] = values
+	}
+
+	w.WriteHeader(resp.Status())
+	io.WriteString(w, resp.Body)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_requestFromHTTP(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://keyconjurer.example.com/v2/applications?foo=bar", strings.NewReader("body"))
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("X-Amzn-Trace-Id", "Root=1-abc")
+
+	req, err := requestFromHTTP(r)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/v2/applications", req.Path)
+	assert.Equal(t, "Root=1-abc", req.Headers.Get("X-Amzn-Trace-Id"))
+	assert.Equal(t, "keyconjurer.example.com", req.Headers.Get("Host"))
+	assert.Equal(t, "bar", req.Query.Get("foo"))
+	assert.Equal(t, "body", req.Body)
+	assert.Equal(t, "192.0.2.1", req.SourceIP)
+
+	ts, ok := requestTokenSource(req)
+	require.True(t, ok)
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token", tok.AccessToken)
+}
+
+func TestServeMux_RejectsRequestsWithoutBearerToken(t *testing.T) {
+	mux := NewServeMux(ServeUserApplicationsHandler{})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/v2/applications", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"unauthorized"}`, w.Body.String())
+}
+
+func TestServeMux_ServesHealthCheck(t *testing.T) {
+	mux := NewServeMux(ServeUserApplicationsHandler{})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/v2/applications", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestTimeoutHandler_RespondsWithJSON(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}), time.Millisecond)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v3/applications", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"request timed out"}`, w.Body.String())
+
+	// Responses from the handler keep the Content-Type it set.
+	h = TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}), time.Minute)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v3/applications", nil))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+}
@@ -4,11 +4,9 @@ import (
 	"encoding/json"
 
 	"log/slog"
-
-	"github.com/aws/aws-lambda-go/events"
 )
 
-func ServeJSON[T any](w *events.ALBTargetGroupResponse, data T) {
+func ServeJSON[T any](w *Response, data T) {
 	buf, err := json.Marshal(data)
 	if err != nil {
 		// Nothing to be done here
@@ -16,11 +14,7 @@ func ServeJSON[T any](w *events.ALBTargetGroupResponse, data T) {
 		return
 	}
 
-	if w.Headers == nil {
-		w.Headers = make(map[string]string)
-	}
-
-	w.Headers["Content-Type"] = "application/json"
+	w.SetHeader("Content-Type", "application/json")
 	w.Body = string(buf)
 }
 
@@ -28,7 +22,7 @@ type JSONError struct {
 	Message string `json:"error"`
 }
 
-func ServeJSONError(w *events.ALBTargetGroupResponse, statusCode int, msg string) {
+func ServeJSONError(w *Response, statusCode int, msg string) {
 	w.StatusCode = statusCode
 	ServeJSON(w, JSONError{Message: msg})
 }
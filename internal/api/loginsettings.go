@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// LoginSettings tell the CLI how to host the server which receives the OAuth2 callback when a user logs in, so that its redirect URI matches one registered for the KeyConjurer OIDC application.
+//
+// Fields which are empty are left to the defaults of the CLI.
+type LoginSettings struct {
+	// CallbackPorts are the ports the callback server may listen on, in order of preference. A port of 0 lets the operating system choose a port, which is only suitable for providers that accept any port in a loopback redirect URI.
+	CallbackPorts []string `json:"callback_ports,omitempty"`
+	// CallbackBindAddress is the loopback IP address the callback server listens on, such as 127.0.0.1 or ::1.
+	CallbackBindAddress string `json:"callback_bind_address,omitempty"`
+	// CallbackRedirectHost is the host of the redirect URI, which must be localhost or a loopback IP address such as 127.0.0.1 or [::1].
+	CallbackRedirectHost string `json:"callback_redirect_host,omitempty"`
+}
+
+// Validate returns an error if any of the settings are invalid.
+func (s LoginSettings) Validate() error {
+	for _, port := range s.CallbackPorts {
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || port != strconv.FormatUint(n, 10) {
+			return fmt.Errorf("invalid callback port %q", port)
+		}
+	}
+
+	// The settings are served without authentication, so they must not be able to make the CLI listen on, or send authorization codes to, anything but the machine it runs on.
+	if ip := net.ParseIP(strings.Trim(s.CallbackBindAddress, "[]")); s.CallbackBindAddress != "" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("callback bind address %q is not a loopback IP address", s.CallbackBindAddress)
+	}
+
+	if ip := net.ParseIP(strings.Trim(s.CallbackRedirectHost, "[]")); s.CallbackRedirectHost != "" && s.CallbackRedirectHost != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("callback redirect host %q must be localhost or a loopback IP address", s.CallbackRedirectHost)
+	}
+
+	return nil
+}
+
+// HandleLoginSettings serves the LoginSettings. They are needed before the user has logged in, so no token is required.
+func (s ServeUserApplicationsHandler) HandleLoginSettings(_ context.Context, _ Request) (w Response, err error) {
+	ServeJSON(&w, s.LoginSettings)
+	w.SetHeader("Cache-Control", "public, max-age=300")
+	return w, nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginSettings_Validate(t *testing.T) {
+	valid := []LoginSettings{
+		{},
+		{CallbackPorts: []string{"57468", "0"}},
+		{CallbackBindAddress: "::1", CallbackRedirectHost: "[::1]"},
+		{CallbackBindAddress: "[::1]", CallbackRedirectHost: "localhost"},
+		{CallbackBindAddress: "127.0.0.1", CallbackRedirectHost: "127.0.0.1"},
+		{CallbackBindAddress: "127.0.0.2", CallbackRedirectHost: "127.0.0.2"},
+	}
+	for _, settings := range valid {
+		assert.NoError(t, settings.Validate(), "%+v", settings)
+	}
+
+	invalid := []LoginSettings{
+		{CallbackPorts: []string{"65536"}},
+		{CallbackPorts: []string{"-1"}},
+		{CallbackPorts: []string{"057468"}},
+		{CallbackBindAddress: "localhost"},
+		{CallbackRedirectHost: "localhost:57468"},
+		{CallbackRedirectHost: "http://localhost"},
+		// The settings are served without authentication, so they may only refer to the machine the CLI runs on.
+		{CallbackBindAddress: "0.0.0.0"},
+		{CallbackBindAddress: "192.0.2.1"},
+		{CallbackBindAddress: "::"},
+		{CallbackRedirectHost: "attacker.example.com"},
+		{CallbackRedirectHost: "192.0.2.1"},
+		{CallbackRedirectHost: "[2001:db8::1]"},
+		{CallbackRedirectHost: "localhost.example.com"},
+	}
+	for _, settings := range invalid {
+		assert.Error(t, settings.Validate(), "%+v", settings)
+	}
+}
+
+func TestServeMux_ServesLoginSettingsWithoutToken(t *testing.T) {
+	h := ServeUserApplicationsHandler{LoginSettings: &LoginSettings{CallbackPorts: []string{"57468"}, CallbackRedirectHost: "127.0.0.1"}}
+	w := httptest.NewRecorder()
+	NewServeMux(h).ServeHTTP(w, httptest.NewRequest("GET", "/v3/settings/login", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"callback_ports": ["57468"], "callback_redirect_host": "127.0.0.1"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	NewServeMux(ServeUserApplicationsHandler{}).ServeHTTP(w, httptest.NewRequest("GET", "/v3/settings/login", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+// ApplicationService retrieves the configuration of individual applications.
+type ApplicationService interface {
+	GetApplication(ctx context.Context, appID string) (*okta.Application, error)
+}
+
+// ApplicationMetadata is information about an application that administrators provide through the profile of the application in Okta.
+//
+// The profile of an application is a JSON object that can be set through the Okta Apps API. The following attributes are recognized:
+//
+//	{
+//	  "alias": "production",
+//	  "account_ids": ["123456789012"],
+//	  "default_role": "ReadOnly",
+//	  "category": "Production",
+//	  "sort_key": "01-production"
+//	}
+//
+// account_ids may also be a comma separated string. If it is absent, the account ID is read from the identity provider ARN in the settings of the AWS Account Federation integration.
+type ApplicationMetadata struct {
+	Alias       string   `json:"alias,omitempty"`
+	AccountIDs  []string `json:"account_ids,omitempty"`
+	DefaultRole string   `json:"default_role,omitempty"`
+	Category    string   `json:"category,omitempty"`
+	SortKey     string   `json:"sort_key,omitempty"`
+}
+
+// metadataFromApplication reads the metadata of app from its profile and settings.
+func metadataFromApplication(app *okta.Application) ApplicationMetadata {
+	var metadata ApplicationMetadata
+	profile, _ := app.Profile.(map[string]any)
+	metadata.Alias = stringAttribute(profile, "alias")
+	metadata.DefaultRole = stringAttribute(profile, "default_role")
+	metadata.Category = stringAttribute(profile, "category")
+	metadata.SortKey = stringAttribute(profile, "sort_key")
+
+	switch ids := profile["account_ids"].(type) {
+	case []any:
+		for _, id := range ids {
+			if s, ok := id.(string); ok && s != "" {
+				metadata.AccountIDs = append(metadata.AccountIDs, s)
+			}
+		}
+	case string:
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				metadata.AccountIDs = append(metadata.AccountIDs, id)
+			}
+		}
+	}
+
+	if len(metadata.AccountIDs) == 0 && app.Settings != nil && app.Settings.App != nil {
+		providerARN, _ := (*app.Settings.App)["identityProviderArn"].(string)
+		if parsed, err := arn.Parse(providerARN); err == nil && parsed.AccountID != "" {
+			metadata.AccountIDs = []string{parsed.AccountID}
+		}
+	}
+
+	return metadata
+}
+
+func stringAttribute(attrs map[string]any, key string) string {
+	s, _ := attrs[key].(string)
+	return strings.TrimSpace(s)
+}
+
+// DefaultAlias generates an alias from the label of an application, such as production-account for "AWS - Production Account".
+func DefaultAlias(label string) string {
+	magicPrefixes := []string{"AWS -"}
+	for _, prefix := range magicPrefixes {
+		label = strings.TrimPrefix(label, prefix)
+		label = strings.TrimSpace(label)
+	}
+
+	return strings.ToLower(strings.ReplaceAll(label, " ", "-"))
+}
+
+// ApplicationV3 is an application as served by the v3 API.
+type ApplicationV3 struct {
+	ID      string `json:"id"`
+	Label   string `json:"label"`
+	AppName string `json:"app_name"`
+	Type    string `json:"type"`
+	// Alias is the alias suggested for the application, from its profile or generated from its label.
+	Alias       string   `json:"alias"`
+	AccountIDs  []string `json:"account_ids"`
+	DefaultRole string   `json:"default_role,omitempty"`
+	Category    string   `json:"category,omitempty"`
+	// SortKey orders applications for display. It defaults to the label of the application.
+	SortKey string `json:"sort_key"`
+}
+
+// ApplicationsV3Response is the body of a successful response from the v3 applications endpoint.
+type ApplicationsV3Response struct {
+	Applications []ApplicationV3 `json:"applications"`
+}
+
+// maxConcurrentMetadataRequests limits the number of requests made to Okta at once when retrieving application metadata.
+const maxConcurrentMetadataRequests = 8
+
+// applicationMetadata retrieves the metadata of each application in apps, from the cache where possible.
+//
+// Metadata is optional, so applications whose metadata cannot be retrieved are served without it rather than failing the request.
+func (s ServeUserApplicationsHandler) applicationMetadata(ctx context.Context, apps []*okta.AppLink) []ApplicationMetadata {
+	metadata := make([]ApplicationMetadata, len(apps))
+	if s.Applications == nil {
+		return metadata
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentMetadataRequests)
+	for idx, app := range apps {
+		if s.MetadataCache != nil {
+			cached, ok := s.MetadataCache.GetMetadata(ctx, app.AppInstanceId)
+			s.Metrics.observeCache("application_metadata", ok)
+			if ok {
+				metadata[idx] = cached
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			details, err := s.Applications.GetApplication(ctx, app.AppInstanceId)
+			if err != nil {
+				slog.Warn("could not retrieve application metadata", slog.String("application_id", app.AppInstanceId), slog.String("error", err.Error()))
+				return
+			}
+
+			metadata[idx] = metadataFromApplication(details)
+			if s.MetadataCache != nil {
+				s.MetadataCache.SetMetadata(ctx, app.AppInstanceId, metadata[idx])
+			}
+		}()
+	}
+
+	wg.Wait()
+	return metadata
+}
+
+// applicationsV3 builds the v3 representation of the applications in links that should be served.
+func (s ServeUserApplicationsHandler) applicationsV3(ctx context.Context, links []*okta.AppLink) []ApplicationV3 {
+	filter := s.filter()
+	var served []*okta.AppLink
+	var types []string
+	for _, link := range links {
+		if appType, ok := filter.Type(link); ok {
+			served = append(served, link)
+			types = append(types, appType)
+		}
+	}
+
+	metadata := s.applicationMetadata(ctx, served)
+	apps := make([]ApplicationV3, len(served))
+	for idx, link := range served {
+		app := ApplicationV3{
+			ID:          link.AppInstanceId,
+			Label:       link.Label,
+			AppName:     link.AppName,
+			Type:        types[idx],
+			Alias:       metadata[idx].Alias,
+			AccountIDs:  metadata[idx].AccountIDs,
+			DefaultRole: metadata[idx].DefaultRole,
+			Category:    metadata[idx].Category,
+			SortKey:     metadata[idx].SortKey,
+		}
+
+		if app.Alias == "" {
+			app.Alias = DefaultAlias(link.Label)
+		}
+
+		if app.AccountIDs == nil {
+			app.AccountIDs = []string{}
+		}
+
+		if app.SortKey == "" {
+			app.SortKey = strings.ToLower(link.Label)
+		}
+
+		apps[idx] = app
+	}
+
+	slices.SortStableFunc(apps, func(a, b ApplicationV3) int {
+		return strings.Compare(a.SortKey, b.SortKey)
+	})
+
+	return apps
+}
+
+// HandleV3 serves the applications assigned to the caller along with the metadata of each.
+func (s ServeUserApplicationsHandler) HandleV3(ctx context.Context, r Request) (w Response, err error) {
+	claims, ok := s.authenticate(ctx, r, &w)
+	if !ok {
+		return w, nil
+	}
+
+	requestAttrs := append(RequestAttrs(r), slog.String("username", claims.Username()))
+	links, cached, err := s.listApplications(ctx, claims.Username())
+	if err != nil {
+		serveListError(&w, err, requestAttrs)
+		return w, nil
+	}
+
+	apps := s.applicationsV3(ctx, s.authorize(r, claims, links))
+	s.Metrics.observeApplicationCount(len(apps))
+	requestAttrs = append(requestAttrs, slog.Int("application_count", len(apps)), slog.Bool("cached", cached))
+	slog.Info("served applications", requestAttrs...)
+	ServeJSON(&w, ApplicationsV3Response{Applications: apps})
+	w.SetHeader("Cache-Control", s.cacheControl())
+	ServeNotModified(&w, r)
+	return w, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeApplicationService serves applications from a map, counting the number of requests made.
+type fakeApplicationService struct {
+	mu    sync.Mutex
+	apps  map[string]*okta.Application
+	calls int
+}
+
+func (f *fakeApplicationService) GetApplication(_ context.Context, appID string) (*okta.Application, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	app, ok := f.apps[appID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return app, nil
+}
+
+func Test_metadataFromApplication(t *testing.T) {
+	app := okta.Application{
+		Profile: map[string]any{
+			"alias":        "prod",
+			"account_ids":  []any{"123456789012", "210987654321"},
+			"default_role": "ReadOnly",
+			"category":     "Production",
+			"sort_key":     "01",
+		},
+	}
+
+	assert.Equal(t, ApplicationMetadata{
+		Alias:       "prod",
+		AccountIDs:  []string{"123456789012", "210987654321"},
+		DefaultRole: "ReadOnly",
+		Category:    "Production",
+		SortKey:     "01",
+	}, metadataFromApplication(&app))
+
+	app.Profile = map[string]any{"account_ids": "123456789012, 210987654321"}
+	assert.Equal(t, []string{"123456789012", "210987654321"}, metadataFromApplication(&app).AccountIDs)
+}
+
+func Test_metadataFromApplication_ReadsAccountFromIdentityProvider(t *testing.T) {
+	settings := okta.ApplicationSettingsApplication{"identityProviderArn": "arn:aws:iam::123456789012:saml-provider/Okta"}
+	app := okta.Application{Settings: &okta.ApplicationSettings{App: &settings}}
+	assert.Equal(t, ApplicationMetadata{AccountIDs: []string{"123456789012"}}, metadataFromApplication(&app))
+}
+
+func TestServeUserApplicationsHandler_HandleV3(t *testing.T) {
+	oktaService := countingOktaService{links: []*okta.AppLink{
+		{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Production"},
+		{AppInstanceId: "0oa2", AppName: "amazon_aws", Label: "AWS - Development"},
+		{AppInstanceId: "0oa3", AppName: "amazon_aws", Label: "AWS - Unreachable"},
+	}}
+	apps := fakeApplicationService{apps: map[string]*okta.Application{
+		"0oa1": {Profile: map[string]any{"alias": "prod", "default_role": "ReadOnly", "category": "Production", "sort_key": "01"}},
+		"0oa2": {Profile: map[string]any{"account_ids": []any{"123456789012"}}},
+	}}
+	h := ServeUserApplicationsHandler{
+		Okta:          &oktaService,
+		Tokens:        &fixedValidator{claims: Claims{PreferredUsername: "user@example.com"}},
+		Applications:  &apps,
+		MetadataCache: &ApplicationCache{Backend: NewMemoryCache(), TTL: time.Hour},
+	}
+	req := Request{Method: "GET", Path: "/v3/applications", Headers: http.Header{"Authorization": {"Bearer token"}}}
+
+	w, err := h.Routes().Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+	assert.NotEmpty(t, w.Headers.Get("ETag"))
+
+	var resp ApplicationsV3Response
+	require.NoError(t, json.Unmarshal([]byte(w.Body), &resp))
+	assert.Equal(t, []ApplicationV3{
+		{ID: "0oa1", Label: "AWS - Production", AppName: "amazon_aws", Type: "aws", Alias: "prod", AccountIDs: []string{}, DefaultRole: "ReadOnly", Category: "Production", SortKey: "01"},
+		{ID: "0oa2", Label: "AWS - Development", AppName: "amazon_aws", Type: "aws", Alias: "development", AccountIDs: []string{"123456789012"}, SortKey: "aws - development"},
+		// Applications whose metadata cannot be retrieved are still served.
+		{ID: "0oa3", Label: "AWS - Unreachable", AppName: "amazon_aws", Type: "aws", Alias: "unreachable", AccountIDs: []string{}, SortKey: "aws - unreachable"},
+	}, resp.Applications)
+	assert.Equal(t, 3, apps.calls)
+
+	// Metadata that was retrieved is cached, even though the applications of the user are not.
+	_, err = h.Routes().Handle(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 4, apps.calls)
+	assert.Equal(t, 2, oktaService.calls)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the metrics recorded by the account service.
+//
+// A nil *Metrics records nothing, so that metrics are optional.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests            *prometheus.CounterVec
+	requestDuration     *prometheus.HistogramVec
+	oktaResponses       *prometheus.CounterVec
+	oktaRequestDuration *prometheus.HistogramVec
+	applicationCount    prometheus.Histogram
+	cacheRequests       *prometheus.CounterVec
+}
+
+// applicationCountBuckets are the buckets of the distribution of the number of applications served to each user.
+var applicationCountBuckets = []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500}
+
+// NewMetrics registers the metrics of the account service in r.
+func NewMetrics(r *prometheus.Registry) *Metrics {
+	m := Metrics{
+		Registry: r,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "keyconjurer_http_requests_total",
+			Help: "Requests served, by route and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "keyconjurer_http_request_duration_seconds",
+			Help: "Time taken to serve requests, by route and status code.",
+		}, []string{"route", "method", "status"}),
+		oktaResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "keyconjurer_okta_responses_total",
+			Help: "Responses received from the Okta API, by endpoint and status code.",
+		}, []string{"endpoint", "status"}),
+		oktaRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "keyconjurer_okta_request_duration_seconds",
+			Help: "Time taken by requests to the Okta API, by endpoint.",
+		}, []string{"endpoint"}),
+		applicationCount: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "keyconjurer_application_count",
+			Help:    "The number of applications served to each user.",
+			Buckets: applicationCountBuckets,
+		}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "keyconjurer_cache_requests_total",
+			Help: "Cache lookups, by cache and whether they were a hit or a miss.",
+		}, []string{"cache", "result"}),
+	}
+
+	r.MustRegister(m.requests, m.requestDuration, m.oktaResponses, m.oktaRequestDuration, m.applicationCount, m.cacheRequests)
+	return &m
+}
+
+// Instrument records the number and duration of requests served by h, labelled with route.
+func (m *Metrics) Instrument(route string, h RequestHandler) RequestHandler {
+	if m == nil {
+		return h
+	}
+
+	return RequestHandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+		start := time.Now()
+		w, err := h.Handle(ctx, r)
+		status := strconv.Itoa(w.Status())
+		if err != nil {
+			status = "error"
+		}
+
+		m.requests.WithLabelValues(route, r.Method, status).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+		return w, err
+	})
+}
+
+func (m *Metrics) observeApplicationCount(n int) {
+	if m != nil {
+		m.applicationCount.Observe(float64(n))
+	}
+}
+
+func (m *Metrics) observeCache(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// OktaTransport returns a http.RoundTripper which records the status code and duration of requests to the Okta API before passing them to next.
+func (m *Metrics) OktaTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if m == nil {
+		return next
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		endpoint := oktaEndpoint(req.URL.Path)
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		m.oktaRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.oktaResponses.WithLabelValues(endpoint, "error").Inc()
+			return resp, err
+		}
+
+		m.oktaResponses.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// oktaEndpoint replaces the IDs in the path of a request to the Okta API with a placeholder, so that metrics are not labelled with user names or application IDs.
+//
+// Okta API paths alternate between collections and IDs after the version, such as /api/v1/users/{id}/appLinks.
+func oktaEndpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for idx := 3; idx < len(segments); idx += 2 {
+		segments[idx] = "{id}"
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
+
+// TraceID returns the root trace ID from the X-Amzn-Trace-Id header of r, which is added by load balancers and API Gateway.
+func TraceID(r Request) string {
+	header := r.Headers.Get("X-Amzn-Trace-Id")
+	for _, field := range strings.Split(header, ";") {
+		if root, ok := strings.CutPrefix(strings.TrimSpace(field), "Root="); ok {
+			return root
+		}
+	}
+
+	return header
+}
+
+// AccessLog logs a line for every request served by h.
+func AccessLog(h RequestHandler) RequestHandler {
+	return RequestHandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+		start := time.Now()
+		w, err := h.Handle(ctx, r)
+		attrs := []any{
+			slog.String("method", r.Method),
+			slog.String("path", r.Path),
+			slog.Int("status", w.Status()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("response_bytes", len(w.Body)),
+			slog.String("trace_id", TraceID(r)),
+			slog.String("user_agent", r.Headers.Get("User-Agent")),
+		}
+
+		if v := r.Headers.Get("X-Forwarded-For"); v != "" {
+			attrs = append(attrs, slog.String("x_forwarded_for", v))
+		} else if r.SourceIP != "" {
+			attrs = append(attrs, slog.String("source_ip", r.SourceIP))
+		}
+
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+
+		slog.Info("access", attrs...)
+		return w, err
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_oktaEndpoint(t *testing.T) {
+	assert.Equal(t, "/api/v1/users/{id}/appLinks", oktaEndpoint("/api/v1/users/user@example.com/appLinks"))
+	assert.Equal(t, "/api/v1/apps/{id}", oktaEndpoint("/api/v1/apps/0oa1234567890"))
+	assert.Equal(t, "/api/v1/apps", oktaEndpoint("/api/v1/apps"))
+}
+
+func TestTraceID(t *testing.T) {
+	r := Request{Headers: http.Header{"X-Amzn-Trace-Id": {"Self=1-abc;Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"}}}
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", TraceID(r))
+
+	r.Headers.Set("X-Amzn-Trace-Id", "custom-trace")
+	assert.Equal(t, "custom-trace", TraceID(r))
+}
+
+func TestServeMux_ServesMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewMetrics(registry)
+	h := ServeUserApplicationsHandler{
+		Okta:    &countingOktaService{links: []*okta.AppLink{{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Prod"}}},
+		Tokens:  &fixedValidator{claims: Claims{PreferredUsername: "user@example.com"}},
+		Metrics: m,
+	}
+	mux := NewServeMux(h)
+
+	req := httptest.NewRequest("POST", "/v2/applications", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `keyconjurer_http_requests_total{method="POST",route="/v2/applications",status="200"} 1`)
+	assert.Contains(t, w.Body.String(), `keyconjurer_application_count_bucket{le="1"} 1`)
+}
+
+func TestMetrics_OktaTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	m := NewMetrics(prometheus.NewRegistry())
+	client := http.Client{Transport: m.OktaTransport(nil)}
+	resp, err := client.Get(srv.URL + "/api/v1/users/user@example.com/appLinks")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.oktaResponses.WithLabelValues("/api/v1/users/{id}/appLinks", "429")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.oktaResponses), "only one endpoint and status code was seen")
+}
+
+func TestMetrics_NilRecordsNothing(t *testing.T) {
+	var m *Metrics
+	h := RequestHandlerFunc(func(context.Context, Request) (Response, error) { return Response{}, nil })
+	_, err := m.Instrument("/", h).Handle(context.Background(), Request{})
+	require.NoError(t, err)
+	m.observeApplicationCount(1)
+	m.observeCache("applications", true)
+	assert.Equal(t, http.DefaultTransport, m.OktaTransport(nil))
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf strings.Builder
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	h := AccessLog(RequestHandlerFunc(func(context.Context, Request) (Response, error) {
+		return Response{StatusCode: http.StatusTeapot, Body: "short and stout"}, nil
+	}))
+	_, err := h.Handle(context.Background(), Request{
+		Method:   "GET",
+		Path:     "/v3/applications",
+		Headers:  http.Header{"X-Amzn-Trace-Id": {"Root=1-abc-def"}},
+		SourceIP: "192.0.2.1",
+	})
+	require.NoError(t, err)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &entry))
+	assert.Equal(t, "access", entry["msg"])
+	assert.Equal(t, "1-abc-def", entry["trace_id"])
+	assert.Equal(t, "/v3/applications", entry["path"])
+	assert.Equal(t, float64(http.StatusTeapot), entry["status"])
+	assert.Equal(t, "192.0.2.1", entry["source_ip"])
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
 )
 
 type Okta struct {
@@ -16,32 +18,104 @@ type Okta struct {
 	oktaClient *okta.Client
 }
 
-func NewOktaService(domain *url.URL, token string) Okta {
+// NewOktaService returns an OktaService using the Okta API at domain. If client is nil, http.DefaultClient is used.
+//
+// Requests that are rate limited by Okta are retried if the rate limit resets before the deadline of the request; otherwise, a *RateLimitError is returned.
+func NewOktaService(domain *url.URL, token string, client *http.Client) Okta {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// The retries of the SDK are disabled as they neither respect the deadline of the request nor report how long to wait.
+	oktaHTTPClient := *client
+	oktaHTTPClient.Transport = newRateLimitTransport(client.Transport)
 	_, oktaClient, _ := okta.NewClient(
 		context.Background(),
 		okta.WithToken(token),
 		okta.WithOrgUrl(domain.String()),
+		okta.WithHttpClient(oktaHTTPClient),
+		okta.WithRateLimitMaxRetries(0),
 	)
 
-	return Okta{domain, token, http.DefaultClient, oktaClient}
+	return Okta{domain, token, client, oktaClient}
 }
 
 func (o Okta) ListApplicationsForUser(ctx context.Context, user string) ([]*okta.AppLink, error) {
 	links, resp, err := o.oktaClient.User.ListAppLinks(ctx, user)
+	return allPages(ctx, o.oktaClient, links, resp, err)
+}
+
+// allPages appends the items of every page after the first to items.
+//
+// okta.Response.Next cannot be used as it expects ctx to carry the client, which is not the case for contexts from incoming requests.
+func allPages[T any](ctx context.Context, client *okta.Client, items []T, resp *okta.Response, err error) ([]T, error) {
 	if err != nil {
 		return nil, err
 	}
 
 	for resp.HasNextPage() {
-		var next []*okta.AppLink
-		if resp, err = resp.Next(ctx, &next); err != nil {
+		re := client.GetRequestExecutor()
+		req, err := re.WithAccept("application/json").WithContentType("application/json").NewRequest(http.MethodGet, resp.NextPage, nil)
+		if err != nil {
 			return nil, err
 		}
 
-		links = append(links, next...)
+		var next []T
+		if resp, err = re.Do(ctx, req, &next); err != nil {
+			return nil, err
+		}
+
+		items = append(items, next...)
+	}
+
+	return items, nil
+}
+
+// GetApplication retrieves an application, including its profile and settings.
+func (o Okta) GetApplication(ctx context.Context, appID string) (*okta.Application, error) {
+	app, _, err := o.oktaClient.Application.GetApplication(ctx, appID, okta.NewApplication(), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return links, nil
+	application, ok := app.(*okta.Application)
+	if !ok {
+		return nil, fmt.Errorf("unexpected application type %T", app)
+	}
+
+	return application, nil
+}
+
+// ListApplicationsByName returns every application using the application integration with the given name, such as amazon_aws.
+func (o Okta) ListApplicationsByName(ctx context.Context, name string) ([]*okta.Application, error) {
+	qp := query.NewQueryParams(query.WithFilter(fmt.Sprintf("name eq %q", name)), query.WithLimit(200))
+	apps, resp, err := o.oktaClient.Application.ListApplications(ctx, qp)
+	if err != nil {
+		return nil, err
+	}
+
+	applications := make([]*okta.Application, 0, len(apps))
+	for _, app := range apps {
+		if application, ok := app.(*okta.Application); ok {
+			applications = append(applications, application)
+		}
+	}
+
+	return allPages(ctx, o.oktaClient, applications, resp, nil)
+}
+
+// ListApplicationUsers returns the users assigned to an application, either directly or through a group, including their Okta profile.
+func (o Okta) ListApplicationUsers(ctx context.Context, appID string) ([]*okta.AppUser, error) {
+	qp := query.NewQueryParams(query.WithExpand("user"), query.WithLimit(500))
+	users, resp, err := o.oktaClient.Application.ListApplicationUsers(ctx, appID, qp)
+	return allPages(ctx, o.oktaClient, users, resp, err)
+}
+
+// ListApplicationGroups returns the groups assigned to an application, including the profile of each group.
+func (o Okta) ListApplicationGroups(ctx context.Context, appID string) ([]*okta.ApplicationGroupAssignment, error) {
+	qp := query.NewQueryParams(query.WithExpand("group"), query.WithLimit(200))
+	groups, resp, err := o.oktaClient.Application.ListApplicationGroupAssignments(ctx, appID, qp)
+	return allPages(ctx, o.oktaClient, groups, resp, err)
 }
 
 type Claims struct {
@@ -52,6 +126,49 @@ type Claims struct {
 	Email             string `json:"email"`
 	ZoneInfo          string `json:"zoneinfo"`
 	Locale            string `json:"locale"`
+	// UserID is the Okta ID of the user. This is only present in access tokens.
+	UserID string `json:"uid"`
+	// ClientID is the ID of the client the token was issued to. This is only present in access tokens.
+	ClientID string `json:"cid"`
+	// Groups contains the names of the groups the user is a member of. This is only present if the authorization server has been configured to issue a groups claim.
+	Groups []string `json:"groups"`
+}
+
+// Attribute returns the value of the claim with the given name, as used in authorization rules.
+func (c Claims) Attribute(name string) (string, bool) {
+	switch name {
+	case "sub":
+		return c.Sub, true
+	case "given_name":
+		return c.GivenName, true
+	case "family_name":
+		return c.FamilyName, true
+	case "preferred_username":
+		return c.PreferredUsername, true
+	case "email":
+		return c.Email, true
+	case "zoneinfo":
+		return c.ZoneInfo, true
+	case "locale":
+		return c.Locale, true
+	case "uid":
+		return c.UserID, true
+	case "cid":
+		return c.ClientID, true
+	default:
+		return "", false
+	}
+}
+
+// Username returns the name used to look up the user in Okta.
+//
+// Okta access tokens do not contain preferred_username, but their subject is the login of the user.
+func (c Claims) Username() string {
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername
+	}
+
+	return c.Sub
 }
 
 var (
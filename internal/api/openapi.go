@@ -0,0 +1,17 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpec is the OpenAPI document describing the endpoints of the account service.
+//
+//go:embed openapi.yaml
+var OpenAPISpec []byte
+
+// serveOpenAPISpec serves OpenAPISpec.
+func serveOpenAPISpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(OpenAPISpec)
+}
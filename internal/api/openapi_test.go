@@ -0,0 +1,143 @@
+package api
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// TestOpenAPISpec_MatchesRoutes ensures that the OpenAPI document describes exactly the endpoints served through the Router.
+func TestOpenAPISpec_MatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(OpenAPISpec, &spec))
+
+	var documented []Endpoint
+	for path, operations := range spec.Paths {
+		if !strings.HasPrefix(path, "/v2/") && !strings.HasPrefix(path, "/v3/") {
+			continue
+		}
+
+		for method := range operations {
+			documented = append(documented, Endpoint{Method: strings.ToUpper(method), Path: path})
+		}
+	}
+
+	h := ServeUserApplicationsHandler{Audit: &Audit{}, Workload: &WorkloadIdentity{}, LoginSettings: &LoginSettings{}}
+	served := h.Routes().Endpoints()
+	assert.ElementsMatch(t, served, documented)
+	assert.True(t, slices.IsSortedFunc(served, func(a, b Endpoint) int { return strings.Compare(a.Path, b.Path) }))
+}
+
+func TestServeMux_ServesOpenAPISpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewServeMux(ServeUserApplicationsHandler{}).ServeHTTP(w, httptest.NewRequest("GET", "/openapi.yaml", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, OpenAPISpec, w.Body.Bytes())
+}
+
+// openAPISchema is the subset of an OpenAPI schema object needed to compare it with a Go type.
+type openAPISchema struct {
+	Ref        string                    `yaml:"$ref"`
+	Type       string                    `yaml:"type"`
+	Required   []string                  `yaml:"required"`
+	Properties map[string]*openAPISchema `yaml:"properties"`
+	Items      *openAPISchema            `yaml:"items"`
+}
+
+// TestOpenAPISpec_MatchesTypes ensures that the schemas in the OpenAPI document describe the JSON encoding of the types the server and client use, so that neither drifts from the document.
+func TestOpenAPISpec_MatchesTypes(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]*openAPISchema `yaml:"schemas"`
+		} `yaml:"components"`
+	}
+	require.NoError(t, yaml.Unmarshal(OpenAPISpec, &spec))
+
+	types := map[string]any{
+		"Error":                      JSONError{},
+		"ApplicationV2":              Application{},
+		"ApplicationV3":              ApplicationV3{},
+		"ApplicationsV3Response":     ApplicationsV3Response{},
+		"AuditReport":                AuditReport{},
+		"AuditApplication":           AuditApplication{},
+		"LoginSettings":              LoginSettings{},
+		"WorkloadCredentialsRequest": WorkloadCredentialsRequest{},
+		"WorkloadCredentials":        WorkloadCredentials{},
+	}
+
+	var names []string
+	for name := range spec.Components.Schemas {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, names, slices.Collect(maps.Keys(types)), "every schema should be compared with a type")
+
+	for name, v := range types {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := spec.Components.Schemas[name]
+			require.True(t, ok, "schema %s is not documented", name)
+			assertSchemaMatchesType(t, spec.Components.Schemas, name, schema, reflect.TypeOf(v))
+		})
+	}
+}
+
+func assertSchemaMatchesType(t *testing.T, schemas map[string]*openAPISchema, path string, schema *openAPISchema, typ reflect.Type) {
+	t.Helper()
+	if ref, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/"); ok {
+		require.Contains(t, schemas, ref, "%s refers to an undocumented schema", path)
+		schema = schemas[ref]
+	}
+
+	switch {
+	case typ == reflect.TypeOf(time.Time{}):
+		assert.Equal(t, "string", schema.Type, path)
+	case typ.Kind() == reflect.Slice:
+		require.Equal(t, "array", schema.Type, path)
+		require.NotNil(t, schema.Items, path)
+		assertSchemaMatchesType(t, schemas, path+"[]", schema.Items, typ.Elem())
+	case typ.Kind() == reflect.Struct:
+		assert.Equal(t, "object", schema.Type, path)
+		var properties, required []string
+		fields := map[string]reflect.Type{}
+		for field := range slices.Values(reflect.VisibleFields(typ)) {
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties = append(properties, name)
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				required = append(required, name)
+			}
+			fields[name] = field.Type
+		}
+
+		assert.ElementsMatch(t, properties, slices.Collect(maps.Keys(schema.Properties)), "properties of %s", path)
+		assert.ElementsMatch(t, required, schema.Required, "required properties of %s", path)
+		for name, typ := range fields {
+			if property, ok := schema.Properties[name]; ok {
+				assertSchemaMatchesType(t, schemas, path+"."+name, property, typ)
+			}
+		}
+	case typ.Kind() == reflect.String:
+		assert.Equal(t, "string", schema.Type, path)
+	case typ.Kind() == reflect.Bool:
+		assert.Equal(t, "boolean", schema.Type, path)
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		assert.Equal(t, "integer", schema.Type, path)
+	default:
+		t.Errorf("%s: unexpected type %s", path, typ)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+// RateLimitError indicates that a request could not be served because a rate limit was exceeded.
+type RateLimitError struct {
+	// RetryAfter is how long the caller should wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+const (
+	// maxRateLimitRetries is the number of times a request to Okta is retried after being rate limited.
+	maxRateLimitRetries = 3
+	// maxRateLimitWait is the longest a request to Okta will wait for a rate limit to reset.
+	maxRateLimitWait = 30 * time.Second
+	// rateLimitWarningThreshold is the fraction of the rate limit remaining below which a warning is logged.
+	rateLimitWarningThreshold = 0.1
+)
+
+// rateLimitTransport retries requests to the Okta API that are rejected with 429 Too Many Requests.
+//
+// Okta reports when the rate limit resets in the X-Rate-Limit-Reset header. Requests wait until then, or back off exponentially if the header is missing, but only if the wait would end before the deadline of the request.
+// Otherwise, a *RateLimitError is returned so that the caller can be told when to try again.
+type rateLimitTransport struct {
+	next  http.RoundTripper
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimitTransport(next http.RoundTripper) *rateLimitTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &rateLimitTransport{next: next, now: time.Now, sleep: sleepContext}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			warnIfRateLimitLow(req, resp)
+			return resp, nil
+		}
+
+		wait := t.retryAfter(resp, attempt)
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		// Requests with a body can only be retried if the body can be read again.
+		retryable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		deadline, hasDeadline := req.Context().Deadline()
+		if !retryable || attempt >= maxRateLimitRetries || wait > maxRateLimitWait || (hasDeadline && t.now().Add(wait).After(deadline)) {
+			return nil, &RateLimitError{RetryAfter: wait}
+		}
+
+		slog.Warn("rate limited by okta, retrying", slog.String("path", req.URL.Path), slog.Duration("wait", wait), slog.Int("attempt", attempt+1))
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying a request that was rate limited.
+func (t *rateLimitTransport) retryAfter(resp *http.Response, attempt int) time.Duration {
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		// The reset time is relative to the clock of the Okta server, which is reported in the Date header.
+		now := t.now()
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			now = date
+		}
+
+		// One second is added as the reset time is truncated to the second.
+		if wait := time.Unix(reset, 0).Sub(now) + time.Second; wait > 0 {
+			return wait
+		}
+	}
+
+	backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+	return backoff + time.Duration(rand.Int64N(int64(backoff/2)))
+}
+
+// warnIfRateLimitLow logs a warning if the X-Rate-Limit-Remaining header shows the rate limit is close to being exhausted.
+func warnIfRateLimitLow(req *http.Request, resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Limit"))
+	if err != nil || limit == 0 {
+		return
+	}
+
+	remaining, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	if float64(remaining) < float64(limit)*rateLimitWarningThreshold {
+		slog.Warn("okta rate limit nearly exhausted", slog.String("path", req.URL.Path), slog.Int("limit", limit), slog.Int("remaining", remaining))
+	}
+}
+
+// UserRateLimiter limits the rate at which each user may make requests, using a token bucket per user.
+type UserRateLimiter struct {
+	// Rate is the number of requests per second each user may make on average.
+	Rate float64
+	// Burst is the number of requests a user may make at once.
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// nextSweep is the number of buckets at which idle buckets are next removed.
+	nextSweep int
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewUserRateLimiter returns a limiter allowing each user perMinute requests per minute, with bursts of up to burst requests.
+func NewUserRateLimiter(perMinute float64, burst int) *UserRateLimiter {
+	return &UserRateLimiter{Rate: perMinute / 60, Burst: max(burst, 1), now: time.Now}
+}
+
+// Allow reports whether user may make a request now. If not, it returns how long the user must wait.
+func (l *UserRateLimiter) Allow(user string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+		l.nextSweep = minSweepSize
+	}
+
+	now := l.now()
+	b, ok := l.buckets[user]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[user] = b
+		if len(l.buckets) >= l.nextSweep {
+			l.sweep(now)
+		}
+	}
+
+	b.tokens = min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep removes the buckets of users who have not made a request for long enough that their bucket would be full.
+func (l *UserRateLimiter) sweep(now time.Time) {
+	full := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	for user, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, user)
+		}
+	}
+
+	l.nextSweep = max(2*len(l.buckets), minSweepSize)
+}
+
+// ServeRateLimited writes a 429 Too Many Requests response telling the client when to retry.
+func ServeRateLimited(w *Response, retryAfter time.Duration) {
+	ServeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	w.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
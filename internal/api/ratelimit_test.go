@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequenceTransport responds to each request with the next of its responses.
+type sequenceTransport struct {
+	responses []*http.Response
+	calls     int
+}
+
+func (s *sequenceTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	resp := s.responses[s.calls]
+	s.calls++
+	return resp, nil
+}
+
+func rateLimitedResponse(date time.Time, reset time.Time) *http.Response {
+	resp := httptest.NewRecorder()
+	resp.Header().Set("Date", date.UTC().Format(http.TimeFormat))
+	resp.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	resp.WriteHeader(http.StatusTooManyRequests)
+	return resp.Result()
+}
+
+func TestRateLimitTransport_WaitsForReset(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := sequenceTransport{responses: []*http.Response{
+		rateLimitedResponse(now, now.Add(4*time.Second)),
+		httptest.NewRecorder().Result(),
+	}}
+
+	var slept []time.Duration
+	transport := newRateLimitTransport(&next)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	req := httptest.NewRequest("GET", "https://example.okta.com/api/v1/users/user/appLinks", nil)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{5 * time.Second}, slept)
+}
+
+func TestRateLimitTransport_GivesUpIfResetIsAfterDeadline(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := sequenceTransport{responses: []*http.Response{rateLimitedResponse(now, now.Add(20*time.Second))}}
+	transport := newRateLimitTransport(&next)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(context.Context, time.Duration) error {
+		t.Error("the transport should not wait beyond the deadline of the request")
+		return nil
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(10*time.Second))
+	defer cancel()
+	req := httptest.NewRequest("GET", "https://example.okta.com/api/v1/users/user/appLinks", nil).WithContext(ctx)
+	_, err := transport.RoundTrip(req)
+
+	var rateLimitErr *RateLimitError
+	require.ErrorAs(t, err, &rateLimitErr)
+	assert.Equal(t, 21*time.Second, rateLimitErr.RetryAfter)
+	assert.Equal(t, 1, next.calls)
+}
+
+func TestOkta_ReturnsRateLimitError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	domain, _ := url.Parse(srv.URL)
+	service := NewOktaService(domain, "token", srv.Client())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := service.ListApplicationsForUser(ctx, "user@example.com")
+	var rateLimitErr *RateLimitError
+	require.ErrorAs(t, err, &rateLimitErr)
+	assert.Greater(t, rateLimitErr.RetryAfter, 50*time.Second)
+}
+
+func TestUserRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewUserRateLimiter(6, 2)
+	limiter.now = func() time.Time { return now }
+
+	for range 2 {
+		ok, _ := limiter.Allow("user")
+		require.True(t, ok)
+	}
+
+	ok, wait := limiter.Allow("user")
+	require.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	// Other users have their own limit.
+	ok, _ = limiter.Allow("other")
+	assert.True(t, ok)
+
+	now = now.Add(10 * time.Second)
+	ok, _ = limiter.Allow("user")
+	assert.True(t, ok)
+}
+
+func TestServeUserApplicationsHandler_RateLimited(t *testing.T) {
+	okta := countingOktaService{links: []*okta.AppLink{{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Prod"}}}
+	h := ServeUserApplicationsHandler{
+		Okta:    &okta,
+		Tokens:  &fixedValidator{claims: Claims{PreferredUsername: "user@example.com"}},
+		Limiter: NewUserRateLimiter(1, 1),
+	}
+	routes := h.Routes()
+	req := Request{Method: "GET", Path: "/v3/applications", Headers: http.Header{"Authorization": {"Bearer token"}}}
+
+	w, err := routes.Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+
+	w, err = routes.Handle(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, w.Status())
+	assert.Equal(t, "60", w.Headers.Get("Retry-After"))
+	assert.Equal(t, 1, okta.calls)
+
+	var jsonErr JSONError
+	require.NoError(t, json.Unmarshal([]byte(w.Body), &jsonErr))
+	assert.Equal(t, "rate limit exceeded", jsonErr.Message)
+}
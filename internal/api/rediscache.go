@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache is a CacheBackend which stores values in Redis or a server compatible with it, such as Valkey or ElastiCache.
+type RedisCache struct {
+	client *redis.Client
+}
+
+// redisTimeout is how long to wait for Redis to respond before giving up, so that an unavailable cache does not hold up requests for long.
+const redisTimeout = 2 * time.Second
+
+// NewRedisCache returns a RedisCache for a URL in the form redis://[[username]:password@]host[:port][/db].
+//
+// The rediss scheme connects using TLS.
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	return &RedisCache{client: redis.NewClient(opts)}, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+// Close closes the connections to Redis.
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RequestHandlerFunc adapts a function to a RequestHandler.
+type RequestHandlerFunc func(ctx context.Context, r Request) (Response, error)
+
+func (f RequestHandlerFunc) Handle(ctx context.Context, r Request) (Response, error) {
+	return f(ctx, r)
+}
+
+// Router dispatches requests to handlers by their method and path.
+//
+// Lambda delivers every request to the same function, so the Router takes the place of http.ServeMux in front of handlers that are served through Lambda.
+type Router struct {
+	routes map[string]map[string]RequestHandler
+}
+
+// Route registers h to serve requests with the given method and path.
+func (rt *Router) Route(method, path string, h RequestHandler) {
+	if rt.routes == nil {
+		rt.routes = make(map[string]map[string]RequestHandler)
+	}
+
+	path = normalizePath(path)
+	if rt.routes[path] == nil {
+		rt.routes[path] = make(map[string]RequestHandler)
+	}
+
+	rt.routes[path][strings.ToUpper(method)] = h
+}
+
+// Endpoint is a method and path served by a Router.
+type Endpoint struct {
+	Method string
+	Path   string
+}
+
+// Endpoints returns every method and path served by rt, ordered by path and then method.
+func (rt *Router) Endpoints() []Endpoint {
+	var endpoints []Endpoint
+	for path, methods := range rt.routes {
+		for method := range methods {
+			endpoints = append(endpoints, Endpoint{Method: method, Path: path})
+		}
+	}
+
+	slices.SortFunc(endpoints, func(a, b Endpoint) int {
+		return cmp.Or(strings.Compare(a.Path, b.Path), strings.Compare(a.Method, b.Method))
+	})
+	return endpoints
+}
+
+func (rt *Router) Handle(ctx context.Context, r Request) (Response, error) {
+	var w Response
+	methods, ok := rt.routes[normalizePath(r.Path)]
+	if !ok {
+		ServeJSONError(&w, http.StatusNotFound, "not found")
+		return w, nil
+	}
+
+	h, ok := methods[strings.ToUpper(r.Method)]
+	if !ok {
+		ServeJSONError(&w, http.StatusMethodNotAllowed, "method not allowed")
+		return w, nil
+	}
+
+	return h.Handle(ctx, r)
+}
+
+// normalizePath removes trailing slashes so that /v2/applications and /v2/applications/ are served by the same route.
+func normalizePath(path string) string {
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		return trimmed
+	}
+
+	return "/"
+}
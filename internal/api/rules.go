@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"gopkg.in/yaml.v3"
+)
+
+// Effect is the outcome of an authorization rule.
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// AuthorizationRule shows or hides the applications it matches from the users it matches.
+//
+// Every condition that is specified must match for the rule to apply. A rule with no conditions matches every application and every user.
+type AuthorizationRule struct {
+	// Name identifies the rule in audit logs.
+	Name   string `yaml:"name"`
+	Effect Effect `yaml:"effect"`
+	// AppName is the name of the Okta application integration the rule applies to.
+	AppName string `yaml:"app_name"`
+	// Label is a regular expression which the label of the application must match.
+	Label string `yaml:"label"`
+	// Groups matches users who are a member of at least one of these groups, according to the groups claim.
+	Groups []string `yaml:"groups"`
+	// User matches users whose claims match each of the given regular expressions, keyed by the name of the claim, such as email.
+	User map[string]string `yaml:"user"`
+
+	app  ApplicationRule
+	user map[string]*regexp.Regexp
+}
+
+func (r *AuthorizationRule) compile() error {
+	if r.Name == "" {
+		return errors.New("a name is required")
+	}
+
+	if r.Effect != EffectAllow && r.Effect != EffectDeny {
+		return fmt.Errorf("effect must be %q or %q", EffectAllow, EffectDeny)
+	}
+
+	r.app = ApplicationRule{AppName: r.AppName, Label: r.Label}
+	if r.AppName != "" || r.Label != "" {
+		if err := r.app.compile(); err != nil {
+			return err
+		}
+	}
+
+	r.user = make(map[string]*regexp.Regexp, len(r.User))
+	for claim, pattern := range r.User {
+		if _, ok := (Claims{}).Attribute(claim); !ok {
+			return fmt.Errorf("unknown user attribute %q", claim)
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for user attribute %q: %w", claim, err)
+		}
+
+		r.user[claim] = re
+	}
+
+	return nil
+}
+
+func (r AuthorizationRule) matches(claims Claims, app *okta.AppLink) bool {
+	if !r.app.matches(app) {
+		return false
+	}
+
+	if len(r.Groups) > 0 && !slices.ContainsFunc(r.Groups, func(group string) bool { return slices.Contains(claims.Groups, group) }) {
+		return false
+	}
+
+	for claim, re := range r.user {
+		value, _ := claims.Attribute(claim)
+		if !re.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AuthorizationRules decide which of the applications assigned to a user in Okta they may see.
+//
+// The rules only filter the applications the account service lists. They are not an access control: a user can still retrieve credentials for a hidden application from Okta by its ID, so access must be restricted with Okta assignments.
+//
+// Rules are evaluated in order and the first rule that matches decides the outcome. If no rule matches, the Default effect applies.
+type AuthorizationRules struct {
+	Default Effect              `yaml:"default"`
+	Rules   []AuthorizationRule `yaml:"rules"`
+}
+
+// Decision is the outcome of evaluating AuthorizationRules for a user and an application.
+type Decision struct {
+	Effect Effect
+	// Rule is the name of the rule which decided the outcome, or empty if the default was used.
+	Rule string
+}
+
+// Allowed indicates whether the user may see the application.
+func (d Decision) Allowed() bool {
+	return d.Effect == EffectAllow
+}
+
+// ReadAuthorizationRules reads AuthorizationRules from YAML, such as:
+//
+//	default: allow
+//	rules:
+//	  - name: production-requires-sre
+//	    effect: allow
+//	    label: "(?i)production"
+//	    groups: [SRE]
+//	  - name: production-deny-others
+//	    effect: deny
+//	    label: "(?i)production"
+//	  - name: contractors
+//	    effect: deny
+//	    user:
+//	      email: "@contractor\\.example\\.com$"
+func ReadAuthorizationRules(r io.Reader) (AuthorizationRules, error) {
+	var rules AuthorizationRules
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil && !errors.Is(err, io.EOF) {
+		return rules, err
+	}
+
+	switch rules.Default {
+	case "":
+		rules.Default = EffectAllow
+	case EffectAllow, EffectDeny:
+	default:
+		return rules, fmt.Errorf("default must be %q or %q", EffectAllow, EffectDeny)
+	}
+
+	for idx := range rules.Rules {
+		if err := rules.Rules[idx].compile(); err != nil {
+			return rules, fmt.Errorf("rule %d: %w", idx+1, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// Evaluate decides whether the user with the given claims may see app.
+func (a AuthorizationRules) Evaluate(claims Claims, app *okta.AppLink) Decision {
+	for _, rule := range a.Rules {
+		if rule.matches(claims, app) {
+			return Decision{Effect: rule.Effect, Rule: rule.Name}
+		}
+	}
+
+	return Decision{Effect: a.Default}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+default: allow
+rules:
+  - name: production-requires-sre
+    effect: allow
+    label: "(?i)production"
+    groups: [SRE, Security]
+  - name: production-deny-others
+    effect: deny
+    label: "(?i)production"
+  - name: contractors
+    effect: deny
+    user:
+      email: "@contractor\\.example\\.com$"
+`
+
+func TestAuthorizationRules(t *testing.T) {
+	rules, err := ReadAuthorizationRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+
+	production := &okta.AppLink{AppInstanceId: "1", AppName: "amazon_aws", Label: "AWS - Production"}
+	sandbox := &okta.AppLink{AppInstanceId: "2", AppName: "amazon_aws", Label: "AWS - Sandbox"}
+
+	tests := []struct {
+		name     string
+		claims   Claims
+		app      *okta.AppLink
+		expected Decision
+	}{
+		{"SREInProduction", Claims{Groups: []string{"Everyone", "SRE"}}, production, Decision{EffectAllow, "production-requires-sre"}},
+		{"DeveloperInProduction", Claims{Groups: []string{"Everyone"}}, production, Decision{EffectDeny, "production-deny-others"}},
+		{"DeveloperInSandbox", Claims{Groups: []string{"Everyone"}, Email: "dev@example.com"}, sandbox, Decision{EffectAllow, ""}},
+		{"ContractorInSandbox", Claims{Email: "someone@contractor.example.com"}, sandbox, Decision{EffectDeny, "contractors"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, rules.Evaluate(tt.claims, tt.app))
+		})
+	}
+}
+
+func TestReadAuthorizationRules_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"InvalidDefault":   `default: maybe`,
+		"MissingName":      `rules: [{effect: deny}]`,
+		"InvalidEffect":    `rules: [{name: a, effect: block}]`,
+		"InvalidLabel":     `rules: [{name: a, effect: deny, label: "("}]`,
+		"UnknownAttribute": `rules: [{name: a, effect: deny, user: {shoe_size: "9"}}]`,
+		"InvalidAttribute": `rules: [{name: a, effect: deny, user: {email: "("}}]`,
+		"UnknownField":     `rules: [{name: a, effect: deny, group: [SRE]}]`,
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadAuthorizationRules(strings.NewReader(doc))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestServeUserApplicationsHandler_AppliesRules(t *testing.T) {
+	rules, err := ReadAuthorizationRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+
+	h := ServeUserApplicationsHandler{
+		Okta: &countingOktaService{links: []*okta.AppLink{
+			{AppInstanceId: "1", AppName: "amazon_aws", Label: "AWS - Production"},
+			{AppInstanceId: "2", AppName: "amazon_aws", Label: "AWS - Sandbox"},
+		}},
+		Tokens: &fixedValidator{claims: Claims{PreferredUsername: "dev@example.com", Groups: []string{"Everyone"}}},
+		Rules:  &rules,
+	}
+
+	for _, req := range []Request{
+		{Method: "POST", Path: "/v2/applications", Headers: http.Header{"Authorization": {"Bearer token"}}},
+		{Method: "GET", Path: "/v3/applications", Headers: http.Header{"Authorization": {"Bearer token"}}},
+	} {
+		w, err := h.Routes().Handle(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.Status())
+		assert.NotContains(t, w.Body, "Production", req.Path)
+		assert.Contains(t, w.Body, "Sandbox", req.Path)
+	}
+}
+
+func TestClaims_UnmarshalsGroups(t *testing.T) {
+	var claims Claims
+	require.NoError(t, json.Unmarshal([]byte(`{"sub":"user","groups":["Everyone","SRE"]}`), &claims))
+	assert.Equal(t, []string{"Everyone", "SRE"}, claims.Groups)
+}
@@ -3,19 +3,21 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"log/slog"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/coreos/go-oidc"
 	"github.com/okta/okta-sdk-golang/v2/okta"
 )
 
 type Application struct {
 	ID   string `json:"@id"`
 	Name string `json:"name"`
+	// Type identifies the kind of application, such as aws, so that clients can decide how to handle it.
+	Type string `json:"type"`
 }
 
 type OktaService interface {
@@ -23,72 +25,240 @@ type OktaService interface {
 }
 
 type ServeUserApplicationsHandler struct {
-	Okta OktaService
-	Idp  *oidc.Provider
+	Okta   OktaService
+	Tokens TokenValidator
+	// Cache, if not nil, caches the applications of each user between requests.
+	Cache *ApplicationCache
+	// Filter selects the applications that are served. If nil, DefaultApplicationFilter is used.
+	Filter *ApplicationFilter
+	// Applications, if not nil, is used to retrieve the metadata served by the v3 API.
+	Applications ApplicationService
+	// MetadataCache, if not nil, caches the metadata retrieved with Applications between requests.
+	//
+	// Metadata is shared by every user an application is assigned to and changes rarely, so it is cached separately from the applications of each user, usually for longer.
+	MetadataCache *ApplicationCache
+	// Rules, if not nil, restrict the applications each user may see beyond their assignments in Okta.
+	Rules *AuthorizationRules
+	// Metrics, if not nil, records metrics about the requests served.
+	Metrics *Metrics
+	// Limiter, if not nil, limits how often each user may cause applications to be fetched from Okta.
+	Limiter *UserRateLimiter
+	// Audit, if not nil, serves a report of who is assigned to each AWS application to its audit groups.
+	Audit *Audit
+	// Workload, if not nil, issues AWS credentials to CI jobs which present an OIDC token from a trusted issuer.
+	Workload *WorkloadIdentity
+	// LoginSettings, if not nil, are served to the CLI to configure how it receives the OAuth2 callback when logging in.
+	LoginSettings *LoginSettings
 }
 
-func (s ServeUserApplicationsHandler) Handle(ctx context.Context, r events.ALBTargetGroupRequest) (w events.ALBTargetGroupResponse, err error) {
+func (s ServeUserApplicationsHandler) filter() ApplicationFilter {
+	if s.Filter == nil {
+		return DefaultApplicationFilter
+	}
+
+	return *s.Filter
+}
+
+// authenticate validates the bearer token of r. If the token is missing or invalid, an error response is written to w and ok is false.
+func (s ServeUserApplicationsHandler) authenticate(ctx context.Context, r Request, w *Response) (claims Claims, ok bool) {
 	requestAttrs := RequestAttrs(r)
 	ts, ok := requestTokenSource(r)
 	if !ok {
 		slog.Error("no bearer token present", requestAttrs...)
-		ServeJSONError(&w, http.StatusUnauthorized, "unauthorized")
-		return
+		ServeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return claims, false
 	}
 
-	info, err := s.Idp.UserInfo(ctx, ts)
+	claims, err := s.Tokens.Validate(ctx, ts)
 	if err != nil {
 		if errors.Is(err, ErrBadRequest) {
+			requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
 			slog.Error("okta indicated the request was poorly formed", requestAttrs...)
-			ServeJSONError(&w, http.StatusInternalServerError, "internal error when talking to the Okta API")
-			return
+			ServeJSONError(w, http.StatusInternalServerError, "internal error when talking to the Okta API")
+			return claims, false
 		}
 
 		requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
 		slog.Error("okta rejected id token", requestAttrs...)
-		ServeJSONError(&w, http.StatusForbidden, "unauthorized")
-		return
+		ServeJSONError(w, http.StatusForbidden, "unauthorized")
+		return claims, false
 	}
 
-	var claims Claims
-	if err := info.Claims(&claims); err != nil {
-		slog.Error("failed to parse claims from Okta userinfo endpoint", requestAttrs...)
-		ServeJSONError(&w, http.StatusInternalServerError, "internal error when talking to the Okta API")
+	return claims, true
+}
+
+// listApplications returns the applications assigned to user, from the cache if possible.
+func (s ServeUserApplicationsHandler) listApplications(ctx context.Context, user string) (links []*okta.AppLink, cached bool, err error) {
+	if s.Cache != nil {
+		links, ok := s.Cache.Get(ctx, user)
+		s.Metrics.observeCache("applications", ok)
+		if ok {
+			return links, true, nil
+		}
+	}
+
+	if s.Limiter != nil {
+		if ok, wait := s.Limiter.Allow(strings.ToLower(user)); !ok {
+			return nil, false, &RateLimitError{RetryAfter: wait}
+		}
 	}
 
-	requestAttrs = append(requestAttrs, slog.String("username", claims.PreferredUsername))
-	applications, err := s.Okta.ListApplicationsForUser(ctx, claims.PreferredUsername)
+	links, err = s.Okta.ListApplicationsForUser(ctx, user)
 	if err != nil {
-		requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
-		slog.Error("failed to fetch applications", requestAttrs...)
-		ServeJSONError(&w, http.StatusBadGateway, "upstream error")
+		return nil, false, err
+	}
+
+	if s.Cache != nil {
+		s.Cache.Set(ctx, user, links)
+	}
+
+	return links, false, nil
+}
+
+// serveListError writes the response for an error returned by listApplications.
+//
+// If the request was rate limited, either by Okta or by Limiter, the client is told when to retry.
+func serveListError(w *Response, err error, requestAttrs []any) {
+	requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		slog.Warn("rate limited while fetching applications", requestAttrs...)
+		ServeRateLimited(w, rateLimitErr.RetryAfter)
 		return
 	}
 
-	var accounts []Application
-	for _, app := range applications {
-		if app.AppName == "amazon_aws" {
-			accounts = append(accounts, Application{
-				ID:   app.AppInstanceId,
-				Name: app.Label,
-			})
+	slog.Error("failed to fetch applications", requestAttrs...)
+	ServeJSONError(w, http.StatusBadGateway, "upstream error")
+}
+
+// authorize returns the applications in links that the user may see.
+//
+// Only applications that would otherwise be served are evaluated, and each decision is logged for audit.
+func (s ServeUserApplicationsHandler) authorize(r Request, claims Claims, links []*okta.AppLink) []*okta.AppLink {
+	if s.Rules == nil {
+		return links
+	}
+
+	filter := s.filter()
+	var permitted []*okta.AppLink
+	for _, link := range links {
+		if _, ok := filter.Type(link); !ok {
+			continue
+		}
+
+		decision := s.Rules.Evaluate(claims, link)
+		attrs := append(RequestAttrs(r),
+			slog.String("username", claims.Username()),
+			slog.String("application_id", link.AppInstanceId),
+			slog.String("application_label", link.Label),
+			slog.String("effect", string(decision.Effect)),
+			slog.String("rule", decision.Rule),
+		)
+		slog.Info("authorization decision", attrs...)
+
+		if decision.Allowed() {
+			permitted = append(permitted, link)
 		}
 	}
 
-	requestAttrs = append(requestAttrs, slog.Int("application_count", len(accounts)))
+	return permitted
+}
+
+// cacheControl returns the Cache-Control header for application lists.
+//
+// Clients may reuse a list for as long as the server would, but the list is specific to the user and must not be stored by shared caches.
+func (s ServeUserApplicationsHandler) cacheControl() string {
+	if s.Cache == nil || s.Cache.TTL <= 0 {
+		return "private, no-cache"
+	}
+
+	return fmt.Sprintf("private, max-age=%d", int(s.Cache.TTL.Seconds()))
+}
+
+func (s ServeUserApplicationsHandler) Handle(ctx context.Context, r Request) (w Response, err error) {
+	claims, ok := s.authenticate(ctx, r, &w)
+	if !ok {
+		return w, nil
+	}
+
+	requestAttrs := RequestAttrs(r)
+	requestAttrs = append(requestAttrs, slog.String("username", claims.Username()))
+	applications, cached, err := s.listApplications(ctx, claims.Username())
+	if err != nil {
+		serveListError(&w, err, requestAttrs)
+		return w, nil
+	}
+
+	applications = s.authorize(r, claims, applications)
+	accounts := s.filter().Applications(applications)
+	s.Metrics.observeApplicationCount(len(accounts))
+	requestAttrs = append(requestAttrs, slog.Int("application_count", len(accounts)), slog.Bool("cached", cached))
 	slog.Info("served applications", requestAttrs...)
 	ServeJSON(&w, accounts)
+	w.SetHeader("Cache-Control", s.cacheControl())
+	ServeNotModified(&w, r)
 	return
 }
 
+// Invalidate removes the applications of the caller from the cache, so that changes to their assignments in Okta are visible on their next request.
+func (s ServeUserApplicationsHandler) Invalidate(ctx context.Context, r Request) (w Response, err error) {
+	claims, ok := s.authenticate(ctx, r, &w)
+	if !ok {
+		return w, nil
+	}
+
+	requestAttrs := append(RequestAttrs(r), slog.String("username", claims.Username()))
+	if s.Cache != nil {
+		if err := s.Cache.Invalidate(ctx, claims.Username()); err != nil {
+			requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
+			slog.Error("failed to invalidate cached applications", requestAttrs...)
+			ServeJSONError(&w, http.StatusBadGateway, "could not invalidate the cache")
+			return w, nil
+		}
+	}
+
+	slog.Info("invalidated cached applications", requestAttrs...)
+	w.StatusCode = http.StatusNoContent
+	return w, nil
+}
+
+// Routes returns a Router serving the application lists and the endpoint used to invalidate them.
+func (s ServeUserApplicationsHandler) Routes() *Router {
+	var rt Router
+	route := func(method, path string, h RequestHandler) {
+		rt.Route(method, path, s.Metrics.Instrument(path, h))
+	}
+
+	route(http.MethodPost, "/v2/applications", s)
+	route(http.MethodDelete, "/v2/applications/cache", RequestHandlerFunc(s.Invalidate))
+	route(http.MethodGet, "/v3/applications", RequestHandlerFunc(s.HandleV3))
+	if s.Audit != nil {
+		route(http.MethodGet, "/v3/audit", RequestHandlerFunc(s.HandleAudit))
+	}
+
+	if s.LoginSettings != nil {
+		route(http.MethodGet, "/v3/settings/login", RequestHandlerFunc(s.HandleLoginSettings))
+	}
+
+	if s.Workload != nil {
+		route(http.MethodPost, "/v3/workload/credentials", RequestHandlerFunc(s.HandleWorkloadCredentials))
+	}
+	return &rt
+}
+
+// requestHandler returns the RequestHandler serving every route, with access logging.
+func (s ServeUserApplicationsHandler) requestHandler() RequestHandler {
+	return AccessLog(s.Routes())
+}
+
 func (s ServeUserApplicationsHandler) Handler() lambda.Handler {
-	return lambda.NewHandler(s.Handle)
+	return NewLambdaHandler(s.requestHandler())
 }
 
-func ServeUserApplications(okta OktaService, idp *oidc.Provider) lambda.Handler {
+func ServeUserApplications(okta OktaService, tokens TokenValidator) lambda.Handler {
 	h := ServeUserApplicationsHandler{
-		Okta: okta,
-		Idp:  idp,
+		Okta:   okta,
+		Tokens: tokens,
 	}
 
 	return h.Handler()
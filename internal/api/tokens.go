@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// TokenValidator authenticates the bearer token of a request and returns the claims of the user it was issued to.
+//
+// Implementations should return an error wrapping ErrBadRequest if the provider could not be asked about the token, and ErrUnauthorized if the token was rejected.
+type TokenValidator interface {
+	Validate(ctx context.Context, ts oauth2.TokenSource) (Claims, error)
+}
+
+// UserInfoValidator validates tokens by calling the UserInfo endpoint of the OIDC provider.
+//
+// This requires a request to the provider for every token validated, but works with tokens that cannot be validated locally, such as those issued by the Okta org authorization server.
+type UserInfoValidator struct {
+	Provider *oidc.Provider
+}
+
+func (u UserInfoValidator) Validate(ctx context.Context, ts oauth2.TokenSource) (Claims, error) {
+	var claims Claims
+	info, err := u.Provider.UserInfo(ctx, ts)
+	if err != nil {
+		return claims, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	if err := info.Claims(&claims); err != nil {
+		return claims, fmt.Errorf("%w: parse userinfo claims: %w", ErrBadRequest, err)
+	}
+
+	return claims, nil
+}
+
+// JWTValidator validates JWT access tokens locally against the signing keys published by the provider.
+//
+// The keys are fetched from the JWKS endpoint of the provider and cached. Keys are fetched again when a token is signed by a key that is not in the cache, so key rotation does not require a restart.
+type JWTValidator struct {
+	verifier *oidc.IDTokenVerifier
+	// ClientIDs, if not empty, restricts the tokens that are accepted to those issued to one of these clients through the cid claim.
+	ClientIDs []string
+	// Fallback is used to validate opaque tokens, which are not JWTs and so cannot be validated locally. If nil, those tokens are rejected.
+	//
+	// JWTs which fail local validation are always rejected, so that the fallback cannot be used to bypass the issuer, audience and client checks.
+	Fallback TokenValidator
+}
+
+// NewJWTValidator returns a validator for tokens issued by issuer for the given audience, signed by one of the keys in keySet.
+//
+// If audience is empty, the audience of the token is not checked.
+func NewJWTValidator(keySet oidc.KeySet, issuer, audience string, clientIDs []string) *JWTValidator {
+	cfg := oidc.Config{
+		ClientID:          audience,
+		SkipClientIDCheck: audience == "",
+	}
+
+	return &JWTValidator{
+		verifier:  oidc.NewVerifier(issuer, keySet, &cfg),
+		ClientIDs: clientIDs,
+	}
+}
+
+var errClientNotPermitted = errors.New("token was issued to a client that is not permitted")
+
+func (j *JWTValidator) Validate(ctx context.Context, ts oauth2.TokenSource) (Claims, error) {
+	if j.Fallback != nil {
+		tok, err := ts.Token()
+		if err != nil {
+			return Claims{}, fmt.Errorf("%w: %w", ErrBadRequest, err)
+		}
+
+		if _, err := jose.ParseSigned(tok.AccessToken); err != nil {
+			return j.Fallback.Validate(ctx, ts)
+		}
+	}
+
+	return j.validateLocally(ctx, ts)
+}
+
+func (j *JWTValidator) validateLocally(ctx context.Context, ts oauth2.TokenSource) (Claims, error) {
+	var claims Claims
+	tok, err := ts.Token()
+	if err != nil {
+		return claims, fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+
+	// The verifier checks the signature, issuer, audience and expiry of the token.
+	verified, err := j.verifier.Verify(ctx, tok.AccessToken)
+	if err != nil {
+		return claims, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	if err := verified.Claims(&claims); err != nil {
+		return claims, fmt.Errorf("%w: parse token claims: %w", ErrUnauthorized, err)
+	}
+
+	if len(j.ClientIDs) > 0 && !slices.Contains(j.ClientIDs, claims.ClientID) {
+		return claims, fmt.Errorf("%w: %w: %q", ErrUnauthorized, errClientNotPermitted, claims.ClientID)
+	}
+
+	return claims, nil
+}
+
+// TokenValidationMode is the way the webserver validates bearer tokens.
+type TokenValidationMode string
+
+const (
+	// TokenValidationUserInfo validates tokens by calling the UserInfo endpoint of the provider.
+	TokenValidationUserInfo TokenValidationMode = "userinfo"
+	// TokenValidationJWT validates tokens locally.
+	TokenValidationJWT TokenValidationMode = "jwt"
+	// TokenValidationJWTWithUserInfoFallback validates JWTs locally, calling the UserInfo endpoint of the provider for opaque tokens.
+	TokenValidationJWTWithUserInfoFallback TokenValidationMode = "jwt+userinfo"
+)
+
+// ParseTokenValidationMode parses a TokenValidationMode, returning an error if it is not one of the known modes.
+func ParseTokenValidationMode(s string) (TokenValidationMode, error) {
+	mode := TokenValidationMode(strings.ToLower(s))
+	switch mode {
+	case TokenValidationUserInfo, TokenValidationJWT, TokenValidationJWTWithUserInfoFallback:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown token validation mode %q", s)
+	}
+}
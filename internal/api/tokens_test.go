@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const testIssuer = "https://example.okta.com/oauth2/default"
+
+// staticKeySet verifies signatures against a single in-memory key.
+type staticKeySet struct {
+	key *rsa.PublicKey
+}
+
+func (s staticKeySet) VerifySignature(_ context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return jws.Verify(s.key)
+}
+
+// fixedValidator always returns the same claims and error.
+type fixedValidator struct {
+	claims Claims
+	err    error
+	calls  int
+}
+
+func (f *fixedValidator) Validate(context.Context, oauth2.TokenSource) (Claims, error) {
+	f.calls++
+	return f.claims, f.err
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]any) oauth2.TokenSource {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	jws, err := signer.Sign(payload)
+	require.NoError(t, err)
+
+	raw, err := jws.CompactSerialize()
+	require.NoError(t, err)
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: raw})
+}
+
+func accessTokenClaims() map[string]any {
+	return map[string]any{
+		"iss": testIssuer,
+		"aud": "api://default",
+		"sub": "user@example.com",
+		"uid": "00u1234",
+		"cid": "keyconjurer",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+func TestJWTValidator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		modify func(claims map[string]any)
+		valid  bool
+	}{
+		{"Valid", key, func(map[string]any) {}, true},
+		{"WrongKey", otherKey, func(map[string]any) {}, false},
+		{"WrongIssuer", key, func(c map[string]any) { c["iss"] = "https://evil.example.com" }, false},
+		{"WrongAudience", key, func(c map[string]any) { c["aud"] = "api://other" }, false},
+		{"Expired", key, func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, false},
+		{"WrongClient", key, func(c map[string]any) { c["cid"] = "someone-else" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewJWTValidator(staticKeySet{&key.PublicKey}, testIssuer, "api://default", []string{"keyconjurer"})
+			claims := accessTokenClaims()
+			tt.modify(claims)
+
+			got, err := validator.Validate(context.Background(), signToken(t, tt.key, claims))
+			if !tt.valid {
+				assert.ErrorIs(t, err, ErrUnauthorized)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "user@example.com", got.Username())
+			assert.Equal(t, "00u1234", got.UserID)
+			assert.Equal(t, "keyconjurer", got.ClientID)
+		})
+	}
+}
+
+func TestJWTValidator_UsesFallbackForOpaqueTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fallback := fixedValidator{claims: Claims{PreferredUsername: "user@example.com"}}
+	validator := NewJWTValidator(staticKeySet{&key.PublicKey}, testIssuer, "", nil)
+	validator.Fallback = &fallback
+
+	opaque := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "not-a-jwt"})
+	claims, err := validator.Validate(context.Background(), opaque)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", claims.Username())
+	assert.Equal(t, 1, fallback.calls)
+
+	// Tokens that can be validated locally should not reach the fallback.
+	_, err = validator.Validate(context.Background(), signToken(t, key, accessTokenClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, fallback.calls)
+
+	// Nor should JWTs which are rejected, otherwise the fallback would accept them.
+	expired := accessTokenClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, err = validator.Validate(context.Background(), signToken(t, key, expired))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, 1, fallback.calls)
+}
+
+func TestServeUserApplicationsHandler_RejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		err        error
+		statusCode int
+	}{
+		{ErrUnauthorized, 403},
+		{ErrBadRequest, 500},
+		{errors.Join(ErrBadRequest, errors.New("userinfo unavailable")), 500},
+	}
+
+	for _, tt := range tests {
+		h := ServeUserApplicationsHandler{Tokens: &fixedValidator{err: tt.err}}
+		req := Request{Headers: map[string][]string{"Authorization": {"Bearer token"}}}
+		w, err := h.Handle(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, tt.statusCode, w.Status())
+	}
+}
+
+func TestParseTokenValidationMode(t *testing.T) {
+	mode, err := ParseTokenValidationMode("JWT+UserInfo")
+	require.NoError(t, err)
+	assert.Equal(t, TokenValidationJWTWithUserInfoFallback, mode)
+
+	_, err = ParseTokenValidationMode("magic")
+	assert.Error(t, err)
+}
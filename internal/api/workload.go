@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/coreos/go-oidc"
+	"gopkg.in/yaml.v3"
+)
+
+// WorkloadIssuer is an OIDC provider whose tokens identify CI jobs, such as GitHub Actions, GitLab or Buildkite.
+type WorkloadIssuer struct {
+	// Name identifies the issuer in rules and audit logs.
+	Name   string `yaml:"name"`
+	Issuer string `yaml:"issuer"`
+	// Audience is the audience tokens must be issued for. CI providers allow jobs to choose the audience of their tokens, so this must be specific to KeyConjurer.
+	Audience string `yaml:"audience"`
+	// JWKSURL is the URL of the signing keys of the issuer. If omitted, it is discovered from the issuer.
+	JWKSURL string `yaml:"jwks_url"`
+}
+
+// WorkloadRule grants CI jobs whose tokens match its claims credentials for a role.
+type WorkloadRule struct {
+	// Name identifies the rule in audit logs.
+	Name string `yaml:"name"`
+	// Issuer is the name of the WorkloadIssuer the rule applies to.
+	Issuer string `yaml:"issuer"`
+	// Claims are regular expressions which claims of the token must match in full, keyed by the name of the claim, such as repository or ref.
+	Claims  map[string]string `yaml:"claims"`
+	RoleARN string            `yaml:"role_arn"`
+	// Duration is the longest the credentials may be valid for. It defaults to one hour, which is also the most AWS allows, as the webserver assumes the role with role credentials of its own.
+	Duration time.Duration `yaml:"duration"`
+
+	claims map[string]*regexp.Regexp
+}
+
+const (
+	defaultWorkloadDuration = time.Hour
+	minWorkloadDuration     = 15 * time.Minute
+	// maxWorkloadDuration is the limit AWS places on sessions created by role chaining.
+	maxWorkloadDuration = time.Hour
+)
+
+func (r *WorkloadRule) compile(issuers []WorkloadIssuer) error {
+	if r.Name == "" {
+		return errors.New("a name is required")
+	}
+
+	if !slices.ContainsFunc(issuers, func(issuer WorkloadIssuer) bool { return issuer.Name == r.Issuer }) {
+		return fmt.Errorf("unknown issuer %q", r.Issuer)
+	}
+
+	// A rule without conditions would grant the role to every job of every customer of the CI provider.
+	if len(r.Claims) == 0 {
+		return errors.New("at least one claim must be matched")
+	}
+
+	if _, err := arn.Parse(r.RoleARN); err != nil {
+		return fmt.Errorf("invalid role_arn: %w", err)
+	}
+
+	if r.Duration == 0 {
+		r.Duration = defaultWorkloadDuration
+	}
+
+	if r.Duration < minWorkloadDuration || r.Duration > maxWorkloadDuration {
+		return fmt.Errorf("duration must be between %s and %s", minWorkloadDuration, maxWorkloadDuration)
+	}
+
+	r.claims = make(map[string]*regexp.Regexp, len(r.Claims))
+	for claim, pattern := range r.Claims {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern for claim %q: %w", claim, err)
+		}
+
+		r.claims[claim] = re
+	}
+
+	return nil
+}
+
+func (r WorkloadRule) matches(issuer string, claims map[string]any) bool {
+	if r.Issuer != issuer {
+		return false
+	}
+
+	for claim, re := range r.claims {
+		if !slices.ContainsFunc(claimValues(claims[claim]), re.MatchString) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// claimValues returns the values of a claim as strings. Claims which are arrays match if any of their elements match.
+func claimValues(value any) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, elem := range v {
+			values = append(values, claimValues(elem)...)
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// WorkloadPolicy decides which CI jobs may retrieve credentials for which roles.
+type WorkloadPolicy struct {
+	Issuers []WorkloadIssuer `yaml:"issuers"`
+	// Rules are evaluated in order, and the first rule that matches the token, and the role if one was requested, is used.
+	Rules []WorkloadRule `yaml:"rules"`
+}
+
+// ReadWorkloadPolicy reads a WorkloadPolicy from YAML, such as:
+//
+//	issuers:
+//	  - name: github
+//	    issuer: https://token.actions.githubusercontent.com
+//	    audience: keyconjurer
+//	rules:
+//	  - name: deploy-production
+//	    issuer: github
+//	    claims:
+//	      repository: example/service
+//	      ref: refs/heads/main
+//	    role_arn: arn:aws:iam::123456789012:role/deploy
+//	    duration: 1h
+func ReadWorkloadPolicy(r io.Reader) (WorkloadPolicy, error) {
+	var policy WorkloadPolicy
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return policy, err
+	}
+
+	names := make(map[string]bool)
+	// Tokens are matched to an issuer by their iss claim, so two issuers with the same URL would let a job be granted the rules of either.
+	urls := make(map[string]bool)
+	for idx, issuer := range policy.Issuers {
+		switch {
+		case issuer.Name == "":
+			return policy, fmt.Errorf("issuer %d: a name is required", idx+1)
+		case names[issuer.Name]:
+			return policy, fmt.Errorf("issuer %d: duplicate name %q", idx+1, issuer.Name)
+		case issuer.Issuer == "":
+			return policy, fmt.Errorf("issuer %d: issuer is required", idx+1)
+		case urls[issuer.Issuer]:
+			return policy, fmt.Errorf("issuer %d: duplicate issuer %q", idx+1, issuer.Issuer)
+		case issuer.Audience == "":
+			return policy, fmt.Errorf("issuer %d: audience is required", idx+1)
+		}
+
+		names[issuer.Name] = true
+		urls[issuer.Issuer] = true
+	}
+
+	for idx := range policy.Rules {
+		if err := policy.Rules[idx].compile(policy.Issuers); err != nil {
+			return policy, fmt.Errorf("rule %d: %w", idx+1, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// RoleAssumer assumes IAM roles. It is implemented by *sts.Client.
+type RoleAssumer interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+// WorkloadIdentity vends AWS credentials to CI jobs which present an OIDC token from one of the issuers in its policy.
+//
+// Credentials are issued by assuming the role of the matching rule with the credentials of the webserver, so each role must trust the role of the webserver.
+type WorkloadIdentity struct {
+	Policy WorkloadPolicy
+	STS    RoleAssumer
+
+	verifiers map[string]*oidc.IDTokenVerifier
+}
+
+// NewWorkloadIdentity returns a WorkloadIdentity for policy. The signing keys of issuers without a JWKS URL are discovered from the issuer.
+func NewWorkloadIdentity(ctx context.Context, policy WorkloadPolicy, sts RoleAssumer) (*WorkloadIdentity, error) {
+	w := WorkloadIdentity{Policy: policy, STS: sts, verifiers: make(map[string]*oidc.IDTokenVerifier)}
+	for _, issuer := range policy.Issuers {
+		jwksURL := issuer.JWKSURL
+		if jwksURL == "" {
+			provider, err := oidc.NewProvider(ctx, issuer.Issuer)
+			if err != nil {
+				return nil, fmt.Errorf("could not discover %s: %w", issuer.Issuer, err)
+			}
+
+			var discovery struct {
+				JWKSURL string `json:"jwks_uri"`
+			}
+			if err := provider.Claims(&discovery); err != nil {
+				return nil, fmt.Errorf("could not read discovery document for %s: %w", issuer.Issuer, err)
+			}
+			jwksURL = discovery.JWKSURL
+		}
+
+		w.verifiers[issuer.Issuer] = oidc.NewVerifier(issuer.Issuer, oidc.NewRemoteKeySet(ctx, jwksURL), &oidc.Config{ClientID: issuer.Audience})
+	}
+
+	return &w, nil
+}
+
+var (
+	errUnknownIssuer  = errors.New("token was not issued by a configured issuer")
+	errNoWorkloadRule = errors.New("no rule grants this workload the requested role")
+)
+
+// verify validates token and returns the issuer it was issued by and its claims.
+func (w *WorkloadIdentity) verify(ctx context.Context, token string) (WorkloadIssuer, map[string]any, error) {
+	// The issuer is read from the unverified token to choose the verifier, which then checks it.
+	var unverified struct {
+		Issuer string `json:"iss"`
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return WorkloadIssuer{}, nil, fmt.Errorf("%w: malformed token", ErrUnauthorized)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &unverified) != nil {
+		return WorkloadIssuer{}, nil, fmt.Errorf("%w: malformed token", ErrUnauthorized)
+	}
+
+	idx := slices.IndexFunc(w.Policy.Issuers, func(issuer WorkloadIssuer) bool { return issuer.Issuer == unverified.Issuer })
+	if idx < 0 {
+		return WorkloadIssuer{}, nil, fmt.Errorf("%w: %w", ErrUnauthorized, errUnknownIssuer)
+	}
+
+	issuer := w.Policy.Issuers[idx]
+	idToken, err := w.verifiers[issuer.Issuer].Verify(ctx, token)
+	if err != nil {
+		return issuer, nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return issuer, nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	return issuer, claims, nil
+}
+
+// rule returns the first rule granting a token from issuer with the given claims the role roleARN, or any role if roleARN is empty.
+func (w *WorkloadIdentity) rule(issuer string, claims map[string]any, roleARN string) (WorkloadRule, bool) {
+	for _, rule := range w.Policy.Rules {
+		if (roleARN == "" || rule.RoleARN == roleARN) && rule.matches(issuer, claims) {
+			return rule, true
+		}
+	}
+
+	return WorkloadRule{}, false
+}
+
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// workloadSessionName returns the role session name for a CI job, which appears in CloudTrail.
+func workloadSessionName(issuer, subject string) string {
+	name := invalidSessionNameChars.ReplaceAllString(fmt.Sprintf("%s@%s", subject, issuer), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return name
+}
+
+// WorkloadCredentialsRequest is the optional body of a request for workload credentials.
+type WorkloadCredentialsRequest struct {
+	// RoleARN selects the role to assume if more than one rule matches the token.
+	RoleARN string `json:"role_arn,omitempty"`
+	// DurationSeconds shortens how long the credentials are valid for.
+	DurationSeconds int32 `json:"duration_seconds,omitempty"`
+}
+
+// WorkloadCredentials are the credentials issued to a CI job, in the format expected from an AWS credential_process.
+type WorkloadCredentials struct {
+	Version         int       `json:"Version"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// HandleWorkloadCredentials issues AWS credentials to a CI job which presents an OIDC token from a configured issuer as its bearer token.
+//
+// Every issuance and denial is logged for audit.
+func (s ServeUserApplicationsHandler) HandleWorkloadCredentials(ctx context.Context, r Request) (w Response, err error) {
+	requestAttrs := RequestAttrs(r)
+	token, ok := strings.CutPrefix(r.Headers.Get("Authorization"), "Bearer ")
+	if !ok || s.Workload == nil {
+		ServeJSONError(&w, http.StatusUnauthorized, "unauthorized")
+		return w, nil
+	}
+
+	var body WorkloadCredentialsRequest
+	if strings.TrimSpace(r.Body) != "" {
+		if err := json.Unmarshal([]byte(r.Body), &body); err != nil || body.DurationSeconds < 0 {
+			ServeJSONError(&w, http.StatusBadRequest, "invalid request body")
+			return w, nil
+		}
+	}
+
+	issuer, claims, err := s.Workload.verify(ctx, token)
+	if err != nil {
+		requestAttrs = append(requestAttrs, slog.String("issuer", issuer.Name), slog.String("error", err.Error()))
+		slog.Warn("denied workload credentials", requestAttrs...)
+		ServeJSONError(&w, http.StatusForbidden, "unauthorized")
+		return w, nil
+	}
+
+	subject, _ := claims["sub"].(string)
+	requestAttrs = append(requestAttrs, slog.String("issuer", issuer.Name), slog.String("subject", subject), slog.String("requested_role_arn", body.RoleARN))
+	rule, ok := s.Workload.rule(issuer.Name, claims, body.RoleARN)
+	if !ok {
+		requestAttrs = append(requestAttrs, slog.String("error", errNoWorkloadRule.Error()))
+		slog.Warn("denied workload credentials", requestAttrs...)
+		ServeJSONError(&w, http.StatusForbidden, errNoWorkloadRule.Error())
+		return w, nil
+	}
+
+	duration := rule.Duration
+	if requested := time.Duration(body.DurationSeconds) * time.Second; requested > 0 && requested < duration {
+		duration = max(requested, minWorkloadDuration)
+	}
+
+	sessionName := workloadSessionName(issuer.Name, subject)
+	requestAttrs = append(requestAttrs, slog.String("rule", rule.Name), slog.String("role_arn", rule.RoleARN), slog.String("session_name", sessionName))
+	out, err := s.Workload.STS.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(rule.RoleARN),
+		RoleSessionName: aws.String(sessionName),
+		DurationSeconds: aws.Int32(int32(duration.Seconds())),
+	})
+	if err != nil {
+		requestAttrs = append(requestAttrs, slog.String("error", err.Error()))
+		slog.Error("could not assume role for workload", requestAttrs...)
+		ServeJSONError(&w, http.StatusBadGateway, "could not assume role")
+		return w, nil
+	}
+
+	creds := WorkloadCredentials{
+		Version:         1,
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Expiration:      aws.ToTime(out.Credentials.Expiration),
+	}
+
+	requestAttrs = append(requestAttrs, slog.String("access_key_id", creds.AccessKeyID), slog.Time("expiration", creds.Expiration))
+	slog.Info("issued workload credentials", requestAttrs...)
+	ServeJSON(&w, creds)
+	w.SetHeader("Cache-Control", "no-store")
+	return w, nil
+}
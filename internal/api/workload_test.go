@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const testCIIssuer = "https://token.actions.githubusercontent.com"
+
+// fakeSTS records the roles assumed through it.
+type fakeSTS struct {
+	inputs []*sts.AssumeRoleInput
+}
+
+func (f *fakeSTS) AssumeRole(_ context.Context, params *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.inputs = append(f.inputs, params)
+	return &sts.AssumeRoleOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("ASIAEXAMPLE"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("session"),
+		Expiration:      aws.Time(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)),
+	}}, nil
+}
+
+const testWorkloadPolicy = `
+issuers:
+  - name: github
+    issuer: https://token.actions.githubusercontent.com
+    audience: keyconjurer
+    jwks_url: %s
+rules:
+  - name: deploy-production
+    issuer: github
+    claims:
+      repository: example/service
+      ref: refs/heads/main
+    role_arn: arn:aws:iam::123456789012:role/deploy
+  - name: read-only
+    issuer: github
+    claims:
+      repository_owner: example
+    role_arn: arn:aws:iam::123456789012:role/read-only
+    duration: 30m
+`
+
+// newTestWorkloadIdentity returns a WorkloadIdentity trusting tokens signed by key, whose public key is served from a local JWKS endpoint.
+func newTestWorkloadIdentity(t *testing.T, key *rsa.PrivateKey, sts RoleAssumer) *WorkloadIdentity {
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, Algorithm: "RS256", Use: "sig"}}})
+	}))
+	t.Cleanup(jwks.Close)
+
+	policy, err := ReadWorkloadPolicy(strings.NewReader(strings.Replace(testWorkloadPolicy, "%s", jwks.URL, 1)))
+	require.NoError(t, err)
+
+	workload, err := NewWorkloadIdentity(context.Background(), policy, sts)
+	require.NoError(t, err)
+	return workload
+}
+
+func ciTokenClaims() map[string]any {
+	return map[string]any{
+		"iss":              testCIIssuer,
+		"aud":              "keyconjurer",
+		"sub":              "repo:example/service:ref:refs/heads/main",
+		"repository":       "example/service",
+		"repository_owner": "example",
+		"ref":              "refs/heads/main",
+		"exp":              time.Now().Add(5 * time.Minute).Unix(),
+		"iat":              time.Now().Unix(),
+	}
+}
+
+func workloadRequest(t *testing.T, key *rsa.PrivateKey, claims map[string]any, body string) Request {
+	token, err := signToken(t, key, claims).Token()
+	require.NoError(t, err)
+	return Request{Method: "POST", Path: "/v3/workload/credentials", Headers: http.Header{"Authorization": {"Bearer " + token.AccessToken}}, Body: body}
+}
+
+func TestServeUserApplicationsHandler_WorkloadCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var stub fakeSTS
+	routes := ServeUserApplicationsHandler{Workload: newTestWorkloadIdentity(t, key, &stub)}.Routes()
+
+	w, err := routes.Handle(context.Background(), workloadRequest(t, key, ciTokenClaims(), ""))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+
+	var creds WorkloadCredentials
+	require.NoError(t, json.Unmarshal([]byte(w.Body), &creds))
+	assert.Equal(t, WorkloadCredentials{Version: 1, AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "session", Expiration: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)}, creds)
+
+	require.Len(t, stub.inputs, 1)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/deploy", aws.ToString(stub.inputs[0].RoleArn))
+	assert.Equal(t, "repo_example_service_ref_refs_heads_main@github", aws.ToString(stub.inputs[0].RoleSessionName))
+	assert.Equal(t, int32(3600), aws.ToInt32(stub.inputs[0].DurationSeconds))
+
+	// A job may choose between the roles it is granted, but cannot exceed the duration of the rule.
+	w, err = routes.Handle(context.Background(), workloadRequest(t, key, ciTokenClaims(), `{"role_arn": "arn:aws:iam::123456789012:role/read-only", "duration_seconds": 43200}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Status())
+	assert.Equal(t, "arn:aws:iam::123456789012:role/read-only", aws.ToString(stub.inputs[1].RoleArn))
+	assert.Equal(t, int32(1800), aws.ToInt32(stub.inputs[1].DurationSeconds))
+
+	denied := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		modify func(map[string]any)
+		body   string
+	}{
+		{name: "branch not permitted", key: key, modify: func(c map[string]any) { c["ref"] = "refs/heads/feature"; c["repository_owner"] = "someone-else" }},
+		{name: "claims must match in full", key: key, modify: func(c map[string]any) { c["repository"] = "example/service-fork"; c["repository_owner"] = "example-fork" }},
+		{name: "role not granted", key: key, modify: func(map[string]any) {}, body: `{"role_arn": "arn:aws:iam::123456789012:role/admin"}`},
+		{name: "wrong audience", key: key, modify: func(c map[string]any) { c["aud"] = "sts.amazonaws.com" }},
+		{name: "unknown issuer", key: key, modify: func(c map[string]any) { c["iss"] = "https://gitlab.example.com" }},
+		{name: "expired", key: key, modify: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "wrong key", key: otherKey, modify: func(map[string]any) {}},
+	}
+
+	for _, tt := range denied {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := ciTokenClaims()
+			tt.modify(claims)
+			w, err := routes.Handle(context.Background(), workloadRequest(t, tt.key, claims, tt.body))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusForbidden, w.Status())
+		})
+	}
+
+	assert.Len(t, stub.inputs, 2)
+}
+
+func TestReadWorkloadPolicy_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"audience is required": `
+issuers:
+  - name: github
+    issuer: https://token.actions.githubusercontent.com
+`,
+		"duplicate issuer": `
+issuers:
+  - {name: github, issuer: https://token.actions.githubusercontent.com, audience: keyconjurer}
+  - {name: github-deploy, issuer: https://token.actions.githubusercontent.com, audience: keyconjurer-deploy}
+`,
+		"unknown issuer": `
+rules:
+  - name: deploy
+    issuer: github
+    claims: {repository: example/service}
+    role_arn: arn:aws:iam::123456789012:role/deploy
+`,
+		"at least one claim must be matched": `
+issuers:
+  - {name: github, issuer: https://token.actions.githubusercontent.com, audience: keyconjurer}
+rules:
+  - name: deploy
+    issuer: github
+    role_arn: arn:aws:iam::123456789012:role/deploy
+`,
+		"duration must be between": `
+issuers:
+  - {name: github, issuer: https://token.actions.githubusercontent.com, audience: keyconjurer}
+rules:
+  - name: deploy
+    issuer: github
+    claims: {repository: example/service}
+    role_arn: arn:aws:iam::123456789012:role/deploy
+    duration: 2h
+`,
+	}
+
+	for want, policy := range tests {
+		t.Run(want, func(t *testing.T) {
+			_, err := ReadWorkloadPolicy(strings.NewReader(policy))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), want)
+		})
+	}
+}
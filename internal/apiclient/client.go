@@ -0,0 +1,291 @@
+// Package apiclient is a typed client for the account service, whose endpoints are described by internal/api/openapi.yaml.
+//
+// The client authenticates requests with an OAuth2 token source, decodes error responses into typed errors and chooses the newest version of the API the server supports.
+//
+// Responses are decoded into the types of the api package, which the tests of that package check against the schemas in the OpenAPI document.
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"log/slog"
+
+	"github.com/riotgames/key-conjurer/internal/api"
+	"golang.org/x/oauth2"
+)
+
+// Version is a version of the account service API.
+type Version int
+
+const (
+	// VersionAuto negotiates the version, preferring the newest version the server supports.
+	VersionAuto Version = 0
+	V2          Version = 2
+	V3          Version = 3
+)
+
+var (
+	// ErrNotModified indicates that the list has not changed since the version identified by the ETag given by the caller.
+	ErrNotModified = errors.New("not modified")
+	// ErrVersionNotSupported indicates that the server does not serve the requested version of the API.
+	ErrVersionNotSupported = errors.New("API version not supported")
+)
+
+// Error is an error response from the account service.
+type Error struct {
+	StatusCode int
+	// Message is the error message given by the server, if any.
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("status code %d", e.StatusCode)
+}
+
+// RateLimitError indicates that the server refused the request because too many requests have been made.
+type RateLimitError struct {
+	// RetryAfter is how long the server asked the client to wait before retrying.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// DefaultMaxRetryWait is the longest a Client waits to retry a request that was rate limited, by default.
+const DefaultMaxRetryWait = 10 * time.Second
+
+// Client is a client for the account service.
+type Client struct {
+	BaseURL    *url.URL
+	HTTPClient *http.Client
+	// Version is the version of the API to use. If it is VersionAuto, the newest version supported by the server is used, and Version is updated once it is known.
+	Version Version
+	// MaxRetryWait is the longest the client will wait to retry a request which was rate limited. Requests are retried at most once.
+	MaxRetryWait time.Duration
+}
+
+// New returns a Client for the account service at baseURL, authenticating requests with tokens from ts.
+func New(ctx context.Context, baseURL *url.URL, ts oauth2.TokenSource) *Client {
+	return &Client{
+		BaseURL:      baseURL,
+		HTTPClient:   oauth2.NewClient(ctx, ts),
+		MaxRetryWait: DefaultMaxRetryWait,
+	}
+}
+
+// ApplicationList is a list of applications and the ETag identifying that version of the list.
+type ApplicationList struct {
+	Applications []api.ApplicationV3
+	ETag         string
+	// Version is the version of the API the list was retrieved from. Lists from V2 do not include metadata.
+	Version Version
+}
+
+// ListApplications lists the applications assigned to the caller.
+//
+// If etag is not empty and the list has not changed since it was issued, ErrNotModified is returned.
+func (c *Client) ListApplications(ctx context.Context, etag string) (ApplicationList, error) {
+	if c.Version == VersionAuto || c.Version == V3 {
+		list, err := c.listApplicationsV3(ctx, etag)
+		if errors.Is(err, ErrVersionNotSupported) && c.Version == VersionAuto {
+			c.Version = V2
+			return c.listApplicationsV2(ctx, etag)
+		}
+
+		if err == nil {
+			c.Version = V3
+		}
+
+		return list, err
+	}
+
+	return c.listApplicationsV2(ctx, etag)
+}
+
+func (c *Client) listApplicationsV3(ctx context.Context, etag string) (ApplicationList, error) {
+	var body json.RawMessage
+	newETag, err := c.do(ctx, http.MethodGet, "/v3/applications", etag, &body)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+		return ApplicationList{}, ErrVersionNotSupported
+	}
+
+	if err != nil {
+		return ApplicationList{}, err
+	}
+
+	resp, err := decodeApplicationsV3(body)
+	if err != nil {
+		return ApplicationList{}, err
+	}
+
+	return ApplicationList{Applications: resp.Applications, ETag: newETag, Version: V3}, nil
+}
+
+// decodeApplicationsV3 decodes the body of a successful response from the v3 applications endpoint.
+//
+// Servers which predate the v3 API serve the v2 list for every path, so ErrVersionNotSupported is returned if the body is not an object with an applications field.
+func decodeApplicationsV3(body []byte) (api.ApplicationsV3Response, error) {
+	var resp api.ApplicationsV3Response
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return resp, ErrVersionNotSupported
+	}
+
+	if _, ok := fields["applications"]; !ok {
+		return resp, ErrVersionNotSupported
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) listApplicationsV2(ctx context.Context, etag string) (ApplicationList, error) {
+	var apps []api.Application
+	newETag, err := c.do(ctx, http.MethodPost, "/v2/applications", etag, &apps)
+	if err != nil {
+		return ApplicationList{}, err
+	}
+
+	list := ApplicationList{Applications: make([]api.ApplicationV3, len(apps)), ETag: newETag, Version: V2}
+	for idx, app := range apps {
+		list.Applications[idx] = api.ApplicationV3{
+			ID:    app.ID,
+			Label: app.Name,
+			Type:  app.Type,
+			Alias: api.DefaultAlias(app.Name),
+		}
+	}
+
+	return list, nil
+}
+
+// InvalidateCache removes the applications of the caller from the cache of the server.
+func (c *Client) InvalidateCache(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v2/applications/cache", "", nil)
+	return err
+}
+
+// AuditReport retrieves the report of who is assigned to each AWS application. Only members of the audit groups configured on the server may retrieve it.
+func (c *Client) AuditReport(ctx context.Context) (api.AuditReport, error) {
+	var report api.AuditReport
+	_, err := c.do(ctx, http.MethodGet, "/v3/audit", "", &report)
+	return report, err
+}
+
+// LoginSettings retrieves the settings the server asks the CLI to log in with. No token is required.
+//
+// Servers which predate login settings, or which do not configure them, return empty settings.
+func (c *Client) LoginSettings(ctx context.Context) (api.LoginSettings, error) {
+	var settings api.LoginSettings
+	_, err := c.do(ctx, http.MethodGet, "/v3/settings/login", "", &settings)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return api.LoginSettings{}, nil
+	}
+
+	return settings, err
+}
+
+// do sends a request and decodes a successful response into v, if v is not nil. It returns the ETag of the response.
+//
+// A request which is rate limited is retried once if the server asks the client to wait no longer than MaxRetryWait.
+func (c *Client) do(ctx context.Context, method, path, etag string, v any) (string, error) {
+	for attempt := 0; ; attempt++ {
+		newETag, err := c.doOnce(ctx, method, path, etag, v)
+		var rateLimitErr *RateLimitError
+		if !errors.As(err, &rateLimitErr) || attempt > 0 || rateLimitErr.RetryAfter > c.MaxRetryWait {
+			return newETag, err
+		}
+
+		slog.Debug("rate limited by the account server, retrying", slog.Duration("wait", rateLimitErr.RetryAfter))
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(rateLimitErr.RetryAfter):
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path, etag string, v any) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL.ResolveReference(&url.URL{Path: path}).String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to issue request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return etag, ErrNotModified
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "", &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", decodeError(resp)
+	}
+
+	if v == nil {
+		return resp.Header.Get("ETag"), nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// decodeError reads the error message from an error response, if there is one.
+func decodeError(resp *http.Response) error {
+	apiErr := Error{StatusCode: resp.StatusCode}
+	var body api.JSONError
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err == nil {
+		apiErr.Message = body.Message
+	}
+
+	return &apiErr
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or a HTTP date.
+// If the header is missing or invalid, a default of one second is returned.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+
+	return time.Second
+}
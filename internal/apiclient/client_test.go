@@ -0,0 +1,114 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_FallsBackToV2(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	// This server predates the v3 API.
+	var calls int
+	mux.HandleFunc("POST /v2/applications", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`[{"@id":"0oa1","name":"AWS - Production Account","type":"aws"}]`))
+	})
+
+	serverURL, _ := url.Parse(srv.URL)
+	client := &Client{BaseURL: serverURL}
+	list, err := client.ListApplications(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, V2, list.Version)
+	assert.Equal(t, "production-account", list.Applications[0].Alias)
+
+	// The negotiated version is remembered.
+	assert.Equal(t, V2, client.Version)
+	_, err = client.ListApplications(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestClient_FallsBackToV2ForServersWhichIgnoreThePath(t *testing.T) {
+	// Like the original server, which serves the v2 list for every request regardless of its method or path.
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"@id":"0oa1","name":"AWS - Production Account"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	serverURL, _ := url.Parse(srv.URL)
+	client := &Client{BaseURL: serverURL}
+	list, err := client.ListApplications(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, V2, list.Version)
+	assert.Equal(t, V2, client.Version)
+	require.Len(t, list.Applications, 1)
+	assert.Equal(t, "0oa1", list.Applications[0].ID)
+	assert.Equal(t, []string{"GET /v3/applications", "POST /v2/applications"}, paths)
+}
+
+func Test_decodeApplicationsV3(t *testing.T) {
+	resp, err := decodeApplicationsV3([]byte(`{"applications":[{"id":"0oa1"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "0oa1", resp.Applications[0].ID)
+
+	_, err = decodeApplicationsV3([]byte(`{"applications":[]}`))
+	assert.NoError(t, err)
+	_, err = decodeApplicationsV3([]byte(`[]`))
+	assert.ErrorIs(t, err, ErrVersionNotSupported)
+	_, err = decodeApplicationsV3([]byte(`{"error":"not found"}`))
+	assert.ErrorIs(t, err, ErrVersionNotSupported)
+	_, err = decodeApplicationsV3([]byte(`{"applications":{}}`))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrVersionNotSupported)
+}
+
+func TestClient_RetriesWhenRateLimited(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"applications":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	serverURL, _ := url.Parse(srv.URL)
+	client := &Client{BaseURL: serverURL, MaxRetryWait: time.Second}
+	_, err := client.ListApplications(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestClient_DecodesErrorsWithoutBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	serverURL, _ := url.Parse(srv.URL)
+	_, err := (&Client{BaseURL: serverURL}).ListApplications(context.Background(), "")
+	assert.EqualError(t, err, "status code 502")
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Second, parseRetryAfter("", now))
+}
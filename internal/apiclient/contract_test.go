@@ -0,0 +1,164 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// The tests in this file run the client against the real account service handler, so that changes to either which break the other are caught.
+
+// tokenValidator accepts the access tokens in users, returning the claims of the user each token belongs to.
+type tokenValidator map[string]api.Claims
+
+func (v tokenValidator) Validate(_ context.Context, ts oauth2.TokenSource) (api.Claims, error) {
+	token, err := ts.Token()
+	if err != nil {
+		return api.Claims{}, err
+	}
+
+	claims, ok := v[token.AccessToken]
+	if !ok {
+		return api.Claims{}, api.ErrUnauthorized
+	}
+
+	return claims, nil
+}
+
+type oktaService struct{}
+
+func (oktaService) ListApplicationsForUser(context.Context, string) ([]*okta.AppLink, error) {
+	return []*okta.AppLink{
+		{AppInstanceId: "0oa1", AppName: "amazon_aws", Label: "AWS - Production"},
+		{AppInstanceId: "0oa2", AppName: "salesforce", Label: "Salesforce"},
+	}, nil
+}
+
+type auditService struct{}
+
+func (auditService) ListApplicationsByName(context.Context, string) ([]*okta.Application, error) {
+	return []*okta.Application{{Id: "0oa1", Label: "AWS - Production", Status: "ACTIVE"}}, nil
+}
+
+func (auditService) ListApplicationUsers(context.Context, string) ([]*okta.AppUser, error) {
+	return []*okta.AppUser{{Id: "00u1", Scope: "USER"}}, nil
+}
+
+func (auditService) ListApplicationGroups(context.Context, string) ([]*okta.ApplicationGroupAssignment, error) {
+	return nil, nil
+}
+
+func newContractServer(t *testing.T, h api.ServeUserApplicationsHandler) *url.URL {
+	h.Okta = oktaService{}
+	h.Tokens = tokenValidator{
+		"user-token":    {PreferredUsername: "user@example.com"},
+		"auditor-token": {PreferredUsername: "auditor@example.com", Groups: []string{"Auditors"}},
+	}
+	h.Audit = &api.Audit{Service: auditService{}, ClientID: "0oaKeyConjurer", Groups: []string{"Auditors"}}
+
+	srv := httptest.NewServer(api.NewServeMux(h))
+	t.Cleanup(srv.Close)
+	serverURL, _ := url.Parse(srv.URL)
+	return serverURL
+}
+
+func newTestClient(serverURL *url.URL, token string) *Client {
+	return New(context.Background(), serverURL, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+}
+
+func TestContract_ListApplications(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(newContractServer(t, api.ServeUserApplicationsHandler{}), "user-token")
+
+	list, err := client.ListApplications(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, V3, list.Version)
+	assert.Equal(t, V3, client.Version)
+	assert.NotEmpty(t, list.ETag)
+	assert.Equal(t, []api.ApplicationV3{{
+		ID:         "0oa1",
+		Label:      "AWS - Production",
+		AppName:    "amazon_aws",
+		Type:       api.ApplicationTypeAWS,
+		Alias:      "production",
+		AccountIDs: []string{},
+		SortKey:    "aws - production",
+	}}, list.Applications)
+
+	_, err = client.ListApplications(ctx, list.ETag)
+	assert.ErrorIs(t, err, ErrNotModified)
+}
+
+func TestContract_V2(t *testing.T) {
+	client := newTestClient(newContractServer(t, api.ServeUserApplicationsHandler{}), "user-token")
+	client.Version = V2
+
+	list, err := client.ListApplications(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, V2, list.Version)
+	assert.Equal(t, []api.ApplicationV3{{ID: "0oa1", Label: "AWS - Production", Type: api.ApplicationTypeAWS, Alias: "production"}}, list.Applications)
+}
+
+func TestContract_InvalidateCache(t *testing.T) {
+	cache := &api.ApplicationCache{Backend: api.NewMemoryCache(), TTL: 0}
+	client := newTestClient(newContractServer(t, api.ServeUserApplicationsHandler{Cache: cache}), "user-token")
+	assert.NoError(t, client.InvalidateCache(context.Background()))
+}
+
+func TestContract_Errors(t *testing.T) {
+	ctx := context.Background()
+	serverURL := newContractServer(t, api.ServeUserApplicationsHandler{Limiter: api.NewUserRateLimiter(1, 1)})
+
+	_, err := newTestClient(serverURL, "invalid-token").ListApplications(ctx, "")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Equal(t, "unauthorized", apiErr.Message)
+
+	_, err = newTestClient(serverURL, "user-token").AuditReport(ctx)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	client := newTestClient(serverURL, "user-token")
+	client.MaxRetryWait = 0
+	_, err = client.ListApplications(ctx, "")
+	require.NoError(t, err)
+	_, err = client.ListApplications(ctx, "")
+	var rateLimitErr *RateLimitError
+	require.ErrorAs(t, err, &rateLimitErr)
+	assert.Greater(t, rateLimitErr.RetryAfter.Seconds(), 0.0)
+}
+
+func TestContract_AuditReport(t *testing.T) {
+	client := newTestClient(newContractServer(t, api.ServeUserApplicationsHandler{}), "auditor-token")
+	report, err := client.AuditReport(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Applications, 1)
+	assert.Equal(t, "0oa1", report.Applications[0].ID)
+	assert.True(t, report.Applications[0].ClientIDMismatch)
+	assert.Equal(t, []api.AuditUser{{ID: "00u1", Scope: "USER"}}, report.Applications[0].Users)
+}
+
+func TestContract_LoginSettings(t *testing.T) {
+	settings := api.LoginSettings{CallbackPorts: []string{"57468", "47512"}, CallbackBindAddress: "::1", CallbackRedirectHost: "[::1]"}
+	serverURL := newContractServer(t, api.ServeUserApplicationsHandler{LoginSettings: &settings})
+
+	client := Client{BaseURL: serverURL}
+	got, err := client.LoginSettings(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, settings, got)
+
+	// Servers which do not configure login settings serve none.
+	client.BaseURL = newContractServer(t, api.ServeUserApplicationsHandler{})
+	got, err = client.LoginSettings(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, api.LoginSettings{}, got)
+}
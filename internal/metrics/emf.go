@@ -0,0 +1,192 @@
+// Package metrics writes the metrics gathered from a Prometheus registry as CloudWatch Embedded Metric Format logs, for processes such as Lambda functions which have no endpoint that metrics could be scraped from.
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// EMFWriter writes the changes to the metrics of a Prometheus registry since it last wrote them as CloudWatch Embedded Metric Format logs, one JSON document per series.
+//
+// Counters are written as the amount they have increased by. Histograms are written as the distribution of the observations since they were last written, with the upper bound of each bucket as a value and the number of observations in it as its count, so that CloudWatch can compute percentiles. Other types of metric are not written.
+type EMFWriter struct {
+	Gatherer prometheus.Gatherer
+	// Namespace is the CloudWatch namespace the metrics are published to.
+	Namespace string
+
+	mu sync.Mutex
+	// written holds the value of each metric when it was last written, keyed by seriesKey.
+	written map[string]float64
+}
+
+type emfMetadata struct {
+	Timestamp         int64                `json:"Timestamp"`
+	CloudWatchMetrics []emfMetricDirective `json:"CloudWatchMetrics"`
+}
+
+type emfMetricDirective struct {
+	Namespace  string                `json:"Namespace"`
+	Dimensions [][]string            `json:"Dimensions"`
+	Metrics    []emfMetricDefinition `json:"Metrics"`
+}
+
+type emfMetricDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit,omitempty"`
+}
+
+// emfDistribution is a set of values and the number of times each was observed, in the form CloudWatch accepts for a metric in place of a single value.
+type emfDistribution struct {
+	Values []float64 `json:"Values"`
+	Counts []float64 `json:"Counts"`
+	Max    float64   `json:"Max"`
+	Min    float64   `json:"Min"`
+	Count  float64   `json:"Count"`
+	Sum    float64   `json:"Sum"`
+}
+
+// sample is the value of a metric to write, either a float64 or an emfDistribution.
+type sample struct {
+	name  string
+	value any
+}
+
+// Write gathers the metrics and writes those which have changed to w.
+func (e *EMFWriter) Write(w io.Writer, now time.Time) error {
+	families, err := e.Gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.written == nil {
+		e.written = make(map[string]float64)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var samples []sample
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				if delta := e.delta(seriesKey(f.GetName(), m.GetLabel()), m.GetCounter().GetValue()); delta != 0 {
+					samples = append(samples, sample{f.GetName(), delta})
+				}
+			case dto.MetricType_HISTOGRAM:
+				if d, ok := e.distribution(seriesKey(f.GetName(), m.GetLabel()), m.GetHistogram()); ok {
+					samples = append(samples, sample{f.GetName(), d})
+				}
+			}
+
+			if len(samples) == 0 {
+				continue
+			}
+
+			if err := enc.Encode(e.document(samples, m.GetLabel(), now)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// delta records value as the last written value of the series key, returning how much it has increased by since it was last written.
+func (e *EMFWriter) delta(key string, value float64) float64 {
+	delta := value - e.written[key]
+	e.written[key] = value
+	return delta
+}
+
+// distribution returns the observations of a histogram since it was last written, or false if there have been none.
+//
+// Each bucket is represented by its upper bound. Observations above the largest bound are counted as that bound, as Prometheus does not record their values.
+func (e *EMFWriter) distribution(key string, h *dto.Histogram) (emfDistribution, bool) {
+	d := emfDistribution{
+		Count: e.delta(key+"\xff_count", float64(h.GetSampleCount())),
+		Sum:   e.delta(key+"\xff_sum", h.GetSampleSum()),
+	}
+
+	// Bucket counts are cumulative, so the observations in each bucket are the difference from the bucket below it.
+	var below, bound float64
+	add := func(value, cumulative float64) {
+		if n := cumulative - below; n > 0 {
+			d.Values = append(d.Values, value)
+			d.Counts = append(d.Counts, n)
+		}
+		below = cumulative
+	}
+
+	for _, b := range h.GetBucket() {
+		bound = b.GetUpperBound()
+		if math.IsInf(bound, 1) {
+			continue
+		}
+		add(bound, e.delta(key+"\xffle="+strconv.FormatFloat(bound, 'g', -1, 64), float64(b.GetCumulativeCount())))
+	}
+	add(bound, d.Count)
+
+	if len(d.Values) == 0 {
+		return emfDistribution{}, false
+	}
+
+	d.Min, d.Max = d.Values[0], d.Values[len(d.Values)-1]
+	return d, true
+}
+
+// document returns the EMF document for the samples of a single series.
+func (e *EMFWriter) document(samples []sample, labels []*dto.LabelPair, now time.Time) map[string]any {
+	doc := make(map[string]any, len(labels)+len(samples)+1)
+	dimensions := make([]string, len(labels))
+	for idx, label := range labels {
+		doc[label.GetName()] = label.GetValue()
+		dimensions[idx] = label.GetName()
+	}
+
+	definitions := make([]emfMetricDefinition, len(samples))
+	for idx, s := range samples {
+		doc[s.name] = s.value
+		definitions[idx] = emfMetricDefinition{Name: s.name, Unit: emfUnit(s.name)}
+	}
+
+	doc["_aws"] = emfMetadata{
+		Timestamp: now.UnixMilli(),
+		CloudWatchMetrics: []emfMetricDirective{{
+			Namespace:  e.Namespace,
+			Dimensions: [][]string{dimensions},
+			Metrics:    definitions,
+		}},
+	}
+	return doc
+}
+
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range labels {
+		b.WriteString("\xff" + label.GetName() + "=" + label.GetValue())
+	}
+
+	return b.String()
+}
+
+// emfUnit returns the CloudWatch unit of a metric from the suffix of its name, following the Prometheus naming conventions.
+func emfUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "Seconds"
+	case strings.HasSuffix(name, "_total"), strings.HasSuffix(name, "_count"):
+		return "Count"
+	}
+
+	return ""
+}
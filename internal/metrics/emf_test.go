@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEMFWriter(t *testing.T) {
+	r := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests served."}, []string{"route"})
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "request_duration_seconds", Help: "Time taken."})
+	r.MustRegister(requests, duration)
+	e := EMFWriter{Gatherer: r, Namespace: "KeyConjurer"}
+	now := time.UnixMilli(1700000000000)
+
+	requests.WithLabelValues("/a").Add(3)
+	duration.Observe(0.25)
+	duration.Observe(0.5)
+
+	var buf bytes.Buffer
+	require.NoError(t, e.Write(&buf, now))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1700000000000,
+			"CloudWatchMetrics": [{"Namespace": "KeyConjurer", "Dimensions": [[]], "Metrics": [{"Name": "request_duration_seconds", "Unit": "Seconds"}]}]
+		},
+		"request_duration_seconds": {"Values": [0.25, 0.5], "Counts": [1, 1], "Min": 0.25, "Max": 0.5, "Count": 2, "Sum": 0.75}
+	}`, lines[0])
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1700000000000,
+			"CloudWatchMetrics": [{"Namespace": "KeyConjurer", "Dimensions": [["route"]], "Metrics": [{"Name": "requests_total", "Unit": "Count"}]}]
+		},
+		"route": "/a",
+		"requests_total": 3
+	}`, lines[1])
+
+	// Only changes since the last call are written.
+	requests.WithLabelValues("/a").Inc()
+	buf.Reset()
+	require.NoError(t, e.Write(&buf, now))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &doc))
+	assert.Equal(t, float64(1), doc["requests_total"])
+
+	buf.Reset()
+	require.NoError(t, e.Write(&buf, now))
+	assert.Empty(t, buf.String())
+
+	// Observations above the largest bucket are counted as its upper bound.
+	duration.Observe(0.5)
+	duration.Observe(20)
+	buf.Reset()
+	require.NoError(t, e.Write(&buf, now))
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, map[string]any{"Values": []any{0.5, 10.0}, "Counts": []any{1.0, 1.0}, "Min": 0.5, "Max": 10.0, "Count": 2.0, "Sum": 20.5}, doc["request_duration_seconds"])
+}
+
+func Test_emfUnit(t *testing.T) {
+	assert.Equal(t, "Seconds", emfUnit("keyconjurer_http_request_duration_seconds"))
+	assert.Equal(t, "Count", emfUnit("keyconjurer_http_requests_total"))
+	assert.Equal(t, "Count", emfUnit("keyconjurer_application_count"))
+	assert.Equal(t, "", emfUnit("keyconjurer_temperature"))
+}
@@ -0,0 +1,239 @@
+// Package oktaauthn implements the parts of the Okta authentication API needed to sign a user in from a terminal, without a browser.
+//
+// A successful transaction produces a one-time session token, which can be exchanged for OAuth2 tokens at the authorization endpoint.
+package oktaauthn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Statuses of a Transaction.
+const (
+	StatusSuccess      = "SUCCESS"
+	StatusMFARequired  = "MFA_REQUIRED"
+	StatusMFAChallenge = "MFA_CHALLENGE"
+)
+
+// Results of a factor which is waiting for the user, such as a push notification.
+const (
+	FactorResultWaiting  = "WAITING"
+	FactorResultRejected = "REJECTED"
+	FactorResultTimeout  = "TIMEOUT"
+)
+
+// Factor types supported by Client.
+const (
+	FactorTypePush = "push"
+	FactorTypeTOTP = "token:software:totp"
+	// FactorTypeDuo is the type of Duo Security factors, whose provider is DUO.
+	FactorTypeDuo = "web"
+)
+
+// DefaultPollInterval is how often a push notification is polled while waiting for the user to respond to it.
+const DefaultPollInterval = 2 * time.Second
+
+var (
+	// ErrPushRejected indicates that the user rejected a push notification.
+	ErrPushRejected = errors.New("push notification rejected")
+	// ErrPushTimeout indicates that the user did not respond to a push notification in time.
+	ErrPushTimeout = errors.New("push notification timed out")
+)
+
+// Error is an error returned by the Okta API.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"errorCode"`
+	Summary    string `json:"errorSummary"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("okta: %s (%s)", e.Summary, e.Code)
+}
+
+// Link is a link to a related resource, which is followed to continue a transaction.
+type Link struct {
+	Name string `json:"name,omitempty"`
+	Href string `json:"href"`
+}
+
+// Factor is an MFA factor the user has enrolled.
+type Factor struct {
+	ID         string `json:"id"`
+	FactorType string `json:"factorType"`
+	Provider   string `json:"provider"`
+	Embedded   struct {
+		// Challenge is set for Okta Verify push notifications which require the user to choose a number.
+		Challenge *struct {
+			CorrectAnswer int `json:"correctAnswer"`
+		} `json:"challenge,omitempty"`
+		// Verification is set for Duo factors which have been challenged.
+		Verification *DuoVerification `json:"verification,omitempty"`
+	} `json:"_embedded"`
+	Links struct {
+		Verify *Link `json:"verify,omitempty"`
+	} `json:"_links"`
+}
+
+// Supported reports whether the factor can be verified by Client.
+func (f Factor) Supported() bool {
+	switch f.FactorType {
+	case FactorTypePush, FactorTypeTOTP:
+		return true
+	case FactorTypeDuo:
+		return f.Provider == "DUO"
+	}
+	return false
+}
+
+// String returns a description of the factor which can be shown to the user.
+func (f Factor) String() string {
+	switch {
+	case f.FactorType == FactorTypePush:
+		return "Okta Verify push notification"
+	case f.FactorType == FactorTypeTOTP && f.Provider == "GOOGLE":
+		return "Google Authenticator code"
+	case f.FactorType == FactorTypeTOTP:
+		return "Okta Verify code"
+	case f.FactorType == FactorTypeDuo && f.Provider == "DUO":
+		return "Duo push notification"
+	}
+	return fmt.Sprintf("%s (%s)", f.FactorType, strings.ToLower(f.Provider))
+}
+
+// Transaction is the state of an authentication transaction.
+type Transaction struct {
+	Status       string `json:"status"`
+	StateToken   string `json:"stateToken,omitempty"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	FactorResult string `json:"factorResult,omitempty"`
+	Embedded     struct {
+		// Factors are the factors the user may verify when the status is StatusMFARequired.
+		Factors []Factor `json:"factors,omitempty"`
+		// Factor is the factor which was challenged when the status is StatusMFAChallenge.
+		Factor *Factor `json:"factor,omitempty"`
+	} `json:"_embedded"`
+	Links struct {
+		Next *Link `json:"next,omitempty"`
+	} `json:"_links"`
+}
+
+// Client makes requests to the authentication API of an Okta organization.
+type Client struct {
+	// Domain is the URL of the Okta organization, such as https://example.okta.com.
+	Domain     string
+	HTTPClient *http.Client
+	// PollInterval is how often push notifications are polled. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+// Authenticate starts a transaction with the username and password of a user.
+//
+// The transaction succeeds immediately if the user has no MFA factors; otherwise its status is StatusMFARequired, and one of its factors must be verified.
+func (c Client) Authenticate(ctx context.Context, username, password string) (Transaction, error) {
+	var tx Transaction
+	err := c.postJSON(ctx, strings.TrimSuffix(c.Domain, "/")+"/api/v1/authn", map[string]string{"username": username, "password": password}, &tx)
+	return tx, err
+}
+
+// VerifyPasscode verifies a TOTP factor with the passcode the user entered.
+func (c Client) VerifyPasscode(ctx context.Context, tx Transaction, factor Factor, passCode string) (Transaction, error) {
+	if factor.Links.Verify == nil {
+		return Transaction{}, fmt.Errorf("factor %s cannot be verified", factor.ID)
+	}
+
+	var next Transaction
+	err := c.postJSON(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": tx.StateToken, "passCode": passCode}, &next)
+	return next, err
+}
+
+// Challenge sends a push notification for factor, returning the transaction which can be polled with WaitForChallenge.
+func (c Client) Challenge(ctx context.Context, tx Transaction, factor Factor) (Transaction, error) {
+	if factor.Links.Verify == nil {
+		return Transaction{}, fmt.Errorf("factor %s cannot be verified", factor.ID)
+	}
+
+	var next Transaction
+	err := c.postJSON(ctx, factor.Links.Verify.Href, map[string]string{"stateToken": tx.StateToken}, &next)
+	return next, err
+}
+
+// WaitForChallenge polls a challenged factor until the user responds to it.
+//
+// ErrPushRejected or ErrPushTimeout is returned if the user rejects the challenge, or does not respond in time.
+func (c Client) WaitForChallenge(ctx context.Context, tx Transaction) (Transaction, error) {
+	interval := c.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+
+	for tx.Status == StatusMFAChallenge {
+		switch tx.FactorResult {
+		case FactorResultRejected:
+			return tx, ErrPushRejected
+		case FactorResultTimeout:
+			return tx, ErrPushTimeout
+		}
+
+		if tx.Links.Next == nil {
+			return tx, errors.New("okta: challenge cannot be polled")
+		}
+
+		select {
+		case <-ctx.Done():
+			return tx, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var next Transaction
+		if err := c.postJSON(ctx, tx.Links.Next.Href, map[string]string{"stateToken": tx.StateToken}, &next); err != nil {
+			return tx, err
+		}
+		tx = next
+	}
+
+	return tx, nil
+}
+
+func (c Client) postJSON(ctx context.Context, uri string, body, v any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := Error{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Code == "" {
+			return fmt.Errorf("okta: unexpected status code %d", resp.StatusCode)
+		}
+		return &apiErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,139 @@
+package oktaauthn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+func newTestClient(t *testing.T, factors ...oktatest.Factor) (Client, context.Context) {
+	srv := oktatest.NewServer("0oaKeyConjurer", oktatest.User{ID: "00u1", Login: "user@example.com", Password: "hunter2", Factors: factors})
+	t.Cleanup(srv.Close)
+	return Client{Domain: srv.URL, HTTPClient: srv.Client(), PollInterval: time.Millisecond}, context.Background()
+}
+
+func TestClient_AuthenticateWithoutFactors(t *testing.T) {
+	client, ctx := newTestClient(t)
+
+	_, err := client.Authenticate(ctx, "user@example.com", "wrong")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "E0000004", apiErr.Code)
+
+	tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, tx.Status)
+	assert.NotEmpty(t, tx.SessionToken)
+}
+
+func TestClient_VerifyPasscode(t *testing.T) {
+	client, ctx := newTestClient(t, oktatest.Factor{Type: oktatest.FactorTypeTOTP, Secret: testSecret})
+	tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+	require.NoError(t, err)
+	require.Equal(t, StatusMFARequired, tx.Status)
+	require.Len(t, tx.Embedded.Factors, 1)
+	factor := tx.Embedded.Factors[0]
+	assert.True(t, factor.Supported())
+	assert.Equal(t, "Okta Verify code", factor.String())
+
+	_, err = client.VerifyPasscode(ctx, tx, factor, "000000")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "E0000068", apiErr.Code)
+
+	passCode, err := oktatest.Passcode(testSecret, time.Now())
+	require.NoError(t, err)
+	tx, err = client.VerifyPasscode(ctx, tx, factor, passCode)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSuccess, tx.Status)
+	assert.NotEmpty(t, tx.SessionToken)
+}
+
+func TestClient_WaitForChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		err    error
+	}{
+		{name: "approved", result: oktatest.FactorResultSuccess},
+		{name: "rejected", result: oktatest.FactorResultRejected, err: ErrPushRejected},
+		{name: "timed out", result: oktatest.FactorResultTimeout, err: ErrPushTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, ctx := newTestClient(t, oktatest.Factor{Type: oktatest.FactorTypePush, Result: tt.result, CorrectAnswer: 42})
+			tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+			require.NoError(t, err)
+
+			tx, err = client.Challenge(ctx, tx, tx.Embedded.Factors[0])
+			require.NoError(t, err)
+			assert.Equal(t, StatusMFAChallenge, tx.Status)
+			assert.Equal(t, FactorResultWaiting, tx.FactorResult)
+			require.NotNil(t, tx.Embedded.Factor.Embedded.Challenge)
+			assert.Equal(t, 42, tx.Embedded.Factor.Embedded.Challenge.CorrectAnswer)
+
+			tx, err = client.WaitForChallenge(ctx, tx)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, StatusSuccess, tx.Status)
+			assert.NotEmpty(t, tx.SessionToken)
+		})
+	}
+}
+
+func TestClient_VerifyDuo(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		err    error
+	}{
+		{name: "approved", result: oktatest.FactorResultSuccess},
+		{name: "rejected", result: oktatest.FactorResultRejected, err: ErrPushRejected},
+		{name: "timed out", result: oktatest.FactorResultTimeout, err: ErrPushTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, ctx := newTestClient(t, oktatest.Factor{Type: oktatest.FactorTypeDuo, Result: tt.result})
+			tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+			require.NoError(t, err)
+			factor := tx.Embedded.Factors[0]
+			assert.Equal(t, "Duo push notification", factor.String())
+
+			tx, err = client.VerifyDuo(ctx, tx, factor)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, StatusSuccess, tx.Status)
+			assert.NotEmpty(t, tx.SessionToken)
+		})
+	}
+}
+
+func TestClient_WaitForChallengeStopsWhenCancelled(t *testing.T) {
+	client, ctx := newTestClient(t, oktatest.Factor{Type: oktatest.FactorTypePush})
+	client.PollInterval = time.Hour
+	tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+	require.NoError(t, err)
+	tx, err = client.Challenge(ctx, tx, tx.Embedded.Factors[0])
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = client.WaitForChallenge(ctx, tx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
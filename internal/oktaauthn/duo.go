@@ -0,0 +1,189 @@
+package oktaauthn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// duoFrameVersion is the version of the Duo web frame the requests imitate.
+const duoFrameVersion = "2.6"
+
+// DuoVerification describes the Duo web frame a Duo factor is verified in, which Okta embeds in its sign in page.
+type DuoVerification struct {
+	// Host is the API hostname of the Duo account.
+	Host string `json:"host"`
+	// Signature is the signed request for Duo, of the form TX|...:APP|...
+	Signature string `json:"signature"`
+	Links     struct {
+		// Complete is where the signed response of Duo is posted once the user has been verified.
+		Complete *Link `json:"complete,omitempty"`
+	} `json:"_links"`
+}
+
+// duoResponse is the envelope of the responses of the Duo web frame.
+type duoResponse struct {
+	Stat     string          `json:"stat"`
+	Message  string          `json:"message"`
+	Response json.RawMessage `json:"response"`
+}
+
+// VerifyDuo verifies a Duo factor by sending a Duo push notification to the first device of the user, as the Duo web frame does when the user chooses Duo Push.
+//
+// VerifyDuo is experimental. Duo has no API for this, so it imitates the legacy web frame, whose endpoints are undocumented and may change or be retired at any time. Users whose first device cannot receive push notifications cannot be verified.
+//
+// ErrPushRejected or ErrPushTimeout is returned if the user rejects the push notification, or does not respond in time.
+func (c Client) VerifyDuo(ctx context.Context, tx Transaction, factor Factor) (Transaction, error) {
+	challenge, err := c.Challenge(ctx, tx, factor)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if challenge.Embedded.Factor == nil || challenge.Embedded.Factor.Embedded.Verification == nil {
+		return Transaction{}, errors.New("okta: duo verification is missing from the challenge")
+	}
+
+	verification := challenge.Embedded.Factor.Embedded.Verification
+	if verification.Links.Complete == nil {
+		return Transaction{}, errors.New("okta: duo verification has no callback")
+	}
+
+	txSig, appSig, ok := strings.Cut(verification.Signature, ":")
+	if !ok {
+		return Transaction{}, errors.New("okta: duo signature is malformed")
+	}
+
+	duoURL := "https://" + verification.Host
+	sid, err := c.startDuoSession(ctx, duoURL, txSig, verification.Links.Complete.Href)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	cookie, err := c.pushDuo(ctx, duoURL, sid)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	// Okta verifies the response of Duo when the web frame posts it back, as it would from the sign in page.
+	form := url.Values{"id": {factor.ID}, "stateToken": {tx.StateToken}, "sig_response": {cookie + ":" + appSig}}
+	resp, err := c.postForm(ctx, verification.Links.Complete.Href, form)
+	if err != nil {
+		return Transaction{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Transaction{}, fmt.Errorf("okta: duo callback returned status code %d", resp.StatusCode)
+	}
+
+	return c.WaitForChallenge(ctx, challenge)
+}
+
+// startDuoSession starts a session of the Duo web frame, returning its ID.
+func (c Client) startDuoSession(ctx context.Context, duoURL, txSig, parent string) (string, error) {
+	params := url.Values{"tx": {txSig}, "parent": {parent}, "v": {duoFrameVersion}}
+	req, err := http.NewRequestWithContext(ctx, "POST", duoURL+"/frame/web/v1/auth?"+params.Encode(), strings.NewReader(url.Values{"parent": {parent}}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// The session ID is in the URL of the prompt Duo redirects to.
+	client := *c.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("duo: unexpected status code %d", resp.StatusCode)
+	}
+
+	sid := location.Query().Get("sid")
+	if sid == "" {
+		return "", errors.New("duo: no session ID in redirect")
+	}
+	return sid, nil
+}
+
+// pushDuo sends a Duo push notification from the session sid and waits for the user to approve it, returning the signed response of Duo.
+func (c Client) pushDuo(ctx context.Context, duoURL, sid string) (string, error) {
+	var prompt struct {
+		TxID string `json:"txid"`
+	}
+	if err := c.postDuo(ctx, duoURL+"/frame/prompt", url.Values{"sid": {sid}, "device": {"phone1"}, "factor": {"Duo Push"}, "out_of_date": {"False"}}, &prompt); err != nil {
+		return "", err
+	}
+
+	interval := c.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+
+	for {
+		var status struct {
+			StatusCode string `json:"status_code"`
+			Result     string `json:"result"`
+			ResultURL  string `json:"result_url"`
+		}
+		if err := c.postDuo(ctx, duoURL+"/frame/status", url.Values{"sid": {sid}, "txid": {prompt.TxID}}, &status); err != nil {
+			return "", err
+		}
+
+		switch {
+		case status.Result == "SUCCESS":
+			var result struct {
+				Cookie string `json:"cookie"`
+			}
+			if err := c.postDuo(ctx, duoURL+status.ResultURL, url.Values{"sid": {sid}}, &result); err != nil {
+				return "", err
+			}
+			return result.Cookie, nil
+		case status.StatusCode == "timeout":
+			return "", ErrPushTimeout
+		case status.Result == "FAILURE":
+			return "", ErrPushRejected
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c Client) postForm(ctx context.Context, uri string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.httpClient().Do(req)
+}
+
+func (c Client) postDuo(ctx context.Context, uri string, form url.Values, v any) error {
+	resp, err := c.postForm(ctx, uri, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body duoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("duo: unexpected response with status code %d", resp.StatusCode)
+	}
+
+	if body.Stat != "OK" {
+		return fmt.Errorf("duo: %s", body.Message)
+	}
+
+	return json.Unmarshal(body.Response, v)
+}
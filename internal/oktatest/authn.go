@@ -0,0 +1,402 @@
+package oktatest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Factor types of the authentication API.
+const (
+	FactorTypePush = "push"
+	FactorTypeTOTP = "token:software:totp"
+	// FactorTypeDuo is the type of Duo Security factors, which are verified in a web frame hosted by Duo.
+	FactorTypeDuo = "web"
+)
+
+// Results of push notifications, sent either by Okta Verify or by Duo.
+const (
+	FactorResultSuccess  = "SUCCESS"
+	FactorResultRejected = "REJECTED"
+	FactorResultTimeout  = "TIMEOUT"
+)
+
+const (
+	transactionLifetime = 5 * time.Minute
+	totpPeriod          = 30
+	// pushPolls is the number of times a push notification is polled before the user responds to it.
+	pushPolls = 2
+)
+
+// Factor is an MFA factor enrolled by a user.
+type Factor struct {
+	// Type is one of FactorTypePush, FactorTypeTOTP or FactorTypeDuo.
+	Type string
+	// Secret is the base32 encoded secret of a TOTP factor.
+	Secret string
+	// Result is how the user responds to push notifications. Defaults to FactorResultSuccess.
+	Result string
+	// CorrectAnswer, if not zero, is the number the user must choose in Okta Verify to approve a push notification.
+	CorrectAnswer int
+}
+
+func (f Factor) provider() string {
+	if f.Type == FactorTypeDuo {
+		return "DUO"
+	}
+	return "OKTA"
+}
+
+func (f Factor) result() string {
+	if f.Result == "" {
+		return FactorResultSuccess
+	}
+	return f.Result
+}
+
+// transaction is an authentication transaction waiting for the user to verify a factor.
+type transaction struct {
+	userID string
+	expiry time.Time
+	// factorID is the factor a push notification or Duo prompt has been sent for.
+	factorID string
+	polls    int
+
+	// duoTx and duoApp are the parts of the signature of the Duo prompt, and duoTxID the Duo push notification sent from it.
+	duoTx    string
+	duoApp   string
+	duoTxID  string
+	duoPolls int
+	// duoCookie is the signed response of Duo once the user has approved the push notification.
+	duoCookie   string
+	duoVerified bool
+}
+
+// Passcode returns the TOTP passcode of secret at t, as an authenticator app would show it.
+func Passcode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(strings.ToUpper(secret), "="))
+	if err != nil {
+		return "", fmt.Errorf("oktatest: invalid TOTP secret: %w", err)
+	}
+
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, uint64(t.Unix()/totpPeriod))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// validPasscode reports whether passCode is the passcode of secret, allowing for the clock of the user to be a period out.
+func validPasscode(secret, passCode string) bool {
+	now := time.Now()
+	for _, t := range []time.Time{now, now.Add(-totpPeriod * time.Second), now.Add(totpPeriod * time.Second)} {
+		if expected, err := Passcode(secret, t); err == nil && passCode == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func factorID(user User, i int) string {
+	return fmt.Sprintf("opf%s%d", user.ID, i)
+}
+
+func (s *Server) verifyURL(id string) string {
+	return fmt.Sprintf("%s/api/v1/authn/factors/%s/verify", s.URL, id)
+}
+
+func (s *Server) duoCallbackURL(id string) string {
+	return fmt.Sprintf("%s/api/v1/authn/factors/%s/lifecycle/duoCallback", s.URL, id)
+}
+
+// serveAuthn implements the primary authentication of the authentication API, which starts a transaction with the username and password of a user.
+func (s *Server) serveAuthn(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.findUser(body.Username)
+	if !ok || user.Password == "" || body.Password != user.Password {
+		writeAPIError(w, http.StatusUnauthorized, "E0000004", "Authentication failed")
+		return
+	}
+
+	if len(user.Factors) == 0 {
+		s.writeAuthnSuccess(w, "", user)
+		return
+	}
+
+	stateToken := randomString()
+	expiry := time.Now().Add(transactionLifetime)
+	s.transactions[stateToken] = &transaction{userID: user.ID, expiry: expiry}
+
+	factors := make([]map[string]any, len(user.Factors))
+	for i, f := range user.Factors {
+		factors[i] = map[string]any{
+			"id":         factorID(user, i),
+			"factorType": f.Type,
+			"provider":   f.provider(),
+			"_links": map[string]any{
+				"verify": map[string]any{"href": s.verifyURL(factorID(user, i))},
+			},
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"stateToken": stateToken,
+		"expiresAt":  expiry.UTC().Format(time.RFC3339),
+		"status":     "MFA_REQUIRED",
+		"_embedded": map[string]any{
+			"user":    map[string]any{"id": user.ID, "profile": map[string]any{"login": user.Login}},
+			"factors": factors,
+		},
+	})
+}
+
+// serveVerifyFactor implements the verification of a factor in a transaction.
+//
+// TOTP factors are verified by their passcode. Push and Duo factors are challenged by the first request, and polled by those that follow.
+func (s *Server) serveVerifyFactor(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		StateToken string `json:"stateToken"`
+		PassCode   string `json:"passCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.transactions[body.StateToken]
+	if !ok || time.Now().After(tx.expiry) {
+		writeAPIError(w, http.StatusUnauthorized, "E0000011", "Invalid token provided")
+		return
+	}
+
+	id := r.PathValue("id")
+	user, _ := s.findUser(tx.userID)
+	i := -1
+	for j := range user.Factors {
+		if factorID(user, j) == id {
+			i = j
+		}
+	}
+
+	if i < 0 {
+		writeAPIError(w, http.StatusNotFound, "E0000007", fmt.Sprintf("Not found: Resource not found: %s (UserFactor)", id))
+		return
+	}
+
+	factor := user.Factors[i]
+	switch factor.Type {
+	case FactorTypeTOTP:
+		if !validPasscode(factor.Secret, body.PassCode) {
+			writeAPIError(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer")
+			return
+		}
+		s.writeAuthnSuccess(w, body.StateToken, user)
+	case FactorTypePush:
+		if tx.factorID != id {
+			tx.factorID, tx.polls = id, 0
+		}
+
+		tx.polls++
+		result := "WAITING"
+		if tx.polls > pushPolls {
+			result = factor.result()
+		}
+
+		if result == FactorResultSuccess {
+			s.writeAuthnSuccess(w, body.StateToken, user)
+			return
+		}
+
+		embedded := map[string]any{}
+		if factor.CorrectAnswer != 0 {
+			embedded["challenge"] = map[string]any{"correctAnswer": factor.CorrectAnswer}
+		}
+		s.writeChallenge(w, body.StateToken, id, factor, result, embedded)
+	case FactorTypeDuo:
+		if tx.factorID != id {
+			tx.factorID = id
+			tx.duoTx, tx.duoApp = "TX|"+randomString(), "APP|"+randomString()
+		}
+
+		if tx.duoVerified {
+			s.writeAuthnSuccess(w, body.StateToken, user)
+			return
+		}
+
+		s.writeChallenge(w, body.StateToken, id, factor, "WAITING", map[string]any{
+			"verification": map[string]any{
+				"host":      strings.TrimPrefix(s.URL, "https://"),
+				"signature": tx.duoTx + ":" + tx.duoApp,
+				"_links": map[string]any{
+					"complete": map[string]any{"href": s.duoCallbackURL(id)},
+				},
+			},
+		})
+	default:
+		writeAPIError(w, http.StatusBadRequest, "E0000001", "Api validation failed: factorType")
+	}
+}
+
+// writeChallenge responds that the factor with the given ID has been challenged, and is waiting for the user. The lock must be held.
+func (s *Server) writeChallenge(w http.ResponseWriter, stateToken, id string, factor Factor, result string, embedded map[string]any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"stateToken":   stateToken,
+		"status":       "MFA_CHALLENGE",
+		"factorResult": result,
+		"_embedded": map[string]any{
+			"factor": map[string]any{
+				"id":         id,
+				"factorType": factor.Type,
+				"provider":   factor.provider(),
+				"_embedded":  embedded,
+			},
+		},
+		"_links": map[string]any{
+			"next": map[string]any{"name": "poll", "href": s.verifyURL(id)},
+		},
+	})
+}
+
+// writeAuthnSuccess completes the transaction of stateToken, if any, and issues a session token for user. The lock must be held.
+func (s *Server) writeAuthnSuccess(w http.ResponseWriter, stateToken string, user User) {
+	delete(s.transactions, stateToken)
+	sessionToken := randomString()
+	s.sessionTokens[sessionToken] = user.ID
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":       "SUCCESS",
+		"sessionToken": sessionToken,
+		"expiresAt":    time.Now().Add(transactionLifetime).UTC().Format(time.RFC3339),
+		"_embedded": map[string]any{
+			"user": map[string]any{"id": user.ID, "profile": map[string]any{"login": user.Login}},
+		},
+	})
+}
+
+// serveDuoCallback receives the signed response of Duo once the user has approved the Duo push notification, completing the verification of the factor.
+func (s *Server) serveDuoCallback(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.transactions[r.PostFormValue("stateToken")]
+	if !ok || tx.factorID != r.PathValue("id") || tx.duoCookie == "" || r.PostFormValue("sig_response") != tx.duoCookie+":"+tx.duoApp {
+		http.Error(w, "The Duo response is invalid.", http.StatusForbidden)
+		return
+	}
+
+	tx.duoVerified = true
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<html><body></body></html>")
+}
+
+// duoTransaction returns the transaction of the Duo prompt session sid. The lock must be held.
+func (s *Server) duoTransaction(sid string) (*transaction, Factor, bool) {
+	tx, ok := s.transactions[s.duoSessions[sid]]
+	if !ok {
+		return nil, Factor{}, false
+	}
+
+	user, _ := s.findUser(tx.userID)
+	for i, f := range user.Factors {
+		if factorID(user, i) == tx.factorID {
+			return tx, f, true
+		}
+	}
+	return nil, Factor{}, false
+}
+
+// serveDuoAuth starts a Duo prompt session for the transaction the tx part of a Duo signature was issued for, redirecting to the prompt.
+func (s *Server) serveDuoAuth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stateToken, tx := range s.transactions {
+		if tx.duoTx != "" && tx.duoTx == r.URL.Query().Get("tx") && r.URL.Query().Get("parent") == s.duoCallbackURL(tx.factorID) {
+			sid := randomString()
+			s.duoSessions[sid] = stateToken
+			http.Redirect(w, r, "/frame/prompt?sid="+sid, http.StatusFound)
+			return
+		}
+	}
+
+	http.Error(w, "Invalid request.", http.StatusBadRequest)
+}
+
+// serveDuoPrompt sends a Duo push notification to the device of the user.
+func (s *Server) serveDuoPrompt(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, _, ok := s.duoTransaction(r.PostFormValue("sid"))
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]any{"stat": "FAIL", "message": "Invalid session."})
+		return
+	}
+
+	if r.PostFormValue("factor") != "Duo Push" {
+		writeJSON(w, http.StatusOK, map[string]any{"stat": "FAIL", "message": "Unsupported factor."})
+		return
+	}
+
+	tx.duoTxID, tx.duoPolls = randomString(), 0
+	writeJSON(w, http.StatusOK, map[string]any{"stat": "OK", "response": map[string]any{"txid": tx.duoTxID}})
+}
+
+// serveDuoStatus reports whether the user has responded to a Duo push notification.
+func (s *Server) serveDuoStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, factor, ok := s.duoTransaction(r.PostFormValue("sid"))
+	if !ok || tx.duoTxID == "" || r.PostFormValue("txid") != tx.duoTxID {
+		writeJSON(w, http.StatusOK, map[string]any{"stat": "FAIL", "message": "Invalid session."})
+		return
+	}
+
+	tx.duoPolls++
+	response := map[string]any{"status_code": "pushed", "status": "Pushed a login request to your device..."}
+	if tx.duoPolls > pushPolls {
+		switch factor.result() {
+		case FactorResultSuccess:
+			response = map[string]any{"status_code": "allow", "status": "Success. Logging you in...", "result": "SUCCESS", "result_url": "/frame/status/" + tx.duoTxID}
+		case FactorResultRejected:
+			response = map[string]any{"status_code": "deny", "status": "Login request denied.", "result": "FAILURE"}
+		default:
+			response = map[string]any{"status_code": "timeout", "status": "Login timed out.", "result": "FAILURE"}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"stat": "OK", "response": response})
+}
+
+// serveDuoResult returns the signed response of Duo for an approved push notification, which is posted back to Okta.
+func (s *Server) serveDuoResult(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, factor, ok := s.duoTransaction(r.PostFormValue("sid"))
+	if !ok || r.PathValue("txid") != tx.duoTxID || tx.duoPolls <= pushPolls || factor.result() != FactorResultSuccess {
+		writeJSON(w, http.StatusOK, map[string]any{"stat": "FAIL", "message": "Invalid session."})
+		return
+	}
+
+	tx.duoCookie = "AUTH|" + randomString()
+	writeJSON(w, http.StatusOK, map[string]any{"stat": "OK", "response": map[string]any{"cookie": tx.duoCookie, "parent": s.duoCallbackURL(tx.factorID)}})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, summary string) {
+	writeJSON(w, status, map[string]any{"errorCode": code, "errorSummary": summary, "errorCauses": []any{}})
+}
@@ -0,0 +1,119 @@
+package oktatest
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// clientAssertionType is the client_assertion_type of a private_key_jwt client assertion, as described in RFC 7523.
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionLifetime is the longest Okta accepts a client assertion to be valid for.
+const maxAssertionLifetime = time.Hour
+
+// ServiceApp is an API service application, which authenticates with the client credentials grant and a private_key_jwt client assertion.
+//
+// Tokens issued to a service app do not act on behalf of a user, so they carry no ID token and cannot be exchanged for web SSO tokens.
+type ServiceApp struct {
+	ClientID string
+	// Key is the public key client assertions must be signed with.
+	Key jose.JSONWebKey
+	// Scopes are the scopes the app has been granted.
+	Scopes []string
+}
+
+// AddServiceApp registers app so that it can request tokens with the client credentials grant.
+func (s *Server) AddServiceApp(app ServiceApp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serviceApps[app.ClientID] = app
+}
+
+// exchangeClientCredentials implements the client credentials grant for service apps, which authenticate with a private_key_jwt client assertion.
+func (s *Server) exchangeClientCredentials(w http.ResponseWriter, form url.Values) {
+	invalidClient := func(description string) {
+		writeJSON(w, http.StatusUnauthorized, oauthError{"invalid_client", description})
+	}
+
+	if form.Get("client_assertion_type") != clientAssertionType {
+		invalidClient("Client authentication failed. Either the client or the client credentials are invalid.")
+		return
+	}
+
+	assertion, err := jwt.ParseSigned(form.Get("client_assertion"))
+	if err != nil {
+		invalidClient("The client_assertion is not a valid JWT.")
+		return
+	}
+
+	var unverified jwt.Claims
+	if err := assertion.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		invalidClient("The client_assertion is not a valid JWT.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.serviceApps[unverified.Issuer]
+	if !ok || (form.Get("client_id") != "" && form.Get("client_id") != app.ClientID) {
+		invalidClient("Client authentication failed. Either the client or the client credentials are invalid.")
+		return
+	}
+
+	var claims jwt.Claims
+	if err := assertion.Claims(app.Key, &claims); err != nil {
+		invalidClient("The client_assertion signature is invalid.")
+		return
+	}
+
+	now := time.Now()
+	expected := jwt.Expected{Issuer: app.ClientID, Subject: app.ClientID, Audience: jwt.Audience{s.URL + "/oauth2/v1/token"}, Time: now}
+	if err := claims.ValidateWithLeeway(expected, 0); err != nil || claims.Expiry == nil || claims.Expiry.Time().Sub(now) > maxAssertionLifetime {
+		invalidClient("The client_assertion token is invalid or has expired.")
+		return
+	}
+
+	if claims.ID == "" || s.assertions[claims.ID] {
+		invalidClient("The client_assertion token has already been used.")
+		return
+	}
+	s.assertions[claims.ID] = true
+
+	scopes := strings.Fields(form.Get("scope"))
+	if len(scopes) == 0 {
+		writeOAuthError(w, "invalid_scope", "The authorization server resource does not have any configured default scopes, 'scope' must be provided.")
+		return
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(app.Scopes, scope) {
+			writeOAuthError(w, "invalid_scope", "One or more scopes are not configured for the authorization server resource.")
+			return
+		}
+	}
+
+	accessToken := s.sign(map[string]any{
+		"iss": s.URL,
+		"aud": s.URL,
+		"sub": app.ClientID,
+		"cid": app.ClientID,
+		"scp": scopes,
+		"iat": now.Unix(),
+		"exp": now.Add(tokenLifetime).Unix(),
+		"jti": randomString(),
+	})
+	s.tokens[accessToken] = grant{tokenType: tokenTypeAccessToken, expiry: now.Add(tokenLifetime)}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenLifetime.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
@@ -0,0 +1,76 @@
+package oktatest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// signAssertion returns a client assertion for clientID signed with key.
+func signAssertion(t *testing.T, key *ecdsa.PrivateKey, clientID, audience, id string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assertion, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{audience},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		ID:       id,
+	}).CompactSerialize()
+	require.NoError(t, err)
+	return assertion
+}
+
+func TestServer_ClientCredentials(t *testing.T) {
+	srv, ctx := newTestServer(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	srv.AddServiceApp(ServiceApp{ClientID: "0oaService", Key: jose.JSONWebKey{Key: key.Public()}, Scopes: []string{"okta.apps.read"}})
+
+	tokenURL := srv.URL + "/oauth2/v1/token"
+	token := func(assertion string, scopes ...string) (*oauth2.Token, error) {
+		cfg := clientcredentials.Config{
+			ClientID:       "0oaService",
+			TokenURL:       tokenURL,
+			Scopes:         scopes,
+			EndpointParams: url.Values{"client_assertion_type": {clientAssertionType}, "client_assertion": {assertion}},
+			AuthStyle:      oauth2.AuthStyleInParams,
+		}
+		return cfg.Token(ctx)
+	}
+
+	tok, err := token(signAssertion(t, key, "0oaService", tokenURL, "1"), "okta.apps.read")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tok.AccessToken)
+	// Service apps do not act on behalf of a user, so no ID token is issued.
+	assert.Nil(t, tok.Extra("id_token"))
+
+	_, err = token(signAssertion(t, key, "0oaService", tokenURL, "1"), "okta.apps.read")
+	assert.ErrorContains(t, err, "already been used")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = token(signAssertion(t, otherKey, "0oaService", tokenURL, "2"), "okta.apps.read")
+	assert.ErrorContains(t, err, "signature is invalid")
+
+	_, err = token(signAssertion(t, key, "0oaService", srv.URL, "3"), "okta.apps.read")
+	assert.ErrorContains(t, err, "invalid or has expired")
+
+	_, err = token(signAssertion(t, key, "0oaService", tokenURL, "4"), "okta.users.manage")
+	var retrieveErr *oauth2.RetrieveError
+	require.ErrorAs(t, err, &retrieveErr)
+	assert.Equal(t, "invalid_scope", retrieveErr.ErrorCode)
+}
@@ -0,0 +1,169 @@
+package oktatest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	xmlDSigNS       = "http://www.w3.org/2000/09/xmldsig#"
+	excC14N         = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	// awsSignInURL is where AWS expects SAML responses to be posted.
+	awsSignInURL = "https://signin.aws.amazon.com/saml"
+	awsRoleAttr  = "https://aws.amazon.com/SAML/Attributes/Role"
+	awsNameAttr  = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+	attrNameURI  = "urn:oasis:names:tc:SAML:2.0:attrname-format:uri"
+)
+
+// samlFormTemplate is the page Okta serves to post a SAML response to AWS.
+var samlFormTemplate = template.Must(template.New("saml").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form id="appForm" action="` + awsSignInURL + `" method="POST">
+<input name="SAMLResponse" type="hidden" value="{{.}}"/>
+<input name="RelayState" type="hidden" value=""/>
+</form>
+</body>
+</html>
+`))
+
+// samlResponse returns a SAML response for user to sign in to app, containing an assertion with an enveloped signature.
+//
+// The assertion is written in exclusive canonical form, so the digest is computed over the bytes as they appear in the response, and the response can be verified with the certificate of the server.
+func (s *Server) samlResponse(user User, app Application, now time.Time) ([]byte, error) {
+	assertionID := "id" + randomString()
+	issueInstant := now.UTC().Format(time.RFC3339)
+	notOnOrAfter := now.Add(webSSOLifetime).UTC().Format(time.RFC3339)
+
+	// Attributes must be given in canonical order: namespace declarations first, then unqualified attributes sorted by name.
+	var issuer, rest xmlWriter
+	issuer.element("saml2:Issuer", s.URL)
+
+	rest.start("saml2:Subject")
+	rest.element("saml2:NameID", user.Login, "Format", "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified")
+	rest.start("saml2:SubjectConfirmation", "Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+	rest.element("saml2:SubjectConfirmationData", "", "NotOnOrAfter", notOnOrAfter, "Recipient", awsSignInURL)
+	rest.end("saml2:SubjectConfirmation")
+	rest.end("saml2:Subject")
+
+	rest.start("saml2:Conditions", "NotBefore", issueInstant, "NotOnOrAfter", notOnOrAfter)
+	rest.start("saml2:AudienceRestriction")
+	rest.element("saml2:Audience", "urn:amazon:webservices")
+	rest.end("saml2:AudienceRestriction")
+	rest.end("saml2:Conditions")
+
+	rest.start("saml2:AuthnStatement", "AuthnInstant", issueInstant, "SessionIndex", assertionID)
+	rest.start("saml2:AuthnContext")
+	rest.element("saml2:AuthnContextClassRef", "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport")
+	rest.end("saml2:AuthnContext")
+	rest.end("saml2:AuthnStatement")
+
+	rest.start("saml2:AttributeStatement")
+	rest.start("saml2:Attribute", "Name", awsRoleAttr, "NameFormat", attrNameURI)
+	for _, roleARN := range app.RoleARNs {
+		rest.element("saml2:AttributeValue", app.ProviderARN+","+roleARN)
+	}
+	rest.end("saml2:Attribute")
+	rest.start("saml2:Attribute", "Name", awsNameAttr, "NameFormat", attrNameURI)
+	rest.element("saml2:AttributeValue", user.Login)
+	rest.end("saml2:Attribute")
+	rest.end("saml2:AttributeStatement")
+
+	var open xmlWriter
+	open.start("saml2:Assertion", "xmlns:saml2", samlAssertionNS, "ID", assertionID, "IssueInstant", issueInstant, "Version", "2.0")
+	const closeAssertion = "</saml2:Assertion>"
+
+	// The enveloped signature transform removes the signature before the digest is computed.
+	digest := sha256.Sum256([]byte(open.String() + issuer.String() + rest.String() + closeAssertion))
+	signature, err := s.signature(assertionID, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var resp xmlWriter
+	resp.start("saml2p:Response", "xmlns:saml2p", samlProtocolNS, "Destination", awsSignInURL, "ID", "id"+randomString(), "IssueInstant", issueInstant, "Version", "2.0")
+	resp.element("saml2:Issuer", s.URL, "xmlns:saml2", samlAssertionNS)
+	resp.start("saml2p:Status")
+	resp.element("saml2p:StatusCode", "", "Value", "urn:oasis:names:tc:SAML:2.0:status:Success")
+	resp.end("saml2p:Status")
+	resp.WriteString(open.String() + issuer.String() + signature + rest.String() + closeAssertion)
+	resp.end("saml2p:Response")
+	return []byte(resp.String()), nil
+}
+
+// signature returns an XML signature of the element with the given ID, whose canonical form has the given SHA-256 digest.
+func (s *Server) signature(id string, digest []byte) (string, error) {
+	var signedInfo xmlWriter
+	signedInfo.start("ds:CanonicalizationMethod", "Algorithm", excC14N)
+	signedInfo.end("ds:CanonicalizationMethod")
+	signedInfo.start("ds:SignatureMethod", "Algorithm", "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256")
+	signedInfo.end("ds:SignatureMethod")
+	signedInfo.start("ds:Reference", "URI", "#"+id)
+	signedInfo.start("ds:Transforms")
+	signedInfo.element("ds:Transform", "", "Algorithm", "http://www.w3.org/2000/09/xmldsig#enveloped-signature")
+	signedInfo.element("ds:Transform", "", "Algorithm", excC14N)
+	signedInfo.end("ds:Transforms")
+	signedInfo.element("ds:DigestMethod", "", "Algorithm", "http://www.w3.org/2001/04/xmlenc#sha256")
+	signedInfo.element("ds:DigestValue", base64.StdEncoding.EncodeToString(digest))
+	signedInfo.end("ds:Reference")
+
+	// When canonicalized on its own, SignedInfo declares the namespace it inherits from Signature.
+	signed := sha256.Sum256([]byte(`<ds:SignedInfo xmlns:ds="` + xmlDSigNS + `">` + signedInfo.String() + `</ds:SignedInfo>`))
+	value, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, signed[:])
+	if err != nil {
+		return "", err
+	}
+
+	var sig xmlWriter
+	sig.start("ds:Signature", "xmlns:ds", xmlDSigNS)
+	sig.start("ds:SignedInfo")
+	sig.WriteString(signedInfo.String())
+	sig.end("ds:SignedInfo")
+	sig.element("ds:SignatureValue", base64.StdEncoding.EncodeToString(value))
+	sig.start("ds:KeyInfo")
+	sig.start("ds:X509Data")
+	sig.element("ds:X509Certificate", base64.StdEncoding.EncodeToString(s.cert.Raw))
+	sig.end("ds:X509Data")
+	sig.end("ds:KeyInfo")
+	sig.end("ds:Signature")
+	return sig.String(), nil
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// xmlWriter writes XML in canonical form. Callers are responsible for giving attributes in canonical order.
+type xmlWriter struct {
+	strings.Builder
+}
+
+// start writes a start tag. attrs are pairs of names and values.
+func (w *xmlWriter) start(name string, attrs ...string) {
+	w.WriteString("<" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		w.WriteString(" " + attrs[i] + `="` + attrEscaper.Replace(attrs[i+1]) + `"`)
+	}
+	w.WriteString(">")
+}
+
+func (w *xmlWriter) end(name string) {
+	w.WriteString("</" + name + ">")
+}
+
+// element writes an element containing only text. Empty elements are written with an end tag, as canonical XML does not permit empty-element tags.
+func (w *xmlWriter) element(name, text string, attrs ...string) {
+	w.start(name, attrs...)
+	w.WriteString(textEscaper.Replace(text))
+	w.end(name)
+}
@@ -0,0 +1,621 @@
+// Package oktatest provides a local stand-in for the parts of Okta used by KeyConjurer, so that the login and credential flows can be tested end to end without a real Okta organization.
+package oktatest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// Grant types and token types used by the Okta web SSO token exchange.
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken           = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeWebSSO            = "urn:okta:oauth:token-type:web_sso_token"
+)
+
+const (
+	keyID          = "oktatest"
+	tokenLifetime  = time.Hour
+	webSSOLifetime = 5 * time.Minute
+)
+
+// Application is an AWS application in the Okta organization.
+type Application struct {
+	ID    string
+	Label string
+	// ProviderARN is the ARN of the SAML provider for Okta in the AWS account.
+	ProviderARN string
+	// RoleARNs are the roles the users of the application may assume.
+	RoleARNs []string
+}
+
+// User is a user of the Okta organization.
+type User struct {
+	ID     string
+	Login  string
+	Groups []string
+	// Applications are the applications assigned to the user.
+	Applications []Application
+	// Password is the password the user signs in to the authentication API with. Users without a password cannot use the authentication API.
+	Password string
+	// Factors are the MFA factors the user has enrolled, which the authentication API requires one of after the password.
+	Factors []Factor
+}
+
+// Server is an Okta organization served over TLS by an httptest.Server.
+//
+// The org authorization server is used as the OIDC issuer, so URL is both the Okta domain and the issuer.
+type Server struct {
+	// URL is the base URL of the server, of the form https://ipaddr:port with no trailing slash.
+	URL string
+	// ClientID is the ID of the OIDC application that tokens may be issued to.
+	ClientID string
+	// APIToken is the SSWS token accepted by the management API.
+	APIToken string
+
+	srv  *httptest.Server
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+
+	mu       sync.Mutex
+	users    []User
+	signedIn string
+	codes    map[string]authorization
+	tokens   map[string]grant
+	// serviceApps are keyed by client ID.
+	serviceApps map[string]ServiceApp
+	// assertions records the IDs of the client assertions that have been used, so they cannot be replayed.
+	assertions map[string]bool
+	// transactions are the authentication transactions in progress, keyed by state token.
+	transactions map[string]*transaction
+	// sessionTokens are the one-time session tokens issued by the authentication API, mapped to the ID of their user.
+	sessionTokens map[string]string
+	// duoSessions map the IDs of Duo prompt sessions to the state token of their transaction.
+	duoSessions map[string]string
+}
+
+// authorization is an authorization code waiting to be exchanged.
+type authorization struct {
+	userID        string
+	redirectURI   string
+	codeChallenge string
+	scopes        []string
+	nonce         string
+}
+
+// grant records who a token was issued to.
+type grant struct {
+	// userID is empty for tokens issued to service apps, which do not act on behalf of a user.
+	userID    string
+	tokenType string
+	// applicationID is the application a web SSO token may be used with.
+	applicationID string
+	expiry        time.Time
+}
+
+// NewServer starts a Server with the given users, issuing tokens to clientID.
+//
+// The browser is signed in as the first user; see SignIn. The caller should call Close when finished, to shut it down.
+func NewServer(clientID string, users ...User) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: generate key: %v", err))
+	}
+
+	s := &Server{
+		ClientID:    clientID,
+		APIToken:    randomString(),
+		key:         key,
+		users:       users,
+		codes:       make(map[string]authorization),
+		tokens:      make(map[string]grant),
+		serviceApps: make(map[string]ServiceApp),
+		assertions:  make(map[string]bool),
+
+		transactions:  make(map[string]*transaction),
+		sessionTokens: make(map[string]string),
+		duoSessions:   make(map[string]string),
+	}
+
+	if len(users) > 0 {
+		s.signedIn = users[0].ID
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.serveDiscovery)
+	mux.HandleFunc("GET /oauth2/v1/keys", s.serveKeys)
+	mux.HandleFunc("GET /oauth2/v1/authorize", s.serveAuthorize)
+	mux.HandleFunc("POST /oauth2/v1/token", s.serveToken)
+	mux.HandleFunc("GET /oauth2/v1/userinfo", s.serveUserInfo)
+	mux.HandleFunc("GET /login/token/sso", s.serveWebSSO)
+	mux.HandleFunc("GET /api/v1/users/{id}/appLinks", s.serveAppLinks)
+	mux.HandleFunc("POST /api/v1/authn", s.serveAuthn)
+	mux.HandleFunc("POST /api/v1/authn/factors/{id}/verify", s.serveVerifyFactor)
+	mux.HandleFunc("POST /api/v1/authn/factors/{id}/lifecycle/duoCallback", s.serveDuoCallback)
+	mux.HandleFunc("POST /frame/web/v1/auth", s.serveDuoAuth)
+	mux.HandleFunc("POST /frame/prompt", s.serveDuoPrompt)
+	mux.HandleFunc("POST /frame/status", s.serveDuoStatus)
+	mux.HandleFunc("POST /frame/status/{txid}", s.serveDuoResult)
+
+	s.srv = httptest.NewTLSServer(mux)
+	s.URL = s.srv.URL
+	s.cert = s.newCertificate()
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an HTTP client which trusts the certificate of the server.
+//
+// Pass it to OIDC and OAuth2 libraries through the oauth2.HTTPClient context key.
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// Certificate returns the certificate SAML assertions are signed with.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// SignIn changes the user the browser is signed in as. An empty login signs the browser out, so authorization requests fail with login_required.
+func (s *Server) SignIn(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if login == "" {
+		s.signedIn = ""
+		return nil
+	}
+
+	user, ok := s.findUser(login)
+	if !ok {
+		return fmt.Errorf("oktatest: no user with login %q", login)
+	}
+
+	s.signedIn = user.ID
+	return nil
+}
+
+// Token issues tokens to the user with the given login as if they had completed the authorization code flow. The ID token is in the id_token extra field, as it is for tokens from the token endpoint.
+func (s *Server) Token(login string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.findUser(login)
+	if !ok {
+		return nil, fmt.Errorf("oktatest: no user with login %q", login)
+	}
+
+	accessToken, idToken := s.issueTokens(user, []string{"openid", "profile", "okta.apps.read", "okta.apps.sso"}, "")
+	tok := &oauth2.Token{AccessToken: accessToken, TokenType: "Bearer", Expiry: time.Now().Add(tokenLifetime)}
+	return tok.WithExtra(map[string]any{"id_token": idToken}), nil
+}
+
+// Browse acts as the browser of the signed in user, following the redirects from uri to the redirect URI of the client.
+//
+// Like a real browser, it returns without waiting for the page to load, as the login flow only serves the redirect URI once the browser has been opened.
+func (s *Server) Browse(uri string) error {
+	go func() {
+		resp, err := s.Client().Get(uri)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	return nil
+}
+
+// findUser returns the user with the given ID or login. The lock must be held.
+func (s *Server) findUser(idOrLogin string) (User, bool) {
+	for _, user := range s.users {
+		if user.ID == idOrLogin || strings.EqualFold(user.Login, idOrLogin) {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/oauth2/v1/authorize",
+		"token_endpoint":                        s.URL + "/oauth2/v1/token",
+		"userinfo_endpoint":                     s.URL + "/oauth2/v1/userinfo",
+		"jwks_uri":                              s.URL + "/oauth2/v1/keys",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{grantTypeAuthorizationCode, grantTypeClientCredentials, grantTypeTokenExchange},
+		"token_endpoint_auth_methods_supported": []string{"none", "private_key_jwt"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) serveKeys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &s.key.PublicKey, KeyID: keyID, Algorithm: "RS256", Use: "sig"},
+	}})
+}
+
+// serveAuthorize implements the authorization endpoint for the authorization code flow. PKCE is required, as it is for public clients in Okta.
+//
+// The user is the one the browser is signed in as, unless a session token from the authentication API is given.
+func (s *Server) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID {
+		http.Error(w, "The client_id is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "The redirect_uri is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	redirect := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		redirectURI.RawQuery = params.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+
+	redirectError := func(code, description string) {
+		redirect(url.Values{"error": {code}, "error_description": {description}})
+	}
+
+	scopes := strings.Fields(q.Get("scope"))
+	switch {
+	case q.Get("response_type") != "code":
+		redirectError("unsupported_response_type", "The response type is not supported by the authorization server.")
+		return
+	case !slices.Contains(scopes, "openid"):
+		redirectError("invalid_scope", "The authentication request has an invalid 'scope' parameter.")
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		redirectError("invalid_request", "PKCE code challenge is required when the token endpoint authentication method is 'NONE'.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID := s.signedIn
+	if sessionToken := q.Get("sessionToken"); sessionToken != "" {
+		// Session tokens from the authentication API sign the user in, and may only be used once.
+		userID = s.sessionTokens[sessionToken]
+		delete(s.sessionTokens, sessionToken)
+	}
+
+	if userID == "" {
+		redirectError("login_required", "The client specified not to prompt, but the user is not logged in.")
+		return
+	}
+
+	code := randomString()
+	s.codes[code] = authorization{
+		userID:        userID,
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		scopes:        scopes,
+		nonce:         q.Get("nonce"),
+	}
+
+	redirect(url.Values{"code": {code}})
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, "invalid_request", "The request body is invalid.")
+		return
+	}
+
+	// Service apps authenticate with a client assertion rather than their client ID alone.
+	if r.PostForm.Get("grant_type") == grantTypeClientCredentials {
+		s.exchangeClientCredentials(w, r.PostForm)
+		return
+	}
+
+	// Public clients may send their ID either as a parameter or through basic authentication with an empty secret.
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID = user
+	}
+
+	if clientID != s.ClientID {
+		writeJSON(w, http.StatusUnauthorized, oauthError{"invalid_client", "Client authentication failed."})
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case grantTypeAuthorizationCode:
+		s.exchangeAuthorizationCode(w, r.PostForm)
+	case grantTypeTokenExchange:
+		s.exchangeForWebSSOToken(w, r.PostForm)
+	default:
+		writeOAuthError(w, "unsupported_grant_type", "The grant type is not supported by the authorization server.")
+	}
+}
+
+func (s *Server) exchangeAuthorizationCode(w http.ResponseWriter, form url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := form.Get("code")
+	auth, ok := s.codes[code]
+	if !ok {
+		writeOAuthError(w, "invalid_grant", "The authorization code is invalid or has expired.")
+		return
+	}
+	// Codes may only be used once.
+	delete(s.codes, code)
+
+	if form.Get("redirect_uri") != auth.redirectURI {
+		writeOAuthError(w, "invalid_grant", "The redirect_uri does not match the redirect_uri used in the authorization request.")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge {
+		writeOAuthError(w, "invalid_grant", "PKCE verification failed.")
+		return
+	}
+
+	user, _ := s.findUser(auth.userID)
+	accessToken, idToken := s.issueTokens(user, auth.scopes, auth.nonce)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenLifetime.Seconds()),
+		"scope":        strings.Join(auth.scopes, " "),
+		"id_token":     idToken,
+	})
+}
+
+// issueTokens returns an access token and ID token for user, recording them so they can be used with the server. The lock must be held.
+func (s *Server) issueTokens(user User, scopes []string, nonce string) (accessToken, idToken string) {
+	now := time.Now()
+	accessToken = s.sign(map[string]any{
+		"iss": s.URL,
+		"aud": s.URL,
+		"sub": user.Login,
+		"uid": user.ID,
+		"cid": s.ClientID,
+		"scp": scopes,
+		"iat": now.Unix(),
+		"exp": now.Add(tokenLifetime).Unix(),
+		"jti": randomString(),
+	})
+	idToken = s.sign(map[string]any{
+		"iss":                s.URL,
+		"aud":                s.ClientID,
+		"sub":                user.ID,
+		"preferred_username": user.Login,
+		"groups":             user.Groups,
+		"nonce":              nonce,
+		"iat":                now.Unix(),
+		"exp":                now.Add(tokenLifetime).Unix(),
+	})
+
+	s.tokens[accessToken] = grant{userID: user.ID, tokenType: tokenTypeAccessToken, expiry: now.Add(tokenLifetime)}
+	s.tokens[idToken] = grant{userID: user.ID, tokenType: tokenTypeIDToken, expiry: now.Add(tokenLifetime)}
+	return accessToken, idToken
+}
+
+// exchangeForWebSSOToken implements the RFC 8693 token exchange Okta uses to issue web SSO tokens for an application.
+//
+// The actor token is the access token of the user and the subject token is their ID token; both must belong to the same user, who must be assigned the application.
+func (s *Server) exchangeForWebSSOToken(w http.ResponseWriter, form url.Values) {
+	if form.Get("requested_token_type") != tokenTypeWebSSO {
+		writeOAuthError(w, "invalid_request", "The requested_token_type is not supported.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	actor, ok := s.validGrant(form.Get("actor_token"), form.Get("actor_token_type"))
+	if !ok {
+		writeOAuthError(w, "invalid_grant", "The actor_token is invalid or has expired.")
+		return
+	}
+
+	subject, ok := s.validGrant(form.Get("subject_token"), form.Get("subject_token_type"))
+	if !ok || subject.userID != actor.userID {
+		writeOAuthError(w, "invalid_grant", "The subject_token is invalid or has expired.")
+		return
+	}
+
+	applicationID, ok := strings.CutPrefix(form.Get("audience"), "urn:okta:apps:")
+	user, _ := s.findUser(actor.userID)
+	if !ok || !slices.ContainsFunc(user.Applications, func(app Application) bool { return app.ID == applicationID }) {
+		writeOAuthError(w, "invalid_target", "The audience is invalid, or the user is not assigned to the application.")
+		return
+	}
+
+	token := randomString()
+	s.tokens[token] = grant{userID: user.ID, tokenType: tokenTypeWebSSO, applicationID: applicationID, expiry: time.Now().Add(webSSOLifetime)}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":      token,
+		"issued_token_type": tokenTypeWebSSO,
+		"token_type":        "N_A",
+		"expires_in":        int(webSSOLifetime.Seconds()),
+	})
+}
+
+// validGrant returns the grant of token if it was issued as tokenType and has not expired. The lock must be held.
+func (s *Server) validGrant(token, tokenType string) (grant, bool) {
+	g, ok := s.tokens[token]
+	if !ok || g.tokenType != tokenType || time.Now().After(g.expiry) {
+		return grant{}, false
+	}
+
+	return g, true
+}
+
+func (s *Server) serveUserInfo(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.validGrant(token, tokenTypeAccessToken)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="The access token is invalid."`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if g.userID == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", error_description="The access token must provide access to at least one of these scopes - openid"`)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	user, _ := s.findUser(g.userID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":                user.ID,
+		"preferred_username": user.Login,
+		"groups":             user.Groups,
+	})
+}
+
+// serveWebSSO serves the page Okta uses to post a SAML assertion to AWS, given a web SSO token.
+func (s *Server) serveWebSSO(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	g, ok := s.validGrant(r.URL.Query().Get("token"), tokenTypeWebSSO)
+	if ok {
+		// Web SSO tokens may only be used once.
+		delete(s.tokens, r.URL.Query().Get("token"))
+	}
+	user, _ := s.findUser(g.userID)
+	s.mu.Unlock()
+
+	if !ok {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<html><body><p>The token is invalid or has expired.</p></body></html>")
+		return
+	}
+
+	i := slices.IndexFunc(user.Applications, func(app Application) bool { return app.ID == g.applicationID })
+	assertion, err := s.samlResponse(user, user.Applications[i], time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	samlFormTemplate.Execute(w, base64.StdEncoding.EncodeToString(assertion))
+}
+
+// serveAppLinks implements the management API listing the applications assigned to a user, identified by their ID or login.
+func (s *Server) serveAppLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "SSWS "+s.APIToken {
+		writeAPIError(w, http.StatusUnauthorized, "E0000011", "Invalid token provided")
+		return
+	}
+
+	s.mu.Lock()
+	user, ok := s.findUser(r.PathValue("id"))
+	s.mu.Unlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+r.PathValue("id")+" (User)")
+		return
+	}
+
+	links := make([]map[string]any, len(user.Applications))
+	for i, app := range user.Applications {
+		links[i] = map[string]any{
+			"id":               "0ua" + app.ID,
+			"appInstanceId":    app.ID,
+			"appName":          "amazon_aws",
+			"appAssignmentId":  "0ua" + app.ID,
+			"label":            app.Label,
+			"linkUrl":          fmt.Sprintf("%s/home/amazon_aws/%s/272", s.URL, app.ID),
+			"logoUrl":          s.URL + "/assets/img/logos/amazon-aws.png",
+			"credentialsSetup": false,
+			"hidden":           false,
+			"sortOrder":        i,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, links)
+}
+
+// sign returns claims as a JWT signed by the key of the server.
+func (s *Server) sign(claims map[string]any) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: s.key}, opts)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: create signer: %v", err))
+	}
+
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: sign token: %v", err))
+	}
+
+	token, _ := jws.CompactSerialize()
+	return token
+}
+
+// newCertificate returns a self-signed certificate for the key of the server.
+func (s *Server) newCertificate() *x509.Certificate {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: s.URL},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &s.key.PublicKey, s.key)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: create certificate: %v", err))
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func writeOAuthError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, oauthError{code, description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,127 @@
+package oktatest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var testUser = User{
+	ID:     "00u1",
+	Login:  "user@example.com",
+	Groups: []string{"Everyone"},
+	Applications: []Application{{
+		ID:          "0oa1",
+		Label:       "AWS - Production",
+		ProviderARN: "arn:aws:iam::123456789012:saml-provider/Okta",
+		RoleARNs:    []string{"arn:aws:iam::123456789012:role/Admin"},
+	}},
+}
+
+func newTestServer(t *testing.T) (*Server, context.Context) {
+	srv := NewServer("0oaKeyConjurer", testUser)
+	t.Cleanup(srv.Close)
+	return srv, context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+}
+
+// authorize runs the authorization code flow against srv, returning the code and state it redirected with.
+func authorize(t *testing.T, srv *Server, cfg *oauth2.Config, opts ...oauth2.AuthCodeOption) url.Values {
+	client := *srv.Client()
+	var callback url.Values
+	client.CheckRedirect = func(req *http.Request, _ []*http.Request) error {
+		callback = req.URL.Query()
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(cfg.AuthCodeURL("state", opts...))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	return callback
+}
+
+func TestServer_AuthorizationCodeWithPKCE(t *testing.T) {
+	srv, ctx := newTestServer(t)
+	provider, err := oidc.NewProvider(ctx, srv.URL)
+	require.NoError(t, err)
+
+	cfg := &oauth2.Config{ClientID: srv.ClientID, Endpoint: provider.Endpoint(), Scopes: []string{oidc.ScopeOpenID}, RedirectURL: "http://localhost:57468"}
+	// Otherwise the failed exchange below would be retried with the client ID in the body, with an already used code.
+	cfg.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	verifier := oauth2.GenerateVerifier()
+	callback := authorize(t, srv, cfg, oauth2.S256ChallengeOption(verifier))
+	assert.Equal(t, "state", callback.Get("state"))
+
+	_, err = cfg.Exchange(ctx, callback.Get("code"), oauth2.VerifierOption("not the verifier"))
+	assert.ErrorContains(t, err, "PKCE verification failed")
+
+	// Codes are single use, so a failed exchange cannot be retried.
+	_, err = cfg.Exchange(ctx, callback.Get("code"), oauth2.VerifierOption(verifier))
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	callback = authorize(t, srv, cfg, oauth2.S256ChallengeOption(verifier))
+	tok, err := cfg.Exchange(ctx, callback.Get("code"), oauth2.VerifierOption(verifier))
+	require.NoError(t, err)
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: srv.ClientID}).Verify(ctx, tok.Extra("id_token").(string))
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", idToken.Subject)
+
+	info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(tok))
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", info.Subject)
+}
+
+func TestServer_AuthorizeErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cfg := &oauth2.Config{
+		ClientID:    srv.ClientID,
+		Endpoint:    oauth2.Endpoint{AuthURL: srv.URL + "/oauth2/v1/authorize"},
+		Scopes:      []string{oidc.ScopeOpenID},
+		RedirectURL: "http://localhost:57468",
+	}
+
+	callback := authorize(t, srv, cfg)
+	assert.Equal(t, "invalid_request", callback.Get("error"))
+
+	require.NoError(t, srv.SignIn(""))
+	callback = authorize(t, srv, cfg, oauth2.S256ChallengeOption(oauth2.GenerateVerifier()))
+	assert.Equal(t, "login_required", callback.Get("error"))
+	assert.Equal(t, "state", callback.Get("state"))
+
+	assert.Error(t, srv.SignIn("nobody@example.com"))
+}
+
+func TestServer_SAMLResponseIsSigned(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp, err := srv.samlResponse(testUser, testUser.Applications[0], srv.cert.NotBefore)
+	require.NoError(t, err)
+	doc := string(resp)
+
+	assert.Contains(t, doc, "<saml2:AttributeValue>arn:aws:iam::123456789012:saml-provider/Okta,arn:aws:iam::123456789012:role/Admin</saml2:AttributeValue>")
+
+	// Verify the signature as a relying party would, using the certificate embedded in the response.
+	signature := regexp.MustCompile(`<ds:Signature .*</ds:Signature>`).FindString(doc)
+	require.NotEmpty(t, signature)
+	assertion := regexp.MustCompile(`<saml2:Assertion .*</saml2:Assertion>`).FindString(strings.Replace(doc, signature, "", 1))
+	digest := sha256.Sum256([]byte(assertion))
+	assert.Contains(t, signature, "<ds:DigestValue>"+base64.StdEncoding.EncodeToString(digest[:])+"</ds:DigestValue>")
+
+	signedInfo := regexp.MustCompile(`<ds:SignedInfo>(.*)</ds:SignedInfo>`).FindStringSubmatch(signature)[1]
+	signed := sha256.Sum256([]byte(`<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` + signedInfo + `</ds:SignedInfo>`))
+	value, err := base64.StdEncoding.DecodeString(regexp.MustCompile(`<ds:SignatureValue>(.*)</ds:SignatureValue>`).FindStringSubmatch(signature)[1])
+	require.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(srv.Certificate().PublicKey.(*rsa.PublicKey), crypto.SHA256, signed[:], value))
+}
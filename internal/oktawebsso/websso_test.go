@@ -0,0 +1,73 @@
+package oktawebsso
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/RobotsAndPencils/go-saml"
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var testUser = oktatest.User{
+	ID:    "00u1",
+	Login: "user@example.com",
+	Applications: []oktatest.Application{{
+		ID:          "0oa1",
+		Label:       "AWS - Production",
+		ProviderARN: "arn:aws:iam::123456789012:saml-provider/Okta",
+		RoleARNs:    []string{"arn:aws:iam::123456789012:role/Admin", "arn:aws:iam::123456789012:role/ReadOnly"},
+	}},
+}
+
+func newTestServer(t *testing.T) (*oktatest.Server, *oauth2.Config, context.Context) {
+	srv := oktatest.NewServer("0oaKeyConjurer", testUser)
+	t.Cleanup(srv.Close)
+	cfg := &oauth2.Config{
+		ClientID: srv.ClientID,
+		Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/oauth2/v1/token", AuthStyle: oauth2.AuthStyleInParams},
+	}
+	return srv, cfg, context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+}
+
+func TestExchangeAccessTokenAndGetSAMLAssertion(t *testing.T) {
+	srv, cfg, ctx := newTestServer(t)
+	tok, err := srv.Token(testUser.Login)
+	require.NoError(t, err)
+
+	webSSOToken, err := ExchangeAccessToken(ctx, cfg, oauth2.StaticTokenSource(tok), "0oa1")
+	require.NoError(t, err)
+
+	assertion, err := GetSAMLAssertion(ctx, srv.URL, webSSOToken)
+	require.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(string(assertion))
+	require.NoError(t, err)
+	assert.Contains(t, string(decoded), "<ds:Signature ")
+
+	response, err := saml.ParseEncodedResponse(string(assertion))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"arn:aws:iam::123456789012:saml-provider/Okta,arn:aws:iam::123456789012:role/Admin",
+		"arn:aws:iam::123456789012:saml-provider/Okta,arn:aws:iam::123456789012:role/ReadOnly",
+	}, response.GetAttributeValues("https://aws.amazon.com/SAML/Attributes/Role"))
+
+	// Web SSO tokens may only be used once.
+	_, err = GetSAMLAssertion(ctx, srv.URL, webSSOToken)
+	assert.ErrorIs(t, err, ErrNoSAMLAssertion)
+}
+
+func TestExchangeAccessToken_Errors(t *testing.T) {
+	srv, cfg, ctx := newTestServer(t)
+	tok, err := srv.Token(testUser.Login)
+	require.NoError(t, err)
+
+	_, err = ExchangeAccessToken(ctx, cfg, oauth2.StaticTokenSource(tok), "0oaNotAssigned")
+	assert.ErrorContains(t, err, "invalid_target")
+
+	_, err = ExchangeAccessToken(ctx, cfg, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: tok.AccessToken}), "0oa1")
+	assert.ErrorIs(t, err, ErrNotOIDCToken)
+}
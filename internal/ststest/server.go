@@ -0,0 +1,419 @@
+// Package ststest provides a local stand-in for the AWS Security Token Service, so that the commands which exchange SAML assertions and assume roles can be tested without AWS.
+//
+// Requests are not checked for a valid signature; the access key ID of the signing credentials is enough to identify the caller.
+package ststest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobotsAndPencils/go-saml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	namespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+	roleAttr  = "https://aws.amazon.com/SAML/Attributes/Role"
+	nameAttr  = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+
+	// DefaultMaxSessionDuration is the maximum session duration of roles, unless changed with SetMaxSessionDuration.
+	DefaultMaxSessionDuration = time.Hour
+	// roleChainingLimit is the longest a session assumed with the credentials of another role session may last.
+	roleChainingLimit = time.Hour
+	defaultDuration   = time.Hour
+	minDuration       = 15 * time.Minute
+	maxDuration       = 12 * time.Hour
+)
+
+// Error is an error returned by the service.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// DurationSecondsError returns the validation error STS returns when the requested duration is longer than any role session may last.
+func DurationSecondsError(requested time.Duration) Error {
+	return validationError(fmt.Sprintf("1 validation error detected: Value '%d' at 'durationSeconds' failed to satisfy constraint: Member must have value less than or equal to %d", int(requested.Seconds()), int(maxDuration.Seconds())))
+}
+
+// MaxSessionDurationError is the validation error STS returns when the requested duration is longer than the maximum session duration of the role.
+var MaxSessionDurationError = validationError("The requested DurationSeconds exceeds the MaxSessionDuration set for this role.")
+
+// AccessDeniedError returns the error STS returns when the caller may not assume roleARN.
+func AccessDeniedError(callerARN, roleARN string) Error {
+	return Error{StatusCode: http.StatusForbidden, Code: "AccessDenied", Message: fmt.Sprintf("User: %s is not authorized to perform: sts:AssumeRole on resource: %s", callerARN, roleARN)}
+}
+
+func validationError(message string) Error {
+	return Error{StatusCode: http.StatusBadRequest, Code: "ValidationError", Message: message}
+}
+
+var invalidClientTokenError = Error{StatusCode: http.StatusForbidden, Code: "InvalidClientTokenId", Message: "The security token included in the request is invalid."}
+
+// Call is a request made to the server.
+type Call struct {
+	Action string
+	// Params holds the parameters of the request, such as RoleArn and DurationSeconds.
+	Params url.Values
+	// AccessKeyID is the access key ID of the credentials the request was signed with, if any.
+	AccessKeyID string
+}
+
+// session is a role session created by the server.
+type session struct {
+	roleARN     string
+	sessionName string
+	// chained indicates that the session was assumed using the credentials of another role session.
+	chained bool
+}
+
+// Server is an STS endpoint served by an httptest.Server.
+//
+// Any role may be assumed. SAML assertions are accepted if they list the requested role and principal, and are not otherwise validated.
+type Server struct {
+	// URL is the base URL of the server, of the form http://ipaddr:port with no trailing slash.
+	URL string
+
+	srv *httptest.Server
+
+	mu                 sync.Mutex
+	sessions           map[string]session
+	maxSessionDuration map[string]time.Duration
+	errs               map[string][]Error
+	calls              []Call
+}
+
+// NewServer starts a Server. The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		sessions:           make(map[string]session),
+		maxSessionDuration: make(map[string]time.Duration),
+		errs:               make(map[string][]Error),
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetMaxSessionDuration changes the maximum session duration of roleARN from DefaultMaxSessionDuration.
+func (s *Server) SetMaxSessionDuration(roleARN string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSessionDuration[roleARN] = d
+}
+
+// FailNext causes the next request for action, such as AssumeRoleWithSAML, to fail with err. Errors for the same action are returned in the order they were given.
+func (s *Server) FailNext(action string, err Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs[action] = append(s.errs[action], err)
+}
+
+// Calls returns the requests made to the server so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// Credentials returns credentials for a new session of roleARN, as if it had been assumed by a user outside of the server.
+func (s *Server) Credentials(roleARN, sessionName string) aws.Credentials {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds, _ := s.newSession(session{roleARN: roleARN, sessionName: sessionName}, defaultDuration)
+	return aws.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		CanExpire:       true,
+		Expires:         creds.Expiration,
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, Error{StatusCode: http.StatusBadRequest, Code: "MalformedQueryString", Message: err.Error()})
+		return
+	}
+
+	call := Call{Action: r.Form.Get("Action"), Params: r.Form, AccessKeyID: accessKeyID(r)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+
+	if errs := s.errs[call.Action]; len(errs) > 0 {
+		s.errs[call.Action] = errs[1:]
+		writeError(w, errs[0])
+		return
+	}
+
+	var (
+		result any
+		err    error
+	)
+
+	switch call.Action {
+	case "AssumeRoleWithSAML":
+		result, err = s.assumeRoleWithSAML(call.Params)
+	case "AssumeRole":
+		result, err = s.assumeRole(call)
+	case "GetCallerIdentity":
+		result, err = s.getCallerIdentity(call)
+	default:
+		err = Error{StatusCode: http.StatusBadRequest, Code: "InvalidAction", Message: fmt.Sprintf("Could not find operation %s for version 2011-06-15", call.Action)}
+	}
+
+	if err != nil {
+		writeError(w, err.(Error))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response{
+		XMLName:          xml.Name{Local: call.Action + "Response"},
+		Namespace:        namespace,
+		Result:           result,
+		ResponseMetadata: responseMetadata{RequestID: requestID()},
+	})
+}
+
+func (s *Server) assumeRoleWithSAML(params url.Values) (any, error) {
+	roleARN, principalARN := params.Get("RoleArn"), params.Get("PrincipalArn")
+	assertion, err := saml.ParseEncodedResponse(params.Get("SAMLAssertion"))
+	if err != nil {
+		return nil, Error{StatusCode: http.StatusBadRequest, Code: "InvalidIdentityToken", Message: "Invalid base64 SAMLResponse (check that it is base64-encoded)"}
+	}
+
+	if !slices.Contains(assertion.GetAttributeValues(roleAttr), principalARN+","+roleARN) {
+		return nil, Error{StatusCode: http.StatusForbidden, Code: "AccessDenied", Message: "Not authorized to perform sts:AssumeRoleWithSAML"}
+	}
+
+	names := assertion.GetAttributeValues(nameAttr)
+	if len(names) == 0 {
+		return nil, validationError("The SAML assertion does not contain a RoleSessionName attribute.")
+	}
+
+	duration, err := s.duration(params, roleARN, false)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, user := s.newSession(session{roleARN: roleARN, sessionName: names[0]}, duration)
+	return assumeRoleResult{
+		XMLName:         xml.Name{Local: "AssumeRoleWithSAMLResult"},
+		AssumedRoleUser: user,
+		Credentials:     creds,
+		Audience:        "https://signin.aws.amazon.com/saml",
+		Issuer:          assertion.Issuer.Url,
+		Subject:         assertion.Assertion.Subject.NameID.Value,
+		SubjectType:     "unspecified",
+	}, nil
+}
+
+func (s *Server) assumeRole(call Call) (any, error) {
+	caller, ok := s.sessions[call.AccessKeyID]
+	if !ok {
+		return nil, invalidClientTokenError
+	}
+
+	roleARN := call.Params.Get("RoleArn")
+	if call.Params.Get("RoleSessionName") == "" {
+		return nil, validationError("1 validation error detected: Value null at 'roleSessionName' failed to satisfy constraint: Member must not be null")
+	}
+
+	duration, err := s.duration(call.Params, roleARN, true)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, user := s.newSession(session{roleARN: roleARN, sessionName: call.Params.Get("RoleSessionName"), chained: caller.roleARN != ""}, duration)
+	return assumeRoleResult{XMLName: xml.Name{Local: "AssumeRoleResult"}, AssumedRoleUser: user, Credentials: creds}, nil
+}
+
+func (s *Server) getCallerIdentity(call Call) (any, error) {
+	caller, ok := s.sessions[call.AccessKeyID]
+	if !ok {
+		return nil, invalidClientTokenError
+	}
+
+	user := assumedRoleUser(caller)
+	account, _, _ := strings.Cut(strings.TrimPrefix(caller.roleARN, "arn:aws:iam::"), ":")
+	return getCallerIdentityResult{Arn: user.Arn, UserID: user.AssumedRoleID, Account: account}, nil
+}
+
+// duration returns the requested session duration, validating it as STS does. The lock must be held.
+func (s *Server) duration(params url.Values, roleARN string, chained bool) (time.Duration, error) {
+	duration := defaultDuration
+	if v := params.Get("DurationSeconds"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, validationError(fmt.Sprintf("1 validation error detected: Value '%s' at 'durationSeconds' failed to satisfy constraint: Member must be a number", v))
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	maxSessionDuration, ok := s.maxSessionDuration[roleARN]
+	if !ok {
+		maxSessionDuration = DefaultMaxSessionDuration
+	}
+
+	switch {
+	case duration < minDuration:
+		return 0, validationError(fmt.Sprintf("1 validation error detected: Value '%d' at 'durationSeconds' failed to satisfy constraint: Member must have value greater than or equal to %d", int(duration.Seconds()), int(minDuration.Seconds())))
+	case duration > maxDuration:
+		return 0, DurationSecondsError(duration)
+	case chained && duration > roleChainingLimit:
+		return 0, validationError("The requested DurationSeconds exceeds the 1 hour session limit for roles assumed by role chaining.")
+	case duration > maxSessionDuration:
+		return 0, MaxSessionDurationError
+	}
+
+	return duration, nil
+}
+
+// newSession creates a session lasting for duration, returning its credentials. The lock must be held.
+func (s *Server) newSession(sess session, duration time.Duration) (credentialsResult, assumedRoleUserResult) {
+	creds := credentialsResult{
+		AccessKeyID:     "ASIA" + randomID(16),
+		SecretAccessKey: randomString(30),
+		SessionToken:    randomString(64),
+		Expiration:      time.Now().Add(duration).UTC().Truncate(time.Second),
+	}
+
+	s.sessions[creds.AccessKeyID] = sess
+	return creds, assumedRoleUser(sess)
+}
+
+func assumedRoleUser(sess session) assumedRoleUserResult {
+	// Role ARNs are of the form arn:aws:iam::account:role/path/name; assumed role ARNs do not include the path.
+	prefix, resource, _ := strings.Cut(sess.roleARN, ":role/")
+	name := resource[strings.LastIndex(resource, "/")+1:]
+	return assumedRoleUserResult{
+		Arn:           fmt.Sprintf("%s:assumed-role/%s/%s", strings.Replace(prefix, ":iam:", ":sts:", 1), name, sess.sessionName),
+		AssumedRoleID: "AROA" + randomID(17) + ":" + sess.sessionName,
+	}
+}
+
+var credentialPattern = regexp.MustCompile(`Credential=([^/]+)/`)
+
+// accessKeyID returns the access key ID of the credentials r was signed with, or an empty string if it is not signed.
+func accessKeyID(r *http.Request) string {
+	if m := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+func writeError(w http.ResponseWriter, err Error) {
+	typ := "Sender"
+	if err.StatusCode >= 500 {
+		typ = "Receiver"
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(err.StatusCode)
+	xml.NewEncoder(w).Encode(errorResponse{
+		Namespace: namespace,
+		Error:     errorDetail{Type: typ, Code: err.Code, Message: err.Message},
+		RequestID: requestID(),
+	})
+}
+
+type response struct {
+	XMLName          xml.Name
+	Namespace        string `xml:"xmlns,attr"`
+	Result           any
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+type responseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+type credentialsResult struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+type assumedRoleUserResult struct {
+	Arn           string `xml:"Arn"`
+	AssumedRoleID string `xml:"AssumedRoleId"`
+}
+
+type assumeRoleResult struct {
+	XMLName         xml.Name
+	AssumedRoleUser assumedRoleUserResult `xml:"AssumedRoleUser"`
+	Credentials     credentialsResult     `xml:"Credentials"`
+	Audience        string                `xml:"Audience,omitempty"`
+	Issuer          string                `xml:"Issuer,omitempty"`
+	Subject         string                `xml:"Subject,omitempty"`
+	SubjectType     string                `xml:"SubjectType,omitempty"`
+}
+
+type getCallerIdentityResult struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResult"`
+	Arn     string   `xml:"Arn"`
+	UserID  string   `xml:"UserId"`
+	Account string   `xml:"Account"`
+}
+
+type errorResponse struct {
+	XMLName   xml.Name    `xml:"ErrorResponse"`
+	Namespace string      `xml:"xmlns,attr"`
+	Error     errorDetail `xml:"Error"`
+	RequestID string      `xml:"RequestId"`
+}
+
+type errorDetail struct {
+	Type    string `xml:"Type"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func requestID() string {
+	return strings.ToLower(randomID(8) + "-" + randomID(4) + "-" + randomID(4) + "-" + randomID(4) + "-" + randomID(12))
+}
+
+const idAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// randomID returns n random characters in the style of the IDs of IAM resources.
+func randomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	for i := range buf {
+		buf[i] = idAlphabet[int(buf[i])%len(idAlphabet)]
+	}
+	return string(buf)
+}
+
+func randomString(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return base64.StdEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,131 @@
+package ststest
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRoleARN     = "arn:aws:iam::123456789012:role/Admin"
+	testProviderARN = "arn:aws:iam::123456789012:saml-provider/Okta"
+)
+
+func newTestClient(srv *Server, creds aws.CredentialsProvider) *sts.Client {
+	return sts.New(sts.Options{Region: "us-west-2", BaseEndpoint: aws.String(srv.URL), Credentials: creds})
+}
+
+func samlAssertion(roles ...string) string {
+	var attrs string
+	for _, role := range roles {
+		attrs += fmt.Sprintf("<saml2:AttributeValue>%s</saml2:AttributeValue>", role)
+	}
+
+	doc := fmt.Sprintf(`<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" Version="2.0"><saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion"><saml2:AttributeStatement>
+<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">%s</saml2:Attribute>
+<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/RoleSessionName"><saml2:AttributeValue>user@example.com</saml2:AttributeValue></saml2:Attribute>
+</saml2:AttributeStatement></saml2:Assertion></saml2p:Response>`, attrs)
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestServer_AssumeRoleWithSAMLThenAssumeRole(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+
+	resp, err := newTestClient(srv, nil).AssumeRoleWithSAML(ctx, &sts.AssumeRoleWithSAMLInput{
+		RoleArn:       aws.String(testRoleARN),
+		PrincipalArn:  aws.String(testProviderARN),
+		SAMLAssertion: aws.String(samlAssertion(testProviderARN + "," + testRoleARN)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:sts::123456789012:assumed-role/Admin/user@example.com", aws.ToString(resp.AssumedRoleUser.Arn))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), aws.ToTime(resp.Credentials.Expiration), time.Minute)
+
+	creds := credentials.NewStaticCredentialsProvider(aws.ToString(resp.Credentials.AccessKeyId), aws.ToString(resp.Credentials.SecretAccessKey), aws.ToString(resp.Credentials.SessionToken))
+	identity, err := newTestClient(srv, creds).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	require.NoError(t, err)
+	assert.Equal(t, "123456789012", aws.ToString(identity.Account))
+	assert.Equal(t, aws.ToString(resp.AssumedRoleUser.Arn), aws.ToString(identity.Arn))
+
+	// Sessions assumed by role chaining are limited to an hour.
+	_, err = newTestClient(srv, creds).AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::210987654321:role/Workload"),
+		RoleSessionName: aws.String("KeyConjurer-AssumeRole"),
+		DurationSeconds: aws.Int32(7200),
+	})
+	assertAPIError(t, err, "ValidationError")
+
+	chained, err := newTestClient(srv, creds).AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::210987654321:role/Workload"),
+		RoleSessionName: aws.String("KeyConjurer-AssumeRole"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:sts::210987654321:assumed-role/Workload/KeyConjurer-AssumeRole", aws.ToString(chained.AssumedRoleUser.Arn))
+
+	calls := srv.Calls()
+	require.Len(t, calls, 4)
+	assert.Equal(t, "AssumeRole", calls[3].Action)
+	assert.Equal(t, aws.ToString(resp.Credentials.AccessKeyId), calls[3].AccessKeyID)
+}
+
+func TestServer_Errors(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	client := newTestClient(srv, nil)
+	input := func(duration int32) *sts.AssumeRoleWithSAMLInput {
+		return &sts.AssumeRoleWithSAMLInput{
+			RoleArn:         aws.String(testRoleARN),
+			PrincipalArn:    aws.String(testProviderARN),
+			SAMLAssertion:   aws.String(samlAssertion(testProviderARN + "," + testRoleARN)),
+			DurationSeconds: aws.Int32(duration),
+		}
+	}
+
+	_, err := client.AssumeRoleWithSAML(ctx, input(7200))
+	assertAPIError(t, err, "ValidationError", MaxSessionDurationError.Message)
+
+	srv.SetMaxSessionDuration(testRoleARN, 4*time.Hour)
+	_, err = client.AssumeRoleWithSAML(ctx, input(7200))
+	assert.NoError(t, err)
+
+	_, err = client.AssumeRoleWithSAML(ctx, input(86400))
+	assertAPIError(t, err, "ValidationError", DurationSecondsError(24*time.Hour).Message)
+
+	srv.FailNext("AssumeRoleWithSAML", AccessDeniedError("arn:aws:sts::123456789012:assumed-role/Admin/user@example.com", testRoleARN))
+	_, err = client.AssumeRoleWithSAML(ctx, input(3600))
+	assertAPIError(t, err, "AccessDenied")
+	_, err = client.AssumeRoleWithSAML(ctx, input(3600))
+	assert.NoError(t, err, "injected errors are only returned once")
+
+	_, err = client.AssumeRoleWithSAML(ctx, &sts.AssumeRoleWithSAMLInput{
+		RoleArn:       aws.String("arn:aws:iam::123456789012:role/NotInAssertion"),
+		PrincipalArn:  aws.String(testProviderARN),
+		SAMLAssertion: aws.String(samlAssertion(testProviderARN + "," + testRoleARN)),
+	})
+	assertAPIError(t, err, "AccessDenied")
+
+	_, err = newTestClient(srv, credentials.NewStaticCredentialsProvider("AKIAUNKNOWN", "secret", "")).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	assertAPIError(t, err, "InvalidClientTokenId")
+}
+
+func assertAPIError(t *testing.T, err error, code string, message ...string) {
+	t.Helper()
+	var apiErr smithy.APIError
+	require.True(t, errors.As(err, &apiErr), "expected an API error, got %v", err)
+	assert.Equal(t, code, apiErr.ErrorCode())
+	if len(message) > 0 {
+		assert.Equal(t, message[0], apiErr.ErrorMessage())
+	}
+}
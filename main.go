@@ -12,7 +12,6 @@ import (
 	"log/slog"
 
 	"github.com/riotgames/key-conjurer/command"
-	"github.com/spf13/cobra"
 )
 
 const (
@@ -57,7 +56,11 @@ func main() {
 	}
 
 	if err != nil {
-		cobra.CheckErr(err)
+		// cobra.CheckErr is not used, as it would exit with a status of 1 regardless of the error.
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if hint, ok := command.GetHint(err); ok {
+			fmt.Fprintln(os.Stderr, hint)
+		}
 
 		errorCode, ok := command.GetExitCode(err)
 		if !ok {
@@ -5,9 +5,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 
 	"github.com/RobotsAndPencils/go-saml"
 	"github.com/coreos/go-oidc"
@@ -40,33 +42,86 @@ func generateState() string {
 	return base64.URLEncoding.EncodeToString(stateBuf)
 }
 
-func NewAuthorizationCodeHandler(cfg *oauth2.Config, serveURL func(string) error) *AuthorizationCodeHandler {
-	return &AuthorizationCodeHandler{
-		config:   cfg,
-		serveURL: serveURL,
+// AuthorizationCodeOption configures an AuthorizationCodeHandler.
+type AuthorizationCodeOption func(*AuthorizationCodeHandler)
+
+// WithCallbackPages sets the pages shown in the browser once the flow completes. The default page is shown in place of any page which is not set.
+func WithCallbackPages(pages CallbackPages) AuthorizationCodeOption {
+	return func(h *AuthorizationCodeHandler) {
+		h.callbacks.Pages = pages
+	}
+}
+
+// NewAuthorizationCodeHandler returns a handler for the authorization code flow, which calls serveURL with the URL the user must visit to sign in.
+func NewAuthorizationCodeHandler(cfg *oauth2.Config, serveURL func(string) error, opts ...AuthorizationCodeOption) *AuthorizationCodeHandler {
+	var path string
+	if redirectURL, err := url.Parse(cfg.RedirectURL); err == nil {
+		path = redirectURL.Path
 	}
+
+	h := &AuthorizationCodeHandler{
+		config:    cfg,
+		serveURL:  serveURL,
+		callbacks: &handler{Exchanger: cfg, Path: path},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
 }
 
 type AuthorizationCodeHandler struct {
-	config   *oauth2.Config
-	serveURL func(url string) error
+	config    *oauth2.Config
+	serveURL  func(url string) error
+	callbacks *handler
 }
 
-func (r AuthorizationCodeHandler) HandlePendingSession(ctx context.Context, listener net.Listener) (*oauth2.Token, error) {
+// ServeHTTP serves callback requests to the redirect URI of the config for any pending session.
+//
+// Requests to any other path are answered with 404.
+func (r *AuthorizationCodeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.callbacks.ServeHTTP(w, req)
+}
+
+// Authorize starts a new session, calls serveURL with the URL the user must visit, and waits for the session's callback request.
+//
+// Callback requests must be served by ServeHTTP, such as by HandlePendingSession. Any number of sessions may be pending at once; each is identified by its state.
+func (r *AuthorizationCodeHandler) Authorize(ctx context.Context) (*oauth2.Token, error) {
 	state := generateState()
 	verifier := oauth2.GenerateVerifier()
-	url := r.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
-	handler := &handler{jobs: make(chan job), Exchanger: r.config}
-	// TODO: This error probably should not be ignored if it is not http.ErrServerClosed
-	go http.Serve(listener, handler)
-	defer handler.Close()
-
-	if err := r.serveURL(url); err != nil {
+	// The session is registered before the URL is served so that a callback cannot arrive before it.
+	s := r.callbacks.Begin(state, verifier)
+	if err := r.serveURL(r.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))); err != nil {
+		r.callbacks.take(state)
 		// This is unlikely to ever happen
 		return nil, fmt.Errorf("failed to display link: %w", err)
 	}
 
-	return handler.Wait(ctx, state, verifier)
+	return r.callbacks.Wait(ctx, s)
+}
+
+// HandlePendingSession serves callback requests on listener for a single session started with Authorize.
+//
+// The listener is closed once the session completes. If the listener fails before then, the error is returned.
+func (r *AuthorizationCodeHandler) HandlePendingSession(ctx context.Context, listener net.Listener) (*oauth2.Token, error) {
+	// Requests are served with ctx as their base, so that the code is exchanged with the HTTP client in ctx, if there is one.
+	srv := &http.Server{Handler: r, BaseContext: func(net.Listener) context.Context { return ctx }}
+	defer srv.Close()
+
+	waitCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			cancel(fmt.Errorf("serve callback: %w", err))
+		}
+	}()
+
+	tok, err := r.Authorize(waitCtx)
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+		return nil, context.Cause(waitCtx)
+	}
+	return tok, err
 }
 
 func DiscoverConfigAndExchangeTokenForAssertion(ctx context.Context, ts oauth2.TokenSource, oidcDomain, clientID, applicationID string) (*saml.Response, string, error) {
@@ -75,6 +130,13 @@ func DiscoverConfigAndExchangeTokenForAssertion(ctx context.Context, ts oauth2.T
 		return nil, "", fmt.Errorf("discover oauth2 config: %w", err)
 	}
 
+	return ExchangeTokenForAssertion(ctx, oauthCfg, ts, oidcDomain, applicationID)
+}
+
+// ExchangeTokenForAssertion is like DiscoverConfigAndExchangeTokenForAssertion, but uses an already discovered oauth2 config.
+//
+// This is useful when retrieving assertions for many applications, as discovery only needs to happen once.
+func ExchangeTokenForAssertion(ctx context.Context, oauthCfg *oauth2.Config, ts oauth2.TokenSource, oidcDomain, applicationID string) (*saml.Response, string, error) {
 	tok, err := oktawebsso.ExchangeAccessToken(ctx, oauthCfg, ts, applicationID)
 	if err != nil {
 		return nil, "", fmt.Errorf("get websso token: %w", err)
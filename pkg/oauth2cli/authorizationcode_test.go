@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/oauth2"
@@ -42,7 +45,7 @@ func (te *testExchanger) Exchange(_ context.Context, code string, _ ...oauth2.Au
 func Test_handler_YieldsCorrectlyFormattedState(t *testing.T) {
 	var (
 		ex            testExchanger
-		handle        = &handler{Exchanger: &ex, jobs: make(chan job)}
+		handle        = &handler{Exchanger: &ex, Path: "/oauth2/callback"}
 		expectedToken = &oauth2.Token{
 			AccessToken: "1234",
 		}
@@ -50,7 +53,6 @@ func Test_handler_YieldsCorrectlyFormattedState(t *testing.T) {
 		code     = "code goes here"
 		verifier = oauth2.GenerateVerifier()
 		dl, _    = t.Deadline()
-		ctx, _   = context.WithDeadline(context.Background(), dl)
 		values   = url.Values{
 			"state": []string{state},
 			"code":  []string{code},
@@ -65,11 +67,156 @@ func Test_handler_YieldsCorrectlyFormattedState(t *testing.T) {
 		w = httptest.NewRecorder()
 	)
 
+	ctx, cancel := context.WithDeadline(context.Background(), dl)
+	t.Cleanup(cancel)
 	ex.AddToken(code, expectedToken)
 
+	s := handle.Begin(state, verifier)
 	go handle.ServeHTTP(w, r)
 
-	tok, err := handle.Wait(ctx, state, verifier)
+	tok, err := handle.Wait(ctx, s)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedToken, tok)
 }
+
+func Test_authorizationCodeReq_Verify(t *testing.T) {
+	tests := []struct {
+		name string
+		req  authorizationCodeReq
+		err  error
+	}{
+		{name: "valid", req: authorizationCodeReq{state: "state", code: "code"}},
+		{name: "state mismatch", req: authorizationCodeReq{state: "another state", code: "code"}, err: ErrStateMismatch},
+		{name: "missing code", req: authorizationCodeReq{state: "state"}, err: ErrMissingCode},
+		{
+			name: "authorization server error",
+			req:  authorizationCodeReq{state: "state", errorMessage: "access_denied", errorDescription: "User is not assigned to the client application."},
+			err:  AuthorizationError{Code: "access_denied", Description: "User is not assigned to the client application."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code string
+			err := tt.req.Verify("state", &code)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, "code", code)
+				return
+			}
+
+			assert.Equal(t, tt.err, err)
+			assert.ErrorIs(t, err, ErrBadRequest)
+			assert.Empty(t, code)
+		})
+	}
+}
+
+func Test_handler_ReturnsAuthorizationErrors(t *testing.T) {
+	var (
+		handle = &handler{Exchanger: &testExchanger{}}
+		values = url.Values{
+			"state":             []string{"state"},
+			"error":             []string{"access_denied"},
+			"error_description": []string{"User is not assigned to the client application."},
+		}
+		r = httptest.NewRequest("GET", "http://localhost/?"+values.Encode(), nil)
+		w = httptest.NewRecorder()
+	)
+
+	s := handle.Begin("state", oauth2.GenerateVerifier())
+	handle.ServeHTTP(w, r)
+
+	_, err := handle.Wait(context.Background(), s)
+	assert.Equal(t, AuthorizationError{Code: "access_denied", Description: "User is not assigned to the client application."}, err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "User is not assigned to the client application.")
+}
+
+func Test_handler_IgnoresStrayRequests(t *testing.T) {
+	var (
+		ex     testExchanger
+		handle = &handler{Exchanger: &ex, Path: "/oauth2/callback"}
+		token  = &oauth2.Token{AccessToken: "1234"}
+	)
+	ex.AddToken("code", token)
+	s := handle.Begin("state", oauth2.GenerateVerifier())
+
+	w := httptest.NewRecorder()
+	handle.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/favicon.ico", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handle.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/oauth2/callback?state=stale&code=code", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "requests for another session must not complete this one")
+
+	w = httptest.NewRecorder()
+	handle.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/oauth2/callback?state=state&code=code", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	tok, err := handle.Wait(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Equal(t, token, tok)
+
+	// The session is complete, so it cannot be completed again.
+	w = httptest.NewRecorder()
+	handle.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/oauth2/callback?state=state&code=code", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_handler_ConcurrentSessions(t *testing.T) {
+	var (
+		ex     testExchanger
+		handle = &handler{Exchanger: &ex}
+		first  = handle.Begin("first", oauth2.GenerateVerifier())
+		second = handle.Begin("second", oauth2.GenerateVerifier())
+	)
+	ex.AddToken("code-1", &oauth2.Token{AccessToken: "1"})
+	ex.AddToken("code-2", &oauth2.Token{AccessToken: "2"})
+
+	// Sessions may complete in any order.
+	handle.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/?state=second&code=code-2", nil))
+	handle.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/?state=first&code=code-1", nil))
+
+	tok, err := handle.Wait(context.Background(), first)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", tok.AccessToken)
+
+	tok, err = handle.Wait(context.Background(), second)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", tok.AccessToken)
+}
+
+func TestAuthorizationCodeHandler_HandlePendingSessionReportsServeErrors(t *testing.T) {
+	cfg := oauth2.Config{RedirectURL: "http://localhost"}
+	h := NewAuthorizationCodeHandler(&cfg, func(string) error { return nil })
+	listener := &failingListener{err: errors.New("accept failed")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	_, err := h.HandlePendingSession(ctx, listener)
+	assert.ErrorIs(t, err, listener.err)
+	assert.NoError(t, ctx.Err(), "the error must be returned without waiting for the session to time out")
+}
+
+// failingListener is a net.Listener whose Accept always fails.
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) { return nil, l.err }
+func (l *failingListener) Close() error              { return nil }
+func (l *failingListener) Addr() net.Addr            { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func TestNewAuthorizationCodeHandler_WithCallbackPages(t *testing.T) {
+	cfg := oauth2.Config{RedirectURL: "http://localhost/callback"}
+	pages := CallbackPages{Branding: Branding{OrganizationName: "Example Corp"}}
+
+	h := NewAuthorizationCodeHandler(&cfg, func(string) error { return nil }, WithCallbackPages(pages))
+	assert.Equal(t, pages, h.callbacks.Pages)
+	assert.Equal(t, "/callback", h.callbacks.Path)
+
+	h = NewAuthorizationCodeHandler(&cfg, func(string) error { return nil })
+	assert.Equal(t, CallbackPages{}, h.callbacks.Pages)
+}
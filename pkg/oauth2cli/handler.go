@@ -5,22 +5,58 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 
 	"golang.org/x/oauth2"
 )
 
 // ErrBadRequest indicates that the request to the oauth2 callback endpoint contained malformed data.
+//
+// All of the errors returned for a callback request that could not be used to sign in match ErrBadRequest with errors.Is.
 var ErrBadRequest = errors.New("bad request")
 
+var (
+	// ErrStateMismatch indicates that the state in the callback request did not match the state of the pending session.
+	//
+	// This usually means the callback came from a browser tab left over from an earlier login.
+	ErrStateMismatch = fmt.Errorf("%w: state does not match the pending session", ErrBadRequest)
+	// ErrMissingCode indicates that the callback request contained neither an authorization code nor an error.
+	ErrMissingCode = fmt.Errorf("%w: no authorization code was given", ErrBadRequest)
+)
+
+// AuthorizationError is returned when the authorization server redirects back with an error instead of an authorization code.
+//
+// See RFC 6749, section 4.1.2.1.
+type AuthorizationError struct {
+	// Code is the error code returned by the authorization server, such as access_denied.
+	Code string
+	// Description is the human readable description of the error, if the authorization server returned one.
+	Description string
+	// URI identifies a page with more information about the error, if the authorization server returned one.
+	URI string
+}
+
+func (e AuthorizationError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("authorization server returned %s", e.Code)
+	}
+	return fmt.Sprintf("authorization server returned %s: %s", e.Code, e.Description)
+}
+
+func (e AuthorizationError) Is(target error) bool {
+	return target == ErrBadRequest
+}
+
 type result struct {
 	Token *oauth2.Token
 	Err   error
 }
 
-type job struct {
+// session is an authorization code flow which is waiting for its callback request.
+type session struct {
 	State, Verifier string
-	C               chan result
+	// C receives the result of the callback request. It is buffered so that the result can be sent even if nobody is waiting for it any longer.
+	C chan result
 }
 
 type codeExchanger interface {
@@ -28,101 +64,104 @@ type codeExchanger interface {
 }
 
 type handler struct {
-	Exchanger     codeExchanger
-	jobs          chan job
-	serveResponse func(err error, w http.ResponseWriter, r *http.Request) error
+	Exchanger codeExchanger
+	// Path is the path of the redirect URI. Requests for any other path, such as those browsers make for /favicon.ico, are answered with 404.
+	Path string
+	// Pages are shown in the browser once the flow completes. If a template is nil, the default page is shown.
+	Pages CallbackPages
+
+	mu       sync.Mutex
+	sessions map[string]session
 }
 
-func defaultResponseHandler(err error, w http.ResponseWriter, _ *http.Request) error {
-	if err == nil {
-		fmt.Fprintln(w, "You may close this window now.")
-		return nil
-	}
-
-	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-		w.WriteHeader(http.StatusGatewayTimeout)
-		fmt.Fprintln(w, "Took too long to get credentials.")
-		return nil
+// serveResponse shows the page for the outcome of the flow, which failed if err is not nil.
+func (h *handler) serveResponse(err error, w http.ResponseWriter, data PageData) {
+	pages := h.Pages.withDefaults()
+	data.Branding = pages.Branding
+	switch {
+	case err == nil:
+		servePage(w, http.StatusOK, pages.Success, data)
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		servePage(w, http.StatusGatewayTimeout, pages.Timeout, data)
+	case errors.Is(err, ErrBadRequest):
+		servePage(w, http.StatusBadRequest, pages.BadRequest, data)
+	default:
+		servePage(w, http.StatusInternalServerError, pages.Error, data)
 	}
-
-	if errors.Is(err, ErrBadRequest) {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, "Bad request.")
-		return nil
-	}
-
-	w.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprintln(w, "Internal server error.")
-	return nil
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	var (
-		job job
-		r   result
-	)
-
-	srvResponse := h.serveResponse
-	if srvResponse == nil {
-		srvResponse = defaultResponseHandler
+	path := h.Path
+	if path == "" {
+		path = "/"
 	}
 
-	ctx := req.Context()
-	select {
-	case j := <-h.jobs:
-		job = j
-
-		// Ensure that the result is sent.
-		defer func() {
-			job.C <- r
-			close(job.C)
-		}()
-	case <-ctx.Done():
-		srvResponse(ctx.Err(), w, req)
+	if req.URL.Path != path {
+		http.NotFound(w, req)
 		return
 	}
 
 	authCodeReq := authorizationCodeReq{
 		errorMessage:     req.FormValue("error"),
 		errorDescription: req.FormValue("error_description"),
+		errorURI:         req.FormValue("error_uri"),
 		state:            req.FormValue("state"),
 		code:             req.FormValue("code"),
 	}
+	data := PageData{Error: authCodeReq.errorMessage, ErrorDescription: authCodeReq.errorDescription}
 
-	var code string
-	if r.Err = authCodeReq.Verify(authCodeReq.state, &code); r.Err != nil {
-		srvResponse(r.Err, w, req)
+	// Requests which do not belong to a pending session, such as those from a browser tab left over from an earlier login, must not affect the sessions which are pending.
+	s, ok := h.take(authCodeReq.state)
+	if !ok {
+		h.serveResponse(ErrStateMismatch, w, data)
 		return
 	}
 
-	r.Token, r.Err = h.Exchanger.Exchange(ctx, code, oauth2.VerifierOption(job.Verifier))
-	if r.Err != nil {
-		srvResponse(r.Err, w, req)
+	var r result
+	defer func() { s.C <- r }()
+
+	var code string
+	if r.Err = authCodeReq.Verify(s.State, &code); r.Err != nil {
+		h.serveResponse(r.Err, w, data)
 		return
 	}
 
-	srvResponse(nil, w, req)
+	r.Token, r.Err = h.Exchanger.Exchange(req.Context(), code, oauth2.VerifierOption(s.Verifier))
+	h.serveResponse(r.Err, w, data)
 }
 
-func (h *handler) Wait(ctx context.Context, state, verifier string) (*oauth2.Token, error) {
-	j := job{State: state, Verifier: verifier, C: make(chan result)}
-	select {
-	case h.jobs <- j:
-	case <-ctx.Done():
-		return nil, ctx.Err()
+// Begin registers a pending session with the given state. Its callback request is served by ServeHTTP, and its result is returned by Wait.
+//
+// Any number of sessions may be pending at once.
+func (h *handler) Begin(state, verifier string) session {
+	s := session{State: state, Verifier: verifier, C: make(chan result, 1)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]session)
 	}
+	h.sessions[state] = s
+	return s
+}
 
+// Wait waits for the callback request of s to be served, or for ctx to be done, whichever happens first.
+func (h *handler) Wait(ctx context.Context, s session) (*oauth2.Token, error) {
+	defer h.take(s.State)
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case r := <-j.C:
+	case r := <-s.C:
 		return r.Token, r.Err
 	}
 }
 
-func (h *handler) Close() error {
-	close(h.jobs)
-	return nil
+// take removes the pending session with the given state, if there is one.
+func (h *handler) take(state string) (session, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[state]
+	delete(h.sessions, state)
+	return s, ok
 }
 
 type authorizationCodeReq struct {
@@ -130,12 +169,23 @@ type authorizationCodeReq struct {
 	state            string
 	errorMessage     string
 	errorDescription string
+	errorURI         string
 }
 
+// Verify checks that the request belongs to the session identified by state and stores its authorization code in code.
 func (o authorizationCodeReq) Verify(state string, code *string) error {
-	if o.errorMessage != "" || strings.Compare(o.state, state) != 0 {
-		return ErrBadRequest
+	if o.state != state {
+		return ErrStateMismatch
+	}
+
+	if o.errorMessage != "" {
+		return AuthorizationError{Code: o.errorMessage, Description: o.errorDescription, URI: o.errorURI}
 	}
+
+	if o.code == "" {
+		return ErrMissingCode
+	}
+
 	*code = o.code
 	return nil
 }
@@ -0,0 +1,90 @@
+package oauth2cli
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+//go:embed pages/*.html
+var pagesFS embed.FS
+
+// defaultPages are shown for any page not given in CallbackPages.
+var defaultPages = CallbackPages{
+	Success:    mustParsePage("success.html"),
+	Timeout:    mustParsePage("timeout.html"),
+	BadRequest: mustParsePage("bad-request.html"),
+	Error:      mustParsePage("error.html"),
+}
+
+func mustParsePage(name string) *template.Template {
+	layout := template.Must(template.ParseFS(pagesFS, "pages/layout.html"))
+	return template.Must(layout.ParseFS(pagesFS, "pages/"+name)).Lookup(name)
+}
+
+// Branding identifies the organization in the pages shown by the browser once the authorization code flow completes.
+type Branding struct {
+	OrganizationName string
+	LogoURL          string
+	// SupportURL is where users are directed for help, if set.
+	SupportURL string
+}
+
+// PageData is the data the templates in CallbackPages are executed with.
+type PageData struct {
+	Branding
+	// Error is the error code returned by the authorization server, such as access_denied, if it returned one.
+	Error string
+	// ErrorDescription is the human readable description of Error returned by the authorization server, if it returned one.
+	ErrorDescription string
+}
+
+// CallbackPages are the HTML templates shown in the browser once the authorization code flow completes. The default page is shown in place of any template that is nil.
+type CallbackPages struct {
+	// Success is shown once the user has been signed in.
+	Success *template.Template
+	// Timeout is shown if the login was cancelled or took too long.
+	Timeout *template.Template
+	// BadRequest is shown if the authorization server returned an error, or the callback request was malformed.
+	BadRequest *template.Template
+	// Error is shown if the authorization code could not be exchanged for a token.
+	Error *template.Template
+
+	Branding Branding
+}
+
+// withDefaults returns p with any missing template replaced by the default page.
+func (p CallbackPages) withDefaults() CallbackPages {
+	if p.Success == nil {
+		p.Success = defaultPages.Success
+	}
+	if p.Timeout == nil {
+		p.Timeout = defaultPages.Timeout
+	}
+	if p.BadRequest == nil {
+		p.BadRequest = defaultPages.BadRequest
+	}
+	if p.Error == nil {
+		p.Error = defaultPages.Error
+	}
+	return p
+}
+
+// servePage writes page with the given status code.
+//
+// The page is rendered before anything is written so that a template which fails to execute can be replaced with a plain text response.
+func servePage(w http.ResponseWriter, status int, page *template.Template, data PageData) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, http.StatusText(status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,83 @@
+package oauth2cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handler_serveResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{name: "success", status: http.StatusOK, body: "You're signed in"},
+		{name: "timeout", err: context.DeadlineExceeded, status: http.StatusGatewayTimeout, body: "stopped waiting"},
+		{name: "cancelled", err: fmt.Errorf("waiting: %w", context.Canceled), status: http.StatusGatewayTimeout, body: "stopped waiting"},
+		{name: "bad request", err: ErrBadRequest, status: http.StatusBadRequest, body: "The user denied access"},
+		{name: "exchange failed", err: errors.New("oauth2: server response missing access_token"), status: http.StatusInternalServerError, body: "Something went wrong"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler{Pages: CallbackPages{Branding: Branding{OrganizationName: "Example Corp", LogoURL: "https://example.com/logo.png", SupportURL: "https://example.com/help"}}}
+			w := httptest.NewRecorder()
+
+			h.serveResponse(tt.err, w, PageData{Error: "access_denied", ErrorDescription: "The user denied access"})
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), tt.body)
+			assert.Contains(t, w.Body.String(), "<title>Example Corp KeyConjurer</title>")
+			assert.Contains(t, w.Body.String(), `src="https://example.com/logo.png"`)
+			assert.Contains(t, w.Body.String(), `href="https://example.com/help"`)
+		})
+	}
+}
+
+func Test_handler_serveResponseEscapesErrorDescription(t *testing.T) {
+	var h handler
+	w := httptest.NewRecorder()
+
+	h.serveResponse(ErrBadRequest, w, PageData{ErrorDescription: "<script>alert(1)</script>"})
+
+	assert.NotContains(t, w.Body.String(), "<script>alert(1)</script>")
+	assert.Contains(t, w.Body.String(), "&lt;script&gt;alert(1)&lt;/script&gt;")
+}
+
+func Test_handler_serveResponseUsesCustomPages(t *testing.T) {
+	h := handler{Pages: CallbackPages{
+		Success:  template.Must(template.New("success").Parse("Welcome to {{.OrganizationName}}")),
+		Branding: Branding{OrganizationName: "Example Corp"},
+	}}
+
+	w := httptest.NewRecorder()
+	h.serveResponse(nil, w, PageData{})
+	assert.Equal(t, "Welcome to Example Corp", w.Body.String())
+
+	// Pages which were not customized fall back to the defaults.
+	w = httptest.NewRecorder()
+	h.serveResponse(ErrBadRequest, w, PageData{})
+	assert.Contains(t, w.Body.String(), "couldn't sign you in")
+}
+
+func Test_handler_serveResponseFallsBackToPlainText(t *testing.T) {
+	h := handler{Pages: CallbackPages{
+		Error: template.Must(template.New("error").Parse("{{.DoesNotExist}}")),
+	}}
+	w := httptest.NewRecorder()
+
+	h.serveResponse(errors.New("exchange failed"), w, PageData{})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+}
@@ -0,0 +1,63 @@
+package oauth2cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// ExchangeSessionToken runs the authorization code flow with PKCE for a user who signed in with the Okta authentication API, rather than in a browser.
+//
+// The one-time session token signs the user in to the authorization endpoint, which redirects to the redirect URI of cfg with an authorization code. The redirect is not followed, so nothing needs to listen on the redirect URI; it must still be registered with the application.
+//
+// The HTTP client is taken from ctx in the same way as oauth2.
+func ExchangeSessionToken(ctx context.Context, cfg *oauth2.Config, sessionToken string) (*oauth2.Token, error) {
+	redirectURL, err := url.Parse(cfg.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL: %w", err)
+	}
+
+	state := generateState()
+	verifier := oauth2.GenerateVerifier()
+	authURL := cfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("sessionToken", sessionToken))
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{}
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = *c
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil || location.Scheme != redirectURL.Scheme || location.Host != redirectURL.Host || location.Path != redirectURL.Path {
+		return nil, fmt.Errorf("authorization server did not redirect to the redirect URI (status code %d)", resp.StatusCode)
+	}
+
+	q := location.Query()
+	authCodeReq := authorizationCodeReq{
+		errorMessage:     q.Get("error"),
+		errorDescription: q.Get("error_description"),
+		errorURI:         q.Get("error_uri"),
+		state:            q.Get("state"),
+		code:             q.Get("code"),
+	}
+
+	var code string
+	if err := authCodeReq.Verify(state, &code); err != nil {
+		return nil, err
+	}
+
+	return cfg.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+}
@@ -0,0 +1,48 @@
+package oauth2cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"github.com/riotgames/key-conjurer/internal/oktaauthn"
+	"github.com/riotgames/key-conjurer/internal/oktatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestExchangeSessionToken(t *testing.T) {
+	srv := oktatest.NewServer("0oaKeyConjurer", oktatest.User{ID: "00u1", Login: "user@example.com", Password: "hunter2"})
+	t.Cleanup(srv.Close)
+	// The browser is signed out, so the user is only signed in by the session token.
+	require.NoError(t, srv.SignIn(""))
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+
+	cfg, err := DiscoverConfig(ctx, srv.URL, srv.ClientID)
+	require.NoError(t, err)
+	cfg.RedirectURL = "http://localhost:57468"
+	// Otherwise a failed exchange would be retried with an already used code.
+	cfg.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+
+	_, err = ExchangeSessionToken(ctx, cfg, "not a session token")
+	var authErr AuthorizationError
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, "login_required", authErr.Code)
+
+	client := oktaauthn.Client{Domain: srv.URL, HTTPClient: srv.Client()}
+	tx, err := client.Authenticate(ctx, "user@example.com", "hunter2")
+	require.NoError(t, err)
+
+	tok, err := ExchangeSessionToken(ctx, cfg, tx.SessionToken)
+	require.NoError(t, err)
+	provider, err := oidc.NewProvider(ctx, srv.URL)
+	require.NoError(t, err)
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: srv.ClientID}).Verify(ctx, tok.Extra("id_token").(string))
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", idToken.Subject)
+
+	// Session tokens may only be used once.
+	_, err = ExchangeSessionToken(ctx, cfg, tx.SessionToken)
+	assert.ErrorAs(t, err, &authErr)
+}
@@ -2,14 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"log/slog"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/coreos/go-oidc"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/riotgames/key-conjurer/internal/api"
+	"github.com/riotgames/key-conjurer/internal/metrics"
 	"github.com/urfave/cli/v3"
 )
 
@@ -31,10 +43,154 @@ func main() {
 				Name:    "okta-token-file",
 				Sources: cli.EnvVars("KEYCONJURER_OKTA_TOKEN_FILE"),
 			},
+			&cli.StringFlag{
+				Name:    "token-validation",
+				Usage:   "How bearer tokens are validated: 'userinfo' calls the Okta UserInfo endpoint for every request, 'jwt' validates tokens from a custom authorization server locally, and 'jwt+userinfo' validates JWTs locally, calling the UserInfo endpoint for opaque tokens. Tokens the CLI logs in with can only be validated with 'userinfo'",
+				Value:   string(api.TokenValidationUserInfo),
+				Sources: cli.EnvVars("KEYCONJURER_TOKEN_VALIDATION"),
+			},
+			&cli.StringFlag{
+				Name:    "jwt-issuer",
+				Usage:   "The issuer of access tokens validated locally, which must be a custom authorization server. Defaults to the default custom authorization server (https://<okta-host>/oauth2/default)",
+				Sources: cli.EnvVars("KEYCONJURER_JWT_ISSUER"),
+			},
+			&cli.StringFlag{
+				Name:    "jwt-audience",
+				Usage:   "The audience access tokens validated locally must be issued for (e.g., 'api://default')",
+				Sources: cli.EnvVars("KEYCONJURER_JWT_AUDIENCE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "jwt-client-id",
+				Usage:   "The client ID that access tokens validated locally must be issued to. May be specified more than once",
+				Sources: cli.EnvVars("KEYCONJURER_JWT_CLIENT_IDS"),
+			},
+			&cli.StringFlag{
+				Name:    "application-types-file",
+				Usage:   "A YAML file selecting which Okta applications are served and their types. If omitted, only applications using the AWS Account Federation integration are served",
+				Sources: cli.EnvVars("KEYCONJURER_APPLICATION_TYPES_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "rules-file",
+				Usage:   "A YAML file of rules hiding applications from the users they match. Hidden applications are not listed, but access to them must still be removed in Okta. If omitted, users see every application assigned to them",
+				Sources: cli.EnvVars("KEYCONJURER_RULES_FILE"),
+			},
+			&cli.DurationFlag{
+				Name:    "cache-ttl",
+				Usage:   "How long the applications of each user are cached for (e.g., '5m'). If 0, applications are not cached",
+				Sources: cli.EnvVars("KEYCONJURER_CACHE_TTL"),
+			},
+			&cli.DurationFlag{
+				Name:    "metadata-cache-ttl",
+				Usage:   "How long the metadata of each application served by the v3 API is cached for (e.g., '15m'). If 0, metadata is retrieved from Okta for every application on every request",
+				Sources: cli.EnvVars("KEYCONJURER_METADATA_CACHE_TTL"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-url",
+				Usage:   "The URL of a Redis server to cache applications and their metadata in, such as redis://cache.example.com:6379/0. If omitted, they are cached in memory",
+				Sources: cli.EnvVars("KEYCONJURER_CACHE_URL"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "audit-group",
+				Usage:   "A group whose members may retrieve the audit report at GET /v3/audit. May be specified more than once. If omitted, the audit report is not served",
+				Sources: cli.EnvVars("KEYCONJURER_AUDIT_GROUPS"),
+			},
+			&cli.StringFlag{
+				Name:    "audit-client-id",
+				Usage:   "The client ID of the KeyConjurer OIDC application. The audit report flags AWS applications whose Allowed Web SSO Client is not this client ID",
+				Sources: cli.EnvVars("KEYCONJURER_AUDIT_CLIENT_ID"),
+			},
+			&cli.StringFlag{
+				Name:    "workload-policy-file",
+				Usage:   "A YAML file of the CI OIDC issuers trusted to request AWS credentials, and the roles their jobs may assume. If omitted, CI jobs cannot request credentials",
+				Sources: cli.EnvVars("KEYCONJURER_WORKLOAD_POLICY_FILE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "callback-port",
+				Usage:   "A port the CLI may receive the OAuth2 callback on when logging in, in order of preference. Must match the redirect URIs of the OIDC application. May be specified more than once",
+				Sources: cli.EnvVars("KEYCONJURER_CALLBACK_PORTS"),
+			},
+			&cli.StringFlag{
+				Name:    "callback-bind-address",
+				Usage:   "The IP address the CLI listens on for the OAuth2 callback when logging in (e.g., '127.0.0.1' or '::1')",
+				Sources: cli.EnvVars("KEYCONJURER_CALLBACK_BIND_ADDRESS"),
+			},
+			&cli.StringFlag{
+				Name:    "callback-redirect-host",
+				Usage:   "The host of the redirect URI the CLI logs in with (e.g., 'localhost', '127.0.0.1' or '[::1]')",
+				Sources: cli.EnvVars("KEYCONJURER_CALLBACK_REDIRECT_HOST"),
+			},
+			&cli.FloatFlag{
+				Name:    "user-rate-limit",
+				Usage:   "The number of times per minute each user may cause their applications to be fetched from Okta (e.g., 30). If 0, users are not limited",
+				Sources: cli.EnvVars("KEYCONJURER_USER_RATE_LIMIT"),
+			},
+			&cli.IntFlag{
+				Name:    "user-rate-burst",
+				Usage:   "The number of times each user may fetch their applications from Okta in quick succession before --user-rate-limit applies",
+				Value:   10,
+				Sources: cli.EnvVars("KEYCONJURER_USER_RATE_BURST"),
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Usage:   "The format of log lines, 'json' or 'text'",
+				Value:   "json",
+				Sources: cli.EnvVars("KEYCONJURER_LOG_FORMAT"),
+			},
+			&cli.StringFlag{
+				Name:    "metrics-namespace",
+				Usage:   "The CloudWatch namespace metrics are published to when running as a Lambda function",
+				Value:   "KeyConjurer",
+				Sources: cli.EnvVars("KEYCONJURER_METRICS_NAMESPACE"),
+			},
+			&cli.StringFlag{
+				Name:    "listen",
+				Usage:   "Serve HTTP on the given address (e.g., ':8080') instead of running as a Lambda function",
+				Sources: cli.EnvVars("KEYCONJURER_LISTEN"),
+			},
+			&cli.StringFlag{
+				Name:    "tls-cert-file",
+				Usage:   "Path to a PEM encoded certificate to serve HTTPS with when --listen is specified",
+				Sources: cli.EnvVars("KEYCONJURER_TLS_CERT_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "tls-key-file",
+				Usage:   "Path to the PEM encoded private key for --tls-cert-file",
+				Sources: cli.EnvVars("KEYCONJURER_TLS_KEY_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "tls-min-version",
+				Usage:   "The minimum TLS version to accept, either '1.2' or '1.3'",
+				Value:   "1.2",
+				Sources: cli.EnvVars("KEYCONJURER_TLS_MIN_VERSION"),
+			},
+			&cli.DurationFlag{
+				Name:    "read-header-timeout",
+				Usage:   "The maximum amount of time to wait for the headers of a request",
+				Value:   10 * time.Second,
+				Sources: cli.EnvVars("KEYCONJURER_READ_HEADER_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "request-timeout",
+				Usage:   "The maximum amount of time to spend serving a request, including calls to Okta",
+				Value:   30 * time.Second,
+				Sources: cli.EnvVars("KEYCONJURER_REQUEST_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "idle-timeout",
+				Usage:   "The maximum amount of time to keep an idle keep-alive connection open",
+				Value:   120 * time.Second,
+				Sources: cli.EnvVars("KEYCONJURER_IDLE_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "shutdown-timeout",
+				Usage:   "The maximum amount of time to wait for in-flight requests to finish when shutting down",
+				Value:   15 * time.Second,
+				Sources: cli.EnvVars("KEYCONJURER_SHUTDOWN_TIMEOUT"),
+			},
 		},
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill, syscall.SIGTERM)
 	defer stop()
 
 	err := cmd.Run(ctx, os.Args)
@@ -45,6 +201,15 @@ func main() {
 }
 
 func runServer(ctx context.Context, cmd *cli.Command) error {
+	switch cmd.String("log-format") {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	default:
+		return cli.Exit("--log-format must be one of 'json' or 'text'", 1)
+	}
+
 	token := cmd.String("okta-token")
 	if token == "" {
 		tokenFile := cmd.String("okta-token-file")
@@ -64,13 +229,295 @@ func runServer(ctx context.Context, cmd *cli.Command) error {
 		Host:   cmd.String("okta-host"),
 	}
 
-	service := api.NewOktaService(&oktaDomain, token)
-	idp, err := oidc.NewProvider(ctx, oktaDomain.String())
+	serviceMetrics := api.NewMetrics(prometheus.NewRegistry())
+	oktaClient := http.Client{Transport: serviceMetrics.OktaTransport(http.DefaultTransport)}
+	service := api.NewOktaService(&oktaDomain, token, &oktaClient)
+	tokens, err := newTokenValidator(ctx, cmd, oktaDomain.String())
+	if err != nil {
+		return err
+	}
+
+	cache, metadataCache, err := newApplicationCaches(cmd)
+	if err != nil {
+		return err
+	}
+
+	filter, err := readApplicationFilter(cmd)
+	if err != nil {
+		return err
+	}
+
+	rules, err := readAuthorizationRules(cmd)
+	if err != nil {
+		return err
+	}
+
+	var limiter *api.UserRateLimiter
+	if perMinute := cmd.Float("user-rate-limit"); perMinute > 0 {
+		limiter = api.NewUserRateLimiter(perMinute, int(cmd.Int("user-rate-burst")))
+	}
+
+	var audit *api.Audit
+	if groups := cmd.StringSlice("audit-group"); len(groups) > 0 {
+		clientID := cmd.String("audit-client-id")
+		if clientID == "" {
+			return cli.Exit("--audit-client-id must be specified with --audit-group", 1)
+		}
+
+		audit = &api.Audit{Service: service, ClientID: clientID, Groups: groups}
+	}
+
+	workload, err := newWorkloadIdentity(ctx, cmd)
 	if err != nil {
-		return fmt.Errorf("could not create OIDC provider: %w", err)
+		return err
 	}
 
-	handler := api.ServeUserApplications(service, idp)
+	loginSettings, err := readLoginSettings(cmd)
+	if err != nil {
+		return err
+	}
+
+	h := api.ServeUserApplicationsHandler{Okta: service, Tokens: tokens, Cache: cache, Filter: filter, Applications: service, MetadataCache: metadataCache, Rules: rules, Metrics: serviceMetrics, Limiter: limiter, Audit: audit, Workload: workload, LoginSettings: loginSettings}
+	if addr := cmd.String("listen"); addr != "" {
+		return listenAndServe(ctx, cmd, addr, api.NewServeMux(h))
+	}
+
+	handler := emfHandler{Handler: h.Handler(), emf: &metrics.EMFWriter{Gatherer: serviceMetrics.Registry, Namespace: cmd.String("metrics-namespace")}}
 	lambda.StartWithOptions(handler, lambda.WithContext(ctx))
 	return nil
 }
+
+// emfHandler writes metrics to stdout in the CloudWatch Embedded Metric Format after each invocation, as a Lambda function has no endpoint that metrics could be scraped from.
+type emfHandler struct {
+	lambda.Handler
+	emf *metrics.EMFWriter
+}
+
+func (e emfHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	resp, err := e.Handler.Invoke(ctx, payload)
+	if emfErr := e.emf.Write(os.Stdout, time.Now()); emfErr != nil {
+		slog.Error("could not write metrics", slog.String("error", emfErr.Error()))
+	}
+
+	return resp, err
+}
+
+// readApplicationFilter reads the file named by --application-types-file, or returns nil if it was not specified.
+func readApplicationFilter(cmd *cli.Command) (*api.ApplicationFilter, error) {
+	path := cmd.String("application-types-file")
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	filter, err := api.ReadApplicationFilter(file)
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("%s is invalid: %s", path, err), 1)
+	}
+
+	return &filter, nil
+}
+
+// readLoginSettings returns the login settings given by the --callback-* flags, or nil if none were specified.
+func readLoginSettings(cmd *cli.Command) (*api.LoginSettings, error) {
+	settings := api.LoginSettings{
+		CallbackPorts:        cmd.StringSlice("callback-port"),
+		CallbackBindAddress:  cmd.String("callback-bind-address"),
+		CallbackRedirectHost: cmd.String("callback-redirect-host"),
+	}
+
+	if len(settings.CallbackPorts) == 0 && settings.CallbackBindAddress == "" && settings.CallbackRedirectHost == "" {
+		return nil, nil
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, cli.Exit(err.Error(), 1)
+	}
+
+	return &settings, nil
+}
+
+// readAuthorizationRules reads the file named by --rules-file, or returns nil if it was not specified.
+func readAuthorizationRules(cmd *cli.Command) (*api.AuthorizationRules, error) {
+	path := cmd.String("rules-file")
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	rules, err := api.ReadAuthorizationRules(file)
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("%s is invalid: %s", path, err), 1)
+	}
+
+	return &rules, nil
+}
+
+// newWorkloadIdentity reads the policy named by --workload-policy-file and creates the STS client used to issue credentials, or returns nil if it was not specified.
+func newWorkloadIdentity(ctx context.Context, cmd *cli.Command) (*api.WorkloadIdentity, error) {
+	path := cmd.String("workload-policy-file")
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	policy, err := api.ReadWorkloadPolicy(file)
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("%s is invalid: %s", path, err), 1)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load AWS configuration: %w", err)
+	}
+
+	return api.NewWorkloadIdentity(ctx, policy, sts.NewFromConfig(cfg))
+}
+
+// newApplicationCaches creates the caches selected by --cache-ttl, --metadata-cache-ttl and --cache-url. Each cache is nil if its TTL is 0.
+func newApplicationCaches(cmd *cli.Command) (apps, metadata *api.ApplicationCache, err error) {
+	ttl, metadataTTL := cmd.Duration("cache-ttl"), cmd.Duration("metadata-cache-ttl")
+	if ttl <= 0 && metadataTTL <= 0 {
+		return nil, nil, nil
+	}
+
+	var backend api.CacheBackend = api.NewMemoryCache()
+	if cacheURL := cmd.String("cache-url"); cacheURL != "" {
+		redis, err := api.NewRedisCache(cacheURL)
+		if err != nil {
+			return nil, nil, cli.Exit(fmt.Sprintf("--cache-url is invalid: %s", err), 1)
+		}
+		backend = redis
+	}
+
+	if ttl > 0 {
+		apps = &api.ApplicationCache{Backend: backend, TTL: ttl}
+	}
+
+	if metadataTTL > 0 {
+		metadata = &api.ApplicationCache{Backend: backend, TTL: metadataTTL}
+	}
+
+	return apps, metadata, nil
+}
+
+// newTokenValidator creates the validator selected by --token-validation.
+func newTokenValidator(ctx context.Context, cmd *cli.Command, oktaDomain string) (api.TokenValidator, error) {
+	mode, err := api.ParseTokenValidationMode(cmd.String("token-validation"))
+	if err != nil {
+		return nil, cli.Exit(err.Error(), 1)
+	}
+
+	idp, err := oidc.NewProvider(ctx, oktaDomain)
+	if err != nil {
+		return nil, fmt.Errorf("could not create OIDC provider: %w", err)
+	}
+
+	userInfo := api.UserInfoValidator{Provider: idp}
+	if mode == api.TokenValidationUserInfo {
+		return userInfo, nil
+	}
+
+	// Access tokens issued by the org authorization server can only be validated by Okta, so a custom authorization server is required.
+	issuer := strings.TrimSuffix(cmd.String("jwt-issuer"), "/")
+	if issuer == "" {
+		issuer = oktaDomain + "/oauth2/default"
+	}
+
+	if issuer == oktaDomain {
+		return nil, cli.Exit(fmt.Sprintf("--jwt-issuer must be a custom authorization server, as access tokens issued by the org authorization server cannot be validated locally; use --token-validation=%s instead", api.TokenValidationUserInfo), 1)
+	}
+
+	issuerProvider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover %s: %w", issuer, err)
+	}
+
+	var discovery struct {
+		JWKSURL string `json:"jwks_uri"`
+	}
+	if err := issuerProvider.Claims(&discovery); err != nil {
+		return nil, fmt.Errorf("could not read discovery document for %s: %w", issuer, err)
+	}
+
+	validator := api.NewJWTValidator(oidc.NewRemoteKeySet(ctx, discovery.JWKSURL), issuer, cmd.String("jwt-audience"), cmd.StringSlice("jwt-client-id"))
+	if mode == api.TokenValidationJWTWithUserInfoFallback {
+		validator.Fallback = userInfo
+	}
+
+	return validator, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// listenAndServe serves handler on addr until ctx is cancelled, at which point in-flight requests are given --shutdown-timeout to finish.
+func listenAndServe(ctx context.Context, cmd *cli.Command, addr string, handler http.Handler) error {
+	certFile, keyFile := cmd.String("tls-cert-file"), cmd.String("tls-key-file")
+	if (certFile == "") != (keyFile == "") {
+		return cli.Exit("--tls-cert-file and --tls-key-file must be specified together", 1)
+	}
+
+	minVersion, ok := tlsVersions[cmd.String("tls-min-version")]
+	if !ok {
+		return cli.Exit("--tls-min-version must be one of '1.2' or '1.3'", 1)
+	}
+
+	requestTimeout := cmd.Duration("request-timeout")
+	srv := http.Server{
+		Addr:              addr,
+		Handler:           api.TimeoutHandler(handler, requestTimeout),
+		ReadHeaderTimeout: cmd.Duration("read-header-timeout"),
+		// The write timeout must be longer than the request timeout so that the timeout response can still be written.
+		WriteTimeout: requestTimeout + 5*time.Second,
+		IdleTimeout:  cmd.Duration("idle-timeout"),
+		TLSConfig:    &tls.Config{MinVersion: minVersion},
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("listening", slog.String("addr", addr), slog.Bool("tls", certFile != ""))
+		if certFile != "" {
+			errs <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errs <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	// ctx is already cancelled, so a fresh context is needed to give in-flight requests time to finish.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cmd.Duration("shutdown-timeout"))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shut down gracefully: %w", err)
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}